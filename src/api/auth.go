@@ -0,0 +1,181 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/logging"
+	"github.com/r04922101/portto/metrics"
+)
+
+// keyHeader carries the API key of a request, which may be given as a bearer token of Authorization instead
+const keyHeader = "X-API-Key"
+
+// keyContextKey is the key of the API key of an authenticated request in its gin context
+const keyContextKey = "api_key"
+
+// keyTTL is the time a key is cached for, within which its revocation takes effect
+const keyTTL = time.Minute
+
+// Auth defines API key authentication and rate limits of requests to chain routes, with keys in Keys,
+// which authenticates no key if nil
+type Auth struct {
+	AuthConfig
+	Keys db.KeyStore
+}
+
+// cachedKey is a key looked up by its secret
+type cachedKey struct {
+	key     *db.APIKey
+	expires time.Time
+}
+
+// guard authenticates requests by their API keys, limiting the rates of keys and of IPs of requests without keys
+type guard struct {
+	Auth
+	keyLimits, ipLimits *limiters
+	usage               *usage
+
+	mu sync.Mutex
+	// keys are cached by the hashes of their secrets, while unknown secrets are not to bound the cache
+	keys map[string]cachedKey
+}
+
+func newGuard(auth Auth) *guard {
+	g := &guard{Auth: auth, keyLimits: newLimiters(), ipLimits: newLimiters(), keys: map[string]cachedKey{}}
+	if auth.Keys != nil {
+		g.usage = newUsage(auth.Keys)
+	}
+	return g
+}
+
+// close stops accounting usage of API keys, flushing pending usage
+func (g *guard) close() {
+	if g.usage != nil {
+		g.usage.close()
+	}
+}
+
+// secret returns the API key given by a request, empty if there is none
+func secret(r *http.Request) string {
+	if s := r.Header.Get(keyHeader); s != "" {
+		return s
+	}
+	if s := r.Header.Get("Authorization"); len(s) > len("Bearer ") && strings.EqualFold(s[:len("Bearer ")], "Bearer ") {
+		return s[len("Bearer "):]
+	}
+	return ""
+}
+
+// lookup returns the key of secret, which is nil if it is unknown or revoked
+func (g *guard) lookup(c *gin.Context, secret string) (*db.APIKey, error) {
+	hash := db.HashKey(secret)
+	g.mu.Lock()
+	cached, ok := g.keys[hash]
+	g.mu.Unlock()
+	if !ok || time.Now().After(cached.expires) {
+		key, err := g.Keys.GetKey(c.Request.Context(), secret)
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		cached = cachedKey{key: key, expires: time.Now().Add(keyTTL)}
+		g.mu.Lock()
+		g.keys[hash] = cached
+		g.mu.Unlock()
+	}
+	if cached.key.RevokedAt != nil {
+		return nil, nil
+	}
+	return cached.key, nil
+}
+
+// handle is a middleware rejecting a request with 401 if its API key is invalid, or missing while keys are required,
+// and with 429 if it exceeds the rate limit of its key or IP, or the daily quota of its key
+func (g *guard) handle(c *gin.Context) {
+	s := secret(c.Request)
+	var key *db.APIKey
+	if s != "" && g.Keys != nil {
+		var err error
+		if key, err = g.lookup(c, s); err != nil {
+			internalError(c, fmt.Errorf("failed to look up API key: %v", err))
+			return
+		}
+	}
+
+	if key == nil {
+		// requests with invalid keys are limited as well, which slows down guessing keys
+		if d := g.ipLimits.wait(c.ClientIP(), g.IPRate, g.IPBurst); d > 0 {
+			tooManyRequests(c, "ip", d, "rate limit of %v requests/sec exceeded, use an API key for a higher limit", g.IPRate)
+			return
+		}
+		switch {
+		case s != "":
+			unauthorized(c, "invalid or revoked API key")
+			return
+		case g.Required:
+			unauthorized(c, "API key required in the %s header", keyHeader)
+			return
+		}
+		c.Next()
+		return
+	}
+
+	limit, burst := g.KeyRate, g.KeyBurst
+	if key.Rate > 0 {
+		limit, burst = key.Rate, key.Burst
+		if burst <= 0 {
+			burst = int(math.Ceil(key.Rate))
+		}
+	}
+	if d := g.keyLimits.wait(strconv.FormatUint(uint64(key.ID), 10), limit, burst); d > 0 {
+		tooManyRequests(c, "key", d, "rate limit of %v requests/sec of API key exceeded", limit)
+		return
+	}
+	d, err := g.usage.take(c.Request.Context(), key)
+	if err != nil {
+		internalError(c, fmt.Errorf("failed to get usage of API key %d: %v", key.ID, err))
+		return
+	}
+	if d > 0 {
+		tooManyRequests(c, "quota", d, "daily quota of %d requests of API key exceeded", key.DailyQuota)
+		return
+	}
+
+	ctx := c.Request.Context()
+	c.Request = c.Request.WithContext(logging.NewContext(ctx, logging.FromContext(ctx).New("key_id", key.ID)))
+	c.Set(keyContextKey, key)
+	c.Next()
+}
+
+// requireKey is a middleware rejecting a request with 401 unless the guard authenticated its API key,
+// e.g. of a route writing data, which anonymous requests may not use even if they may read
+func requireKey(c *gin.Context) {
+	if _, ok := c.Get(keyContextKey); !ok {
+		unauthorized(c, "API key required in the %s header", keyHeader)
+		return
+	}
+	c.Next()
+}
+
+// unauthorized aborts a request without a valid API key
+func unauthorized(c *gin.Context, format string, args ...interface{}) {
+	c.Header("WWW-Authenticate", "Bearer")
+	abort(c, http.StatusUnauthorized, fmt.Errorf(format, args...))
+}
+
+// tooManyRequests aborts a request exceeding a limit, which allows another request after d
+func tooManyRequests(c *gin.Context, limit string, d time.Duration, format string, args ...interface{}) {
+	metrics.RateLimited.WithLabelValues(limit).Inc()
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	abort(c, http.StatusTooManyRequests, fmt.Errorf(format, args...))
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/r04922101/portto/db"
+)
+
+// memoryKeys keeps API keys by their secrets in memory
+type memoryKeys struct {
+	mu     sync.Mutex
+	keys   map[string]*db.APIKey
+	usages map[uint]int64
+}
+
+func newMemoryKeys() *memoryKeys {
+	return &memoryKeys{keys: map[string]*db.APIKey{}, usages: map[uint]int64{}}
+}
+
+func (m *memoryKeys) CreateKey(ctx context.Context, key *db.APIKey) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key.ID = uint(len(m.keys) + 1)
+	secret := "portto_" + key.Name
+	m.keys[secret] = key
+	return secret, nil
+}
+
+func (m *memoryKeys) GetKey(ctx context.Context, secret string) (*db.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[secret]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	copied := *key
+	return &copied, nil
+}
+
+func (m *memoryKeys) ListKeys(ctx context.Context) ([]*db.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ret []*db.APIKey
+	for _, k := range m.keys {
+		ret = append(ret, k)
+	}
+	return ret, nil
+}
+
+func (m *memoryKeys) RevokeKey(ctx context.Context, id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.keys {
+		if k.ID == id {
+			now := time.Now()
+			k.RevokedAt = &now
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (m *memoryKeys) AddUsage(ctx context.Context, usages []db.KeyUsage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range usages {
+		m.usages[u.KeyID] += u.Requests
+	}
+	return nil
+}
+
+func (m *memoryKeys) GetUsage(ctx context.Context, id uint, day string) (*db.KeyUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &db.KeyUsage{KeyID: id, Day: day, Requests: m.usages[id]}, nil
+}
+
+// newGuardedRouter creates a router of a read route and a write route guarded by auth, whose guard is closed when the test ends
+func newGuardedRouter(t *testing.T, auth Auth) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(respondError)
+	guard := newGuard(auth)
+	t.Cleanup(guard.close)
+	g := r.Group("", guard.handle)
+	g.GET("/blocks", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	g.PUT("/abis/:address", requireKey, func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return r
+}
+
+// serve serves a request of method and path to r with headers given as name and value pairs
+func serve(r http.Handler, method, path string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequireKey(t *testing.T) {
+	keys := newMemoryKeys()
+	secret, _ := keys.CreateKey(context.Background(), &db.APIKey{Name: "dapp"})
+	revoked, _ := keys.CreateKey(context.Background(), &db.APIKey{Name: "revoked"})
+	if err := keys.RevokeKey(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	r := newGuardedRouter(t, Auth{Keys: keys})
+
+	tests := []struct {
+		name    string
+		method  string
+		headers []string
+		status  int
+	}{
+		{"anonymous read", http.MethodGet, nil, http.StatusOK},
+		{"anonymous write", http.MethodPut, nil, http.StatusUnauthorized},
+		{"write with unknown key", http.MethodPut, []string{keyHeader, "portto_unknown"}, http.StatusUnauthorized},
+		{"write with revoked key", http.MethodPut, []string{keyHeader, revoked}, http.StatusUnauthorized},
+		{"write with key", http.MethodPut, []string{keyHeader, secret}, http.StatusNoContent},
+		{"write with bearer token", http.MethodPut, []string{"Authorization", "Bearer " + secret}, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/blocks"
+			if tt.method == http.MethodPut {
+				path = "/abis/0xae13d989daC2f0dEbFf460aC112a837C89BAa7cd"
+			}
+			w := serve(r, tt.method, path, tt.headers...)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestGuardRequired(t *testing.T) {
+	keys := newMemoryKeys()
+	secret, _ := keys.CreateKey(context.Background(), &db.APIKey{Name: "dapp"})
+	r := newGuardedRouter(t, Auth{AuthConfig: AuthConfig{Required: true}, Keys: keys})
+
+	if w := serve(r, http.MethodGet, "/blocks"); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous read = %d, want 401", w.Code)
+	}
+	if w := serve(r, http.MethodGet, "/blocks", keyHeader, secret); w.Code != http.StatusOK {
+		t.Errorf("read with key = %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	keys := newMemoryKeys()
+	defaults, _ := keys.CreateKey(context.Background(), &db.APIKey{Name: "defaults"})
+	own, _ := keys.CreateKey(context.Background(), &db.APIKey{Name: "own", Rate: 0.5})
+	r := newGuardedRouter(t, Auth{AuthConfig: AuthConfig{KeyRate: 0.5, KeyBurst: 3, IPRate: 0.5, IPBurst: 2}, Keys: keys})
+
+	tests := []struct {
+		name    string
+		headers []string
+		allowed int
+		limit   string
+	}{
+		{"anonymous", nil, 2, "ip"},
+		// requests with keys are not limited by the IP, whose bucket is empty
+		{"default limits", []string{keyHeader, defaults}, 3, "key"},
+		// the burst of a key with its own rate is the rate rounded up
+		{"own limits", []string{keyHeader, own}, 1, "key"},
+	}
+	for _, tt := range tests {
+		for i := 0; i < tt.allowed; i++ {
+			if w := serve(r, http.MethodGet, "/blocks", tt.headers...); w.Code != http.StatusOK {
+				t.Errorf("%s: request %d = %d, want 200", tt.name, i, w.Code)
+			}
+		}
+		w := serve(r, http.MethodGet, "/blocks", tt.headers...)
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: request over the limit = %d, want 429", tt.name, w.Code)
+			continue
+		}
+		// a token is refilled in 2 seconds
+		if got := w.Header().Get("Retry-After"); got != "2" {
+			t.Errorf("%s: Retry-After = %q, want 2", tt.name, got)
+		}
+		if !strings.Contains(w.Body.String(), "rate_limited") {
+			t.Errorf("%s: body = %s", tt.name, w.Body)
+		}
+	}
+
+	// invalid keys are limited by the IP before they are rejected
+	if w := serve(r, http.MethodGet, "/blocks", keyHeader, "portto_unknown"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("request with an unknown key = %d, want 429", w.Code)
+	}
+}
+
+func TestDailyQuota(t *testing.T) {
+	keys := newMemoryKeys()
+	secret, _ := keys.CreateKey(context.Background(), &db.APIKey{Name: "dapp", DailyQuota: 3})
+	// a request was made today before the server started
+	keys.usages[1] = 1
+	r := newGuardedRouter(t, Auth{Keys: keys})
+
+	for i := 0; i < 2; i++ {
+		if w := serve(r, http.MethodGet, "/blocks", keyHeader, secret); w.Code != http.StatusOK {
+			t.Errorf("request %d = %d, want 200", i, w.Code)
+		}
+	}
+	w := serve(r, http.MethodGet, "/blocks", keyHeader, secret)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the quota = %d, want 429", w.Code)
+	}
+	retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retry <= 0 || retry > 24*60*60 {
+		t.Errorf("Retry-After = %q, want the seconds until the next UTC day", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestKeyCache(t *testing.T) {
+	keys := newMemoryKeys()
+	secret, _ := keys.CreateKey(context.Background(), &db.APIKey{Name: "dapp"})
+	g := newGuard(Auth{Keys: keys})
+	defer g.close()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/blocks", nil)
+	lookup := func() *db.APIKey {
+		t.Helper()
+		key, err := g.lookup(c, secret)
+		if err != nil {
+			t.Fatalf("failed to look up key: %v", err)
+		}
+		return key
+	}
+
+	if key := lookup(); key == nil || key.ID != 1 {
+		t.Fatalf("key = %+v, want key 1", key)
+	}
+	if key, err := g.lookup(c, "portto_unknown"); key != nil || err != nil {
+		t.Errorf("unknown key = %+v, %v", key, err)
+	}
+	// the revocation takes effect once the cached key expires
+	if err := keys.RevokeKey(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if key := lookup(); key == nil {
+		t.Error("cached key is revoked before it expires")
+	}
+	g.keys[db.HashKey(secret)] = cachedKey{key: g.keys[db.HashKey(secret)].key, expires: time.Now().Add(-time.Second)}
+	if key := lookup(); key != nil {
+		t.Errorf("revoked key = %+v", key)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	ctx := context.Background()
+	keys := newMemoryKeys()
+	key := &db.APIKey{ID: 1}
+	today := db.UsageDay(time.Now())
+	// requests of yesterday are pending when the day rolls over
+	u := &usage{store: keys, day: "2000-01-01", counts: map[uint]*keyCount{1: {requests: 5, pending: 2}}}
+
+	for i := 0; i < 3; i++ {
+		if d, err := u.take(ctx, key); d != 0 || err != nil {
+			t.Fatalf("take = %v, %v", d, err)
+		}
+	}
+	if u.day != today || len(u.pending) != 1 || u.pending[0].Day != "2000-01-01" || u.pending[0].Requests != 2 {
+		t.Errorf("day = %s, pending = %+v, want 2 requests of 2000-01-01 pending", u.day, u.pending)
+	}
+	u.flush(ctx)
+	if keys.usages[1] != 5 || len(u.pending) != 0 || u.counts[1].pending != 0 || u.counts[1].requests != 3 {
+		t.Errorf("usage = %d, pending = %+v, count = %+v, want 5 added", keys.usages[1], u.pending, u.counts[1])
+	}
+	// nothing is added without requests
+	u.flush(ctx)
+	if keys.usages[1] != 5 {
+		t.Errorf("usage = %d, want 5", keys.usages[1])
+	}
+
+	// requests failed to be added are added by the next flush
+	u.take(ctx, key)
+	u.store = failingKeys{keys}
+	u.flush(ctx)
+	if len(u.pending) != 1 || u.pending[0].Requests != 1 {
+		t.Errorf("pending = %+v, want 1 request", u.pending)
+	}
+	u.store = keys
+	u.flush(ctx)
+	if keys.usages[1] != 6 || len(u.pending) != 0 {
+		t.Errorf("usage = %d, pending = %+v, want 6 added", keys.usages[1], u.pending)
+	}
+}
+
+// failingKeys fails to add usage
+type failingKeys struct {
+	*memoryKeys
+}
+
+func (failingKeys) AddUsage(ctx context.Context, usages []db.KeyUsage) error {
+	return errors.New("connection refused")
+}
+
+func TestUsageClose(t *testing.T) {
+	ctx := context.Background()
+	keys := newMemoryKeys()
+	u := newUsage(keys)
+	for i := 0; i < 2; i++ {
+		if d, err := u.take(ctx, &db.APIKey{ID: 1}); d != 0 || err != nil {
+			t.Fatalf("take = %v, %v", d, err)
+		}
+	}
+	// pending usage is flushed on close, which may be called again
+	u.close()
+	u.close()
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	if keys.usages[1] != 2 {
+		t.Errorf("usage = %d, want 2 flushed on close", keys.usages[1])
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/r04922101/portto/cache"
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/logging"
+	"github.com/r04922101/portto/metrics"
+)
+
+// immutable is the Cache-Control of finalized data, which never changes
+const immutable = "public, max-age=31536000, immutable"
+
+// freshness of cached responses, which prefixes their bodies in caches
+const (
+	// unfinalized data, e.g. of blocks near the head, may be reorganized
+	unfinalized byte = iota + 1
+	finalized
+)
+
+// responseCache caches JSON responses of blocks and transactions of a chain,
+// which are finalized once the latest indexed block is Finality blocks ahead of them
+type responseCache struct {
+	CacheConfig
+	// cache is nil if responses are not cached, which still have ETags
+	cache cache.Cache
+	store db.Store
+
+	mu        sync.Mutex
+	head      uint64
+	checkedAt time.Time
+}
+
+// isFinalized returns if data of block n is finalized, with the latest indexed block cached for a second
+func (r *responseCache) isFinalized(ctx context.Context, n uint64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checkedAt) > time.Second {
+		head, err := r.store.LatestNum(ctx)
+		if err != nil {
+			return false, err
+		}
+		r.head, r.checkedAt = head, time.Now()
+	}
+	return n+r.Finality <= r.head, nil
+}
+
+// serve writes the cached response of key, returning false if there is none
+func (r *responseCache) serve(c *gin.Context, kind, key string) bool {
+	if r.cache == nil {
+		return false
+	}
+	v, ttl, ok, err := r.cache.Get(c.Request.Context(), key)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Warn("failed to get cached response", "key", key, "err", err)
+	}
+	if !ok || len(v) == 0 {
+		metrics.CacheLookups.WithLabelValues(kind, "miss").Inc()
+		return false
+	}
+	metrics.CacheLookups.WithLabelValues(kind, "hit").Inc()
+	cacheControl := immutable
+	if v[0] != finalized {
+		cacheControl = fmt.Sprintf("public, max-age=%d", int(ttl.Seconds()))
+	}
+	write(c, cacheControl, v[1:])
+	return true
+}
+
+// respondUncached writes v in JSON without caching it, e.g. a pending transaction or a block out of the canonical chain
+func (r *responseCache) respondUncached(c *gin.Context, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		internalError(c, fmt.Errorf("failed to marshal response: %v", err))
+		return
+	}
+	write(c, "no-cache", body)
+}
+
+// respond writes v in JSON, caching it by key as a record of the canonical block numbered n
+func (r *responseCache) respond(c *gin.Context, key string, n uint64, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		internalError(c, fmt.Errorf("failed to marshal response: %v", err))
+		return
+	}
+
+	ctx := c.Request.Context()
+	freshness, ttl, cacheControl := unfinalized, r.HeadTTL, fmt.Sprintf("public, max-age=%d", int(r.HeadTTL.Seconds()))
+	if ok, err := r.isFinalized(ctx, n); err != nil {
+		logging.FromContext(ctx).Warn("failed to check finality", "block", n, "err", err)
+	} else if ok {
+		freshness, ttl, cacheControl = finalized, r.FinalizedTTL, immutable
+	}
+	if r.cache != nil {
+		if err := r.cache.Set(ctx, key, append([]byte{freshness}, body...), ttl); err != nil {
+			logging.FromContext(ctx).Warn("failed to cache response", "key", key, "err", err)
+		}
+	}
+	write(c, cacheControl, body)
+}
+
+// write writes a JSON body with its strong ETag, or 304 if the request has a matching If-None-Match
+func write(c *gin.Context, cacheControl string, body []byte) {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", cacheControl)
+	if matchETag(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// matchETag returns if an If-None-Match header matches etag, which compares ETags weakly as RFC 7232 tells
+func matchETag(ifNoneMatch, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
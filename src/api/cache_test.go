@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/r04922101/portto/cache"
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+	"github.com/r04922101/portto/metrics"
+)
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{"", false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{"*", true},
+		{`"xyz"`, false},
+	}
+	for _, tt := range tests {
+		if got := matchETag(tt.ifNoneMatch, `"abc"`); got != tt.want {
+			t.Errorf("matchETag(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+		}
+	}
+}
+
+func TestCachedResponses(t *testing.T) {
+	ctx := context.Background()
+	memory := db.NewMemoryStore(chainID)
+	responses := cache.NewLRU(100)
+	store := cache.NewInvalidatingStore(memory, responses)
+	for n := uint64(1); n <= 5; n++ {
+		if err := store.WriteBlock(ctx, newBlock(n, "", newTx(n, "", alice, bob, 1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the RPC endpoint serves the fork replacing block 5
+	client := eth.NewMemoryClient(chainID)
+	client.AddBlock(newBlock(5, "fork"))
+	r := New(Options{
+		Cache:   responses,
+		Caching: CacheConfig{Finality: 2, HeadTTL: time.Minute, FinalizedTTL: time.Hour},
+	}, newTestChain(t, store, client))
+	hits := metrics.CacheLookups.WithLabelValues("block", "hit")
+
+	tests := []struct {
+		path         string
+		cacheControl string
+	}{
+		{"/blocks/" + hash("block", 1, ""), immutable},
+		{"/transaction/" + hash("tx", 3, ""), immutable},
+		{"/blocks/" + hash("block", 5, ""), "public, max-age=60"},
+		{"/transaction/" + hash("tx", 5, ""), "public, max-age=60"},
+	}
+	for _, tt := range tests {
+		w := serve(r, http.MethodGet, tt.path)
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || etag == "" || w.Header().Get("Cache-Control") != tt.cacheControl {
+			t.Errorf("GET %s = %d with ETag %q and Cache-Control %q, want %q", tt.path, w.Code, etag, w.Header().Get("Cache-Control"), tt.cacheControl)
+			continue
+		}
+		body := w.Body.String()
+
+		before := testutil.ToFloat64(hits)
+		cached := serve(r, http.MethodGet, tt.path)
+		if cached.Code != http.StatusOK || cached.Body.String() != body || cached.Header().Get("ETag") != etag {
+			t.Errorf("cached GET %s = %d with ETag %q, want the same response", tt.path, cached.Code, cached.Header().Get("ETag"))
+		}
+		if tt.path[1] == 'b' && testutil.ToFloat64(hits)-before != 1 {
+			t.Errorf("GET %s is not a cache hit", tt.path)
+		}
+
+		for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag} {
+			w := serve(r, http.MethodGet, tt.path, "If-None-Match", ifNoneMatch)
+			if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+				t.Errorf("GET %s with If-None-Match %s = %d, %q", tt.path, ifNoneMatch, w.Code, w.Body)
+			}
+		}
+		if w := serve(r, http.MethodGet, tt.path, "If-None-Match", `"other"`); w.Code != http.StatusOK {
+			t.Errorf("GET %s with another ETag = %d, want 200", tt.path, w.Code)
+		}
+	}
+
+	// cached responses are served even if the DB loses them, until a reorg invalidates them
+	if err := memory.DeleteRange(ctx, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, http.MethodGet, "/blocks/"+hash("block", 1, "")); w.Code != http.StatusOK {
+		t.Errorf("GET cached block 1 = %d, want 200", w.Code)
+	}
+	if err := store.WriteBlock(ctx, newBlock(5, "fork")); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/blocks/" + hash("block", 5, ""), "/transaction/" + hash("tx", 5, "")} {
+		if w := serve(r, http.MethodGet, path); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s of a reorganized block = %d, want 404", path, w.Code)
+		}
+	}
+	if w := serve(r, http.MethodGet, "/blocks/"+hash("block", 5, "fork")); w.Code != http.StatusOK {
+		t.Errorf("GET block 5 of the fork = %d, want 200", w.Code)
+	}
+}
+
+func TestPendingTransactionNotCached(t *testing.T) {
+	ctx := context.Background()
+	responses := cache.NewLRU(100)
+	client := eth.NewMemoryClient(chainID)
+	tx := newTx(1, "pending", alice, bob, 1)
+	client.AddPendingTransaction(&tx)
+	r := New(Options{
+		Cache:   responses,
+		Caching: CacheConfig{Finality: 2, HeadTTL: time.Minute, FinalizedTTL: time.Hour},
+	}, newTestChain(t, db.NewMemoryStore(chainID), client))
+
+	path := "/transaction/" + tx.Hash
+	w := serve(r, http.MethodGet, path)
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("GET %s = %d with Cache-Control %q, want no-cache", path, w.Code, w.Header().Get("Cache-Control"))
+	}
+	if _, _, ok, _ := responses.Get(ctx, cache.TransactionKey(chainID, tx.Hash)); ok {
+		t.Errorf("pending transaction %s is cached", tx.Hash)
+	}
+}
+
+func TestFetchedBlocks(t *testing.T) {
+	ctx := context.Background()
+	responses := cache.NewLRU(100)
+	store := db.NewMemoryStore(chainID)
+	client := eth.NewMemoryClient(chainID)
+	// the orphaned block 1 is replaced by the canonical one on the RPC endpoint
+	client.AddBlock(newBlock(1, "orphan"))
+	for n := uint64(0); n <= 3; n++ {
+		client.AddBlock(newBlock(n, ""))
+	}
+	chain := newTestChain(t, store, client)
+	r := New(Options{
+		Cache:   responses,
+		Caching: CacheConfig{Finality: 2, HeadTTL: time.Minute, FinalizedTTL: time.Hour},
+	}, chain)
+
+	tests := []struct {
+		block        *eth.Block
+		cacheControl string
+		written      bool
+	}{
+		{newBlock(0, ""), "public, max-age=60", true},
+		{newBlock(1, "orphan"), "no-cache", false},
+	}
+	for _, tt := range tests {
+		path := "/blocks/" + tt.block.Hash
+		w := serve(r, http.MethodGet, path)
+		if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != tt.cacheControl {
+			t.Errorf("GET %s = %d with Cache-Control %q, want %q", path, w.Code, w.Header().Get("Cache-Control"), tt.cacheControl)
+		}
+		if _, _, ok, _ := responses.Get(ctx, cache.BlockKey(chainID, tt.block.Hash)); ok != tt.written {
+			t.Errorf("block %s is cached = %v, want %v", tt.block.Hash, ok, tt.written)
+		}
+	}
+
+	// blocks are written in background
+	for i := 0; i < 100; i++ {
+		if _, err := store.GetBlockByNumber(ctx, 0); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if b, err := store.GetBlockByNumber(ctx, 0); err != nil || b.Hash != hash("block", 0, "") {
+		t.Errorf("genesis block = %+v, %v, want it written", b, err)
+	}
+	if b, err := store.GetBlockByNumber(ctx, 1); err == nil {
+		t.Errorf("orphaned block %s is written", b.Hash)
+	}
+}
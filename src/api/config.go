@@ -1,11 +1,66 @@
 package api
 
+import (
+	"time"
+
+	"github.com/r04922101/portto/cache"
+)
+
 // Config defines the config for starting a api server
 type Config struct {
+	SQLDriver   string // mysql, postgres or sqlite
 	SQLHost     string
 	SQLDB       string
 	SQLUser     string
 	SQLPassword string
 	SQLPort     string
+	// SQLReplicas are hosts of read replicas, optionally with ports, sharing the other SQL settings
+	SQLReplicas []string
+	// MaxReplicaLag is the # of blocks replicas may lag behind the primary to serve reads of recent data
+	MaxReplicaLag uint64
+	// Chains are indexed and served side by side, the first one by routes without a chain ID
+	Chains []ChainConfig
+	// ABIDir is a directory of `<address>.json` ABI files to register, skipped if empty
+	ABIDir string
+	// Signatures is a file of method signatures to decode inputs with, skipped if empty
+	Signatures string
+	// TrackBalances enables the indexer stage storing native balances of touched addresses
+	TrackBalances bool
+	// TraceInternal enables the indexer stage storing traced internal transactions
+	TraceInternal bool
+	// StartBlock indexes blocks of the only chain from StartBlock in background at startup if set
+	StartBlock uint64
+	// TrustedProxies are IPs or CIDRs of proxies, whose X-Forwarded-For headers give client IPs, none if empty
+	TrustedProxies []string
+	Auth           AuthConfig
+	// Cache are caches of block and transaction responses, none if empty
+	Cache   cache.Config
+	Caching CacheConfig
+}
+
+// CacheConfig defines how long block and transaction responses are cached
+type CacheConfig struct {
+	// Finality is the # of blocks the latest indexed block is ahead of finalized blocks, whose data is immutable
+	Finality uint64
+	// HeadTTL and FinalizedTTL are the times responses of data not finalized and finalized are cached for
+	HeadTTL      time.Duration
+	FinalizedTTL time.Duration
+}
+
+// AuthConfig defines API key authentication and rate limits of requests per second, which are disabled if 0
+type AuthConfig struct {
+	// Required rejects requests without a valid API key, which are limited by client IP otherwise
+	Required bool
+	// KeyRate and KeyBurst limit requests with each API key, unless the key has its own limits
+	KeyRate  float64
+	KeyBurst int
+	// IPRate and IPBurst limit requests without a valid API key from each client IP
+	IPRate  float64
+	IPBurst int
+}
+
+// ChainConfig defines a chain to index and serve
+type ChainConfig struct {
+	ID          uint64
 	RPCEndpoint string
 }
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/r04922101/portto/eth"
+	"github.com/r04922101/portto/logging"
+)
+
+// statusClientClosedRequest is the status of requests canceled by their clients, as nginx logs them
+const statusClientClosedRequest = 499
+
+// errorCodes maps statuses of error responses to their codes
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusNotFound:            "not_found",
+	http.StatusTooManyRequests:     "rate_limited",
+	statusClientClosedRequest:      "client_closed_request",
+	http.StatusInternalServerError: "internal_error",
+	http.StatusBadGateway:          "upstream_error",
+	http.StatusServiceUnavailable:  "upstream_unavailable",
+}
+
+// errorBody defines the body of error responses
+type errorBody struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// respondError is a middleware writing the last error of a request as an error response,
+// hiding messages of server side errors, which may contain DB or RPC endpoint details, behind logs
+func respondError(c *gin.Context) {
+	// execute pending handlers
+	c.Next()
+
+	err := c.Errors.Last()
+	if err == nil || c.Writer.Written() {
+		return
+	}
+	status := c.Writer.Status()
+	body := errorBody{Code: errorCodes[status], Message: err.Error(), Details: err.Meta}
+	if body.Code == "" {
+		body.Code = http.StatusText(status)
+	}
+	if status >= http.StatusInternalServerError {
+		logging.FromContext(c.Request.Context()).Error("request failed", "status", status, "err", err.Err)
+		body.Message, body.Details = http.StatusText(status), nil
+	}
+	c.JSON(status, body)
+}
+
+// abort stops handling a request with err, which respondError writes with status
+func abort(c *gin.Context, status int, err error) *gin.Error {
+	// the status is written along with the body by respondError
+	c.Status(status)
+	c.Abort()
+	return c.Error(err)
+}
+
+// badParam aborts a request with a malformed parameter, describing the expected value
+func badParam(c *gin.Context, name, value, expected string) {
+	abort(c, http.StatusBadRequest, fmt.Errorf("bad %s parameter, expected %s", name, expected)).
+		SetMeta(gin.H{"parameter": name, "value": value})
+}
+
+// notFound aborts a request for a record, which is neither indexed nor on chain
+func notFound(c *gin.Context, format string, args ...interface{}) {
+	abort(c, http.StatusNotFound, fmt.Errorf(format, args...))
+}
+
+// internalError aborts a request failed by the server, e.g. by the DB
+func internalError(c *gin.Context, err error) {
+	abort(c, http.StatusInternalServerError, err)
+}
+
+// upstreamError aborts a request for a record failed by the RPC endpoint, with 404 if the record is not on chain,
+// 503 if the endpoint is unavailable, and 502 otherwise, unless the request is canceled, e.g. while waiting for a shared fetch
+func upstreamError(c *gin.Context, record string, err error) {
+	switch {
+	case c.Request.Context().Err() != nil:
+		abort(c, statusClientClosedRequest, c.Request.Context().Err())
+	case errors.Is(err, eth.ErrNotFound):
+		abort(c, http.StatusNotFound, fmt.Errorf("%s %w", record, eth.ErrNotFound))
+	case errors.Is(err, eth.ErrUnavailable):
+		abort(c, http.StatusServiceUnavailable, err)
+	default:
+		abort(c, http.StatusBadGateway, err)
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/r04922101/portto/eth"
+	"github.com/r04922101/portto/logging"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// fetchTimeout limits a fetch shared by requests, which outlives the request starting it
+	fetchTimeout = 30 * time.Second
+	// notFoundTTL is how long hashes not found on chain are not fetched again, e.g. ones of blocks not propagated yet
+	notFoundTTL = 5 * time.Second
+	// maxNotFound bounds the # of hashes not found kept at once
+	maxNotFound = 10000
+)
+
+// fetched is the response of a block or transaction fetched from the RPC endpoint
+type fetched struct {
+	// num is the number of the block, which is known if found is set
+	num uint64
+	// found is whether the record is in the canonical block numbered num, which is cached only if it is
+	found bool
+	body  json.RawMessage
+}
+
+// fetcher coalesces fetches of the same block or transaction from the RPC endpoint,
+// so that a burst of requests of a record missing in the DB makes a fetch and a write
+type fetcher struct {
+	group singleflight.Group
+
+	mu sync.Mutex
+	// notFound are keys of records not found on chain, with the time they expire
+	notFound map[string]time.Time
+	// writing are fetched records being written to the DB, which are served until they are written
+	writing map[string]fetched
+}
+
+func newFetcher() *fetcher {
+	return &fetcher{notFound: map[string]time.Time{}, writing: map[string]fetched{}}
+}
+
+// do returns the record of key fetched by fetch, which is shared by concurrent calls of the same key,
+// and returns eth.ErrNotFound without fetching for notFoundTTL after it does
+func (f *fetcher) do(ctx context.Context, key string, fetch func(ctx context.Context) (fetched, error)) (fetched, error) {
+	f.mu.Lock()
+	if expires, ok := f.notFound[key]; ok && time.Now().Before(expires) {
+		f.mu.Unlock()
+		return fetched{}, eth.ErrNotFound
+	}
+	if ret, ok := f.writing[key]; ok {
+		f.mu.Unlock()
+		return ret, nil
+	}
+	f.mu.Unlock()
+
+	ch := f.group.DoChan(key, func() (interface{}, error) {
+		// the fetch is neither canceled with the request starting it, nor traced and logged apart from it
+		fctx := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+		fctx = logging.NewContext(fctx, logging.FromContext(ctx))
+		fctx, cancel := context.WithTimeout(fctx, fetchTimeout)
+		defer cancel()
+
+		ret, err := fetch(fctx)
+		if errors.Is(err, eth.ErrNotFound) {
+			f.addNotFound(key)
+		}
+		return ret, err
+	})
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return fetched{}, r.Err
+		}
+		return r.Val.(fetched), nil
+	case <-ctx.Done():
+		return fetched{}, ctx.Err()
+	}
+}
+
+// addNotFound keeps key not found, dropping expired keys once there are maxNotFound keys
+func (f *fetcher) addNotFound(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	if len(f.notFound) >= maxNotFound {
+		for k, expires := range f.notFound {
+			if now.After(expires) {
+				delete(f.notFound, k)
+			}
+		}
+		if len(f.notFound) >= maxNotFound {
+			return
+		}
+	}
+	f.notFound[key] = now.Add(notFoundTTL)
+}
+
+// write runs write of the fetched record of key in background, serving the record to requests of key until it is done
+func (f *fetcher) write(key string, record fetched, write func()) {
+	f.mu.Lock()
+	f.writing[key] = record
+	f.mu.Unlock()
+	go func() {
+		defer func() {
+			f.mu.Lock()
+			delete(f.writing, key)
+			f.mu.Unlock()
+		}()
+		write()
+	}()
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r04922101/portto/eth"
+)
+
+func TestFetcherCoalesces(t *testing.T) {
+	f := newFetcher()
+	var calls int32
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) (fetched, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return fetched{num: 1, body: json.RawMessage(`{}`)}, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ret, err := f.do(context.Background(), "block", fetch)
+			if err == nil && ret.num != 1 {
+				err = errors.New("unexpected record")
+			}
+			errs <- err
+		}()
+	}
+	// lets every call wait on the fetch before it returns
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("failed to fetch: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("# of fetches = %d, want 1", calls)
+	}
+}
+
+func TestFetcherNotFound(t *testing.T) {
+	f := newFetcher()
+	var calls int
+	fetch := func(ctx context.Context) (fetched, error) {
+		calls++
+		return fetched{}, eth.ErrNotFound
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := f.do(context.Background(), "tx", fetch); !errors.Is(err, eth.ErrNotFound) {
+			t.Errorf("err = %v, want ErrNotFound", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("# of fetches = %d, want 1 within the TTL", calls)
+	}
+
+	// a key fetched again after the TTL
+	f.notFound["tx"] = time.Now().Add(-time.Second)
+	f.do(context.Background(), "tx", fetch)
+	if calls != 2 {
+		t.Errorf("# of fetches = %d, want 2 after the TTL", calls)
+	}
+}
+
+func TestFetcherWrite(t *testing.T) {
+	f := newFetcher()
+	record := fetched{num: 7, body: json.RawMessage(`{"num":7}`)}
+	release, done := make(chan struct{}), make(chan struct{})
+	f.write("block", record, func() {
+		<-release
+		close(done)
+	})
+
+	fetch := func(ctx context.Context) (fetched, error) {
+		t.Error("fetched a record being written")
+		return fetched{}, nil
+	}
+	if ret, err := f.do(context.Background(), "block", fetch); err != nil || ret.num != 7 {
+		t.Errorf("record being written = %+v, %v", ret, err)
+	}
+
+	close(release)
+	<-done
+	// the record is removed right after the write returns
+	for i := 0; i < 100; i++ {
+		f.mu.Lock()
+		_, ok := f.writing["block"]
+		f.mu.Unlock()
+		if !ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("record is served after it is written")
+}
+
+func TestFetcherCanceled(t *testing.T) {
+	f := newFetcher()
+	release, done := make(chan struct{}), make(chan error, 1)
+	fetch := func(ctx context.Context) (fetched, error) {
+		<-release
+		// the shared fetch is not canceled with the request starting it
+		done <- ctx.Err()
+		return fetched{num: 1}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := f.do(ctx, "block", fetch); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("shared fetch is canceled: %v", err)
+	}
+}
@@ -2,15 +2,25 @@ package api
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"math"
+	"math/big"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gin-gonic/gin"
+	"github.com/r04922101/portto/cache"
+	"github.com/r04922101/portto/db"
 	"github.com/r04922101/portto/eth"
 	"github.com/r04922101/portto/indexer"
-	"gorm.io/gorm"
+	"github.com/r04922101/portto/logging"
+	"github.com/r04922101/portto/registry"
 )
 
 const (
@@ -18,38 +28,82 @@ const (
 )
 
 type serviceImpl struct {
-	db        *gorm.DB
+	store     db.Store
 	ethClient eth.Client
 	indexer   indexer.Indexer
+	registry  registry.Registry
+	responses *responseCache
+	fetches   *fetcher
 }
 
-func (s *serviceImpl) getBlocks(c *gin.Context) {
+// parseHash parses a path parameter of a block or transaction hash in hex, returning it in lower case
+func parseHash(c *gin.Context, name string) (string, bool) {
+	h := c.Param(name)
+	if b, err := hexutil.Decode(h); err != nil || len(b) != common.HashLength {
+		badParam(c, name, h, "a 32-byte hash in hex with 0x prefix")
+		return "", false
+	}
+	return common.HexToHash(h).Hex(), true
+}
+
+// parseAddress parses an address in hex given by a parameter, returning it in checksum case
+func parseAddress(c *gin.Context, name, value string) (string, bool) {
+	if !common.IsHexAddress(value) {
+		badParam(c, name, value, "a 20-byte address in hex")
+		return "", false
+	}
+	return common.HexToAddress(value).Hex(), true
+}
+
+// parseLimit parses the limit query parameter, defaultLimit if it is absent
+func parseLimit(c *gin.Context) (int, bool) {
 	l := c.Query("limit")
-	limit, _ := strconv.Atoi(l)
-	if limit <= 0 {
-		limit = defaultLimit
+	if l == "" {
+		return defaultLimit, true
+	}
+	limit, err := strconv.Atoi(l)
+	if err != nil || limit <= 0 {
+		badParam(c, "limit", l, "a positive integer")
+		return 0, false
+	}
+	return limit, true
+}
+
+func (s *serviceImpl) getChain(c *gin.Context) {
+	c.JSON(http.StatusOK, s.ethClient.Chain())
+}
+
+func (s *serviceImpl) getBlocks(c *gin.Context) {
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
 	}
 
 	// get blocks from DB
-	var blocks []*eth.Block
-	if err := s.db.Debug().Model(&eth.Block{}).Preload("Transactions").
-		Order("num desc").Limit(limit).
-		Find(&blocks).Error; err != nil {
-		log.Printf("failed to find recent %d blocks from DB: %v", limit, err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	blocks, err := s.store.ListBlocks(c.Request.Context(), limit)
+	if err != nil {
+		internalError(c, err)
 		return
 	}
 	for _, b := range blocks {
 		toRepsonseBlock(b)
 	}
+	// older blocks may have been pruned
+	earliest, err := s.store.EarliestNum(c.Request.Context())
+	if err != nil {
+		internalError(c, err)
+		return
+	}
 
 	// index new blocks to DB in background
+	logger := logging.FromContext(c.Request.Context()).New("chain", s.store.ChainID())
 	go func(blocks []*eth.Block) {
 		// get latest number from DB
 		var start uint64
 		if len(blocks) == 0 {
 			curNum, err := s.ethClient.GetCurrentNumber(context.Background())
 			if err != nil {
+				logger.Warn("failed to get current block number", "err", err)
 				return
 			}
 			start = curNum
@@ -59,75 +113,367 @@ func (s *serviceImpl) getBlocks(c *gin.Context) {
 
 		// index new blocks into DB
 		if _, err := s.indexer.IndexRecentBlocks(context.Background(), start); err != nil {
-			log.Printf("failed to index recent blocks to DB: %v", err)
+			logger.Error("failed to index recent blocks", "from", start, "err", err)
 		}
 	}(blocks)
 
-	c.JSON(http.StatusOK, gin.H{"blocks": blocks})
+	c.JSON(http.StatusOK, gin.H{"blocks": blocks, "earliest_block_num": earliest})
 }
 
 func (s *serviceImpl) getBlockByHash(c *gin.Context) {
-	h := c.Param("id")
-	if h == "" {
-		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("bad id path parameter"))
+	h, ok := parseHash(c, "id")
+	if !ok {
+		return
+	}
+	key := cache.BlockKey(s.store.ChainID(), h)
+	if s.responses.serve(c, "block", key) {
 		return
 	}
 
-	var (
-		block *eth.Block
-		err   error
-	)
 	// try db exists
-	if err = s.db.Preload("Transactions").Where("hash = ?", h).Find(&block).Error; err != nil {
-		log.Printf("failed to find block with hash %s in DB: %v", h, err)
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
-	} else if block.Hash != h {
-		// get from RPC and index it into DB
-		ctx := c.Request.Context()
-		block, err = s.ethClient.GetBlockByHash(ctx, h)
+	block, err := s.store.GetBlockByHash(c.Request.Context(), h)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		internalError(c, err)
+		return
+	} else if err == nil {
+		toRepsonseBlock(block)
+		s.responses.respond(c, key, block.Num, block)
+		return
+	}
+
+	// get from RPC and index it into DB, once for concurrent requests of the block
+	ret, err := s.fetches.do(c.Request.Context(), key, func(ctx context.Context) (fetched, error) {
+		block, err := s.ethClient.GetBlockByHash(ctx, h)
 		if err != nil {
-			log.Printf("failed to call RPC get block by hash %s: %v", h, err)
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
+			return fetched{}, err
+		}
+		toRepsonseBlock(block)
+		// the response is marshalled before the block is written, which fills its fields
+		body, err := json.Marshal(block)
+		if err != nil {
+			return fetched{}, fmt.Errorf("failed to marshal block: %v", err)
 		}
+		ret := fetched{num: block.Num, body: body}
 
-		// write block into DB in background
-		go func() {
-			s.indexer.IndexBlock(block)
-		}()
-	}
+		// an orphaned block would replace the canonical one of its number, so it is served without being written or cached
+		logger := logging.FromContext(ctx).New("chain", s.store.ChainID(), "block", block.Num)
+		canonical, err := s.ethClient.GetBlockHash(ctx, block.Num)
+		if err != nil {
+			logger.Warn("failed to get canonical block hash", "err", err)
+			return ret, nil
+		} else if !strings.EqualFold(canonical, block.Hash) {
+			logger.Info("fetched block is not canonical", "hash", block.Hash, "canonical", canonical)
+			return ret, nil
+		}
+		ret.found = true
 
-	toRepsonseBlock(block)
-	c.JSON(http.StatusOK, block)
+		// write block into DB in background, unless it is older than pruned blocks
+		earliest, err := s.store.EarliestNum(ctx)
+		if err != nil {
+			logger.Error("failed to get earliest block number", "err", err)
+		} else if block.Num >= earliest {
+			s.fetches.write(key, ret, func() {
+				if err := s.indexer.IndexBlock(block); err != nil {
+					logger.Error("failed to index block", "err", err)
+				}
+			})
+		}
+		return ret, nil
+	})
+	if err != nil {
+		upstreamError(c, "block "+h, err)
+		return
+	}
+	if !ret.found {
+		s.responses.respondUncached(c, ret.body)
+		return
+	}
+	s.responses.respond(c, key, ret.num, ret.body)
 }
 
 func (s *serviceImpl) getTransactionByHash(c *gin.Context) {
-	h := c.Param("txHash")
-	if h == "" {
-		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("bad txHash path parameter"))
+	h, ok := parseHash(c, "txHash")
+	if !ok {
+		return
+	}
+	key := cache.TransactionKey(s.store.ChainID(), h)
+	if s.responses.serve(c, "tx", key) {
+		return
+	}
+
+	// try db exists
+	tx, err := s.store.GetTransaction(c.Request.Context(), h)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		internalError(c, err)
+		return
+	} else if err == nil {
+		s.responses.respond(c, key, tx.BlockNum, tx)
+		return
+	}
+
+	// get from RPC, once for concurrent requests of the transaction
+	ret, err := s.fetches.do(c.Request.Context(), key, func(ctx context.Context) (fetched, error) {
+		tx, err := s.ethClient.GetTransactionByHash(ctx, h)
+		if err != nil {
+			return fetched{}, err
+		}
+		logger := logging.FromContext(ctx).New("chain", s.store.ChainID(), "tx", h)
+		if err := s.registry.DecodeInput(tx); err != nil {
+			logger.Warn("failed to decode input", "err", err)
+		}
+		for i := range tx.Logs {
+			if err := s.registry.DecodeLog(&tx.Logs[i]); err != nil {
+				logger.Warn("failed to decode log", "log", tx.Logs[i].Index, "err", err)
+			}
+		}
+		body, err := json.Marshal(tx)
+		if err != nil {
+			return fetched{}, fmt.Errorf("failed to marshal transaction: %v", err)
+		}
+		// pending transactions from the RPC endpoint have block number 0, which no transaction of the genesis block has
+		return fetched{num: tx.BlockNum, found: tx.BlockNum > 0, body: body}, nil
+	})
+	if err != nil {
+		upstreamError(c, "transaction "+h, err)
+		return
+	}
+	if !ret.found {
+		s.responses.respondUncached(c, ret.body)
 		return
 	}
+	s.responses.respond(c, key, ret.num, ret.body)
+}
+
+// parseWei parses a query parameter of an amount in wei, nil if it is absent
+func parseWei(c *gin.Context, key string) (*big.Int, bool) {
+	v := c.Query(key)
+	if v == "" {
+		return nil, true
+	}
+	w, ok := new(big.Int).SetString(v, 10)
+	if !ok || w.Sign() < 0 {
+		badParam(c, key, v, "a non-negative decimal integer in wei")
+		return nil, false
+	}
+	return w, true
+}
 
+func (s *serviceImpl) getTransactions(c *gin.Context) {
 	var (
-		tx  *eth.Transaction
-		err error
+		query = db.TransactionQuery{}
+		ok    = true
 	)
+	if address := c.Param("addr"); address != "" {
+		query.Address, ok = parseAddress(c, "addr", address)
+	} else if address := c.Query("address"); address != "" {
+		query.Address, ok = parseAddress(c, "address", address)
+	}
+	if !ok {
+		return
+	}
+
+	if query.MinValue, ok = parseWei(c, "min_value"); !ok {
+		return
+	}
+	if query.MaxValue, ok = parseWei(c, "max_value"); !ok {
+		return
+	}
+
+	switch sort := c.DefaultQuery("sort", "block_num"); sort {
+	case "block_num":
+	case "value":
+		query.SortByValue = true
+	default:
+		badParam(c, "sort", sort, "block_num or value")
+		return
+	}
+	switch order := c.DefaultQuery("order", "desc"); order {
+	case "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		badParam(c, "order", order, "asc or desc")
+		return
+	}
+
+	if query.Limit, ok = parseLimit(c); !ok {
+		return
+	}
+
+	txs, err := s.store.ListTransactions(c.Request.Context(), query)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transactions": txs})
+}
+
+func (s *serviceImpl) getToken(c *gin.Context) {
+	address, ok := parseAddress(c, "address", c.Param("address"))
+	if !ok {
+		return
+	}
+
 	// try db exists
-	if err = s.db.Preload("Logs").Where("hash = ?", h).Find(&tx).Error; err != nil {
-		log.Printf("failed to find transaction with hash %s in DB: %v", h, err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	token, err := s.store.GetToken(c.Request.Context(), address)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		internalError(c, err)
 		return
-	} else if tx.Hash != h {
+	} else if err != nil {
 		// get from RPC
-		ctx := c.Request.Context()
-		tx, err = s.ethClient.GetTransactionByHash(ctx, h)
+		token, err = s.ethClient.GetToken(c.Request.Context(), address)
 		if err != nil {
-			log.Printf("failed to call RPC get transaction by hash %s: %v", h, err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			upstreamError(c, "token "+address, err)
 			return
 		}
 	}
 
-	c.JSON(http.StatusOK, tx)
+	c.JSON(http.StatusOK, token)
+}
+
+func (s *serviceImpl) getABI(c *gin.Context) {
+	address, ok := parseAddress(c, "address", c.Param("address"))
+	if !ok {
+		return
+	}
+
+	abi, ok := s.registry.Get(address)
+	if !ok {
+		notFound(c, "no ABI registered for %s", address)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json", []byte(abi))
+}
+
+func (s *serviceImpl) putABI(c *gin.Context) {
+	address, ok := parseAddress(c, "address", c.Param("address"))
+	if !ok {
+		return
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		abort(c, http.StatusBadRequest, fmt.Errorf("failed to read request body: %v", err))
+		return
+	}
+	if err := s.registry.Register(address, body); err != nil {
+		abort(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *serviceImpl) getSelector(c *gin.Context) {
+	selector := strings.ToLower(c.Param("selector"))
+	if b, err := hexutil.Decode(selector); err != nil || len(b) != 4 {
+		badParam(c, "selector", c.Param("selector"), "a 4-byte selector in hex with 0x prefix")
+		return
+	}
+
+	signatures := s.registry.LookupSelector(selector)
+	if len(signatures) == 0 {
+		notFound(c, "no signature known for %s", selector)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"selector": selector, "signatures": signatures})
+}
+
+func (s *serviceImpl) getBalance(c *gin.Context) {
+	address, ok := parseAddress(c, "addr", c.Param("addr"))
+	if !ok {
+		return
+	}
+
+	blockNum := uint64(math.MaxInt64)
+	if b := c.Query("block"); b != "" {
+		var err error
+		blockNum, err = strconv.ParseUint(b, 10, 64)
+		if err != nil {
+			badParam(c, "block", b, "a block number")
+			return
+		}
+	}
+
+	// the balance at a block is the one recorded when the address was last touched
+	balance, err := s.store.GetBalance(c.Request.Context(), address, blockNum)
+	if errors.Is(err, db.ErrNotFound) {
+		notFound(c, "no balance recorded for %s", address)
+		return
+	} else if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, balance)
+}
+
+func (s *serviceImpl) getInternalTransactionsByHash(c *gin.Context) {
+	h, ok := parseHash(c, "txHash")
+	if !ok {
+		return
+	}
+
+	internals, err := s.store.ListInternalTransactionsByHash(c.Request.Context(), h)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"internal_transactions": internals})
+}
+
+func (s *serviceImpl) getInternalTransactionsByAddress(c *gin.Context) {
+	address, ok := parseAddress(c, "addr", c.Param("addr"))
+	if !ok {
+		return
+	}
+	limit, ok := parseLimit(c)
+	if !ok {
+		return
+	}
+
+	internals, err := s.store.ListInternalTransactionsByAddress(c.Request.Context(), address, limit)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"internal_transactions": internals})
+}
+
+func (s *serviceImpl) getContract(c *gin.Context) {
+	address, ok := parseAddress(c, "address", c.Param("address"))
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	contract, err := s.store.GetContract(ctx, address)
+	if errors.Is(err, db.ErrNotFound) {
+		notFound(c, "no contract indexed at %s", address)
+		return
+	} else if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	activity, err := s.store.GetContractActivity(ctx, address)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	resp := gin.H{"contract": contract, "activity": activity}
+	if token, err := s.store.GetToken(ctx, address); err == nil {
+		resp["token"] = token
+	} else if !errors.Is(err, db.ErrNotFound) {
+		internalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+)
+
+// newTestRouter creates a router serving chains of IDs in memory stores, returning their clients in order
+func newTestRouter(t *testing.T, ids ...uint64) (*Router, []*eth.MemoryClient) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	chains := make([]Chain, len(ids))
+	clients := make([]*eth.MemoryClient, len(ids))
+	for i, id := range ids {
+		clients[i] = eth.NewMemoryClient(id)
+		chains[i] = newTestChain(t, db.NewMemoryStore(id), clients[i])
+	}
+	return New(Options{}, chains...), clients
+}
+
+func TestGetChain(t *testing.T) {
+	r, _ := newTestRouter(t, 97, 1)
+	tests := []struct {
+		path string
+		id   uint64
+		name string
+	}{
+		{"/chain", 97, "BSC Testnet"},
+		{"/chains/97/chain", 97, "BSC Testnet"},
+		{"/chains/1/chain", 1, "Ethereum"},
+	}
+	for _, tt := range tests {
+		var chain eth.ChainConfig
+		if code := getJSON(t, r, tt.path, &chain); code != http.StatusOK {
+			t.Errorf("GET %s = %d", tt.path, code)
+			continue
+		}
+		if chain.ID != tt.id || chain.Name != tt.name || !chain.Known || len(chain.Forks) == 0 {
+			t.Errorf("GET %s = %+v", tt.path, chain)
+		}
+	}
+
+	w := serve(r, http.MethodGet, "/chains/56/chain")
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusNotFound {
+		t.Errorf("GET /chains/56/chain = %d, %s", w.Code, w.Body)
+	}
+	if fmt.Sprint(body["code"]) != "not_found" {
+		t.Errorf("error code = %v", body["code"])
+	}
+}
+
+// failingStore fails every read of blocks and transactions like an unreachable DB
+type failingStore struct {
+	db.Store
+}
+
+var errDB = errors.New("dial tcp 10.0.0.1:3306: connection refused")
+
+func (failingStore) GetBlockByHash(ctx context.Context, h string) (*eth.Block, error) {
+	return nil, errDB
+}
+
+func (failingStore) ListTransactions(ctx context.Context, query db.TransactionQuery) ([]*eth.Transaction, error) {
+	return nil, errDB
+}
+
+// getError serves a GET request of path to r, decoding its error body
+func getError(t *testing.T, r http.Handler, path string) (int, errorBody) {
+	t.Helper()
+	w := serve(r, http.MethodGet, path)
+	var body errorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode error response of %s: %v: %s", path, err, w.Body)
+	}
+	return w.Code, body
+}
+
+func TestBadRequests(t *testing.T) {
+	r, _ := newTestRouter(t, chainID)
+	tests := []struct {
+		path, parameter string
+	}{
+		{"/blocks/0x1234", "id"},
+		{"/blocks/" + hash("block", 1, "")[2:], "id"},
+		{"/chains/97/blocks/latest", "id"},
+		{"/blocks/?limit=0", "limit"},
+		{"/blocks/?limit=ten", "limit"},
+		{"/transaction/0xzz" + hash("tx", 1, "")[4:], "txHash"},
+		{"/transaction/0x12/internal", "txHash"},
+		{"/transactions?address=0x1234", "address"},
+		{"/transactions?min_value=-1", "min_value"},
+		{"/transactions?max_value=1e18", "max_value"},
+		{"/transactions?sort=gas", "sort"},
+		{"/transactions?order=up", "order"},
+		{"/address/alice/transactions", "addr"},
+		{"/address/" + alice + "/balance?block=latest", "block"},
+		{"/address/" + alice + "/internal?limit=-1", "limit"},
+		{"/tokens/0x1111", "address"},
+		{"/contracts/" + alice + "00", "address"},
+		{"/abis/0x", "address"},
+		{"/selectors/0xa9059cbb00", "selector"},
+		{"/selectors/transfer", "selector"},
+	}
+	for _, tt := range tests {
+		code, body := getError(t, r, tt.path)
+		if code != http.StatusBadRequest || body.Code != "bad_request" || body.Message == "" {
+			t.Errorf("GET %s = %d, %+v", tt.path, code, body)
+			continue
+		}
+		details, _ := body.Details.(map[string]interface{})
+		if details["parameter"] != tt.parameter || details["value"] == nil {
+			t.Errorf("details of GET %s = %v, want parameter %s", tt.path, body.Details, tt.parameter)
+		}
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	r, _ := newTestRouter(t, chainID)
+	paths := []string{
+		"/blocks/" + hash("block", 1, ""),
+		"/transaction/" + hash("tx", 1, ""),
+		"/address/" + alice + "/balance",
+		"/contracts/" + token,
+		"/abis/" + token,
+		"/selectors/0x12345678",
+		"/chains/56/blocks/" + hash("block", 1, ""),
+		"/chains/bsc/chain",
+		"/blocks/" + hash("block", 1, "") + "/transactions",
+		"/unknown",
+	}
+	for _, path := range paths {
+		code, body := getError(t, r, path)
+		if code != http.StatusNotFound || body.Code != "not_found" || body.Message == "" || body.Details != nil {
+			t.Errorf("GET %s = %d, %+v", path, code, body)
+		}
+	}
+}
+
+func TestUpstreamErrors(t *testing.T) {
+	r, clients := newTestRouter(t, chainID)
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("%w: dial tcp: i/o timeout", eth.ErrUnavailable), http.StatusServiceUnavailable, "upstream_unavailable"},
+		{errors.New("invalid argument 0: hex string has length 40"), http.StatusBadGateway, "upstream_error"},
+	}
+	for _, tt := range tests {
+		clients[0].Fail(tt.err)
+		for _, path := range []string{
+			"/blocks/" + hash("block", 1, ""),
+			"/transaction/" + hash("tx", 1, ""),
+			"/tokens/" + token,
+		} {
+			code, body := getError(t, r, path)
+			// messages of upstream errors are logged rather than responded
+			if code != tt.status || body.Code != tt.code || body.Message != http.StatusText(tt.status) || body.Details != nil {
+				t.Errorf("GET %s failed by %v = %d, %+v", path, tt.err, code, body)
+			}
+		}
+	}
+}
+
+func TestInternalErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := failingStore{db.NewMemoryStore(chainID)}
+	r := New(Options{}, newTestChain(t, store, eth.NewMemoryClient(chainID)))
+	for _, path := range []string{"/blocks/" + hash("block", 1, ""), "/transactions"} {
+		code, body := getError(t, r, path)
+		if code != http.StatusInternalServerError || body.Code != "internal_error" ||
+			body.Message != "Internal Server Error" || strings.Contains(body.Message, "10.0.0.1") {
+			t.Errorf("GET %s = %d, %+v", path, code, body)
+		}
+	}
+}
+
+func TestHealthRoutes(t *testing.T) {
+	r, clients := newTestRouter(t, 97, 1)
+	if w := serve(r, http.MethodGet, "/healthz"); w.Code != http.StatusOK {
+		t.Errorf("GET /healthz = %d, %s", w.Code, w.Body)
+	}
+
+	var ready struct {
+		Ready  bool `json:"ready"`
+		Chains []struct {
+			ChainID uint64 `json:"chain_id"`
+			Ready   bool   `json:"ready"`
+		} `json:"chains"`
+	}
+	if code := getJSON(t, r, "/readyz", &ready); code != http.StatusOK || !ready.Ready || len(ready.Chains) != 2 {
+		t.Errorf("GET /readyz = %d, %+v", code, ready)
+	}
+	// a chain whose RPC endpoint is unavailable makes the server not ready
+	clients[1].Fail(eth.ErrUnavailable)
+	w := serve(r, http.MethodGet, "/readyz")
+	if err := json.Unmarshal(w.Body.Bytes(), &ready); err != nil || w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz = %d, %s", w.Code, w.Body)
+	}
+	if ready.Ready || !ready.Chains[0].Ready || ready.Chains[1].Ready {
+		t.Errorf("readiness = %+v, want chain 1 not ready", ready)
+	}
+	if w := serve(r, http.MethodGet, "/status"); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"chain_id":1`) {
+		t.Errorf("GET /status = %d, %s", w.Code, w.Body)
+	}
+}
+
+// blockingClient blocks fetches of transactions until release is closed
+type blockingClient struct {
+	eth.Client
+	release chan struct{}
+}
+
+func (c blockingClient) GetTransactionByHash(ctx context.Context, h string) (*eth.Transaction, error) {
+	<-c.release
+	return c.Client.GetTransactionByHash(ctx, h)
+}
+
+func TestCanceledRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := blockingClient{eth.NewMemoryClient(chainID), make(chan struct{})}
+	defer close(client.release)
+	r := New(Options{}, newTestChain(t, db.NewMemoryStore(chainID), client))
+
+	// the request is canceled while waiting for the shared fetch, which is not an upstream error
+	path := "/transaction/" + hash("tx", 1, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+	var body errorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != statusClientClosedRequest || body.Code != "client_closed_request" {
+		t.Errorf("canceled GET %s = %d, %s", path, w.Code, w.Body)
+	}
+}
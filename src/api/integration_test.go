@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gin-gonic/gin"
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+	"github.com/r04922101/portto/indexer"
+	"gorm.io/gorm"
+)
+
+const chainID = 97
+
+var (
+	alice = common.HexToAddress("0x1111111111111111111111111111111111111111").Hex()
+	bob   = common.HexToAddress("0x2222222222222222222222222222222222222222").Hex()
+	token = common.HexToAddress("0xae13d989daC2f0dEbFf460aC112a837C89BAa7cd").Hex()
+)
+
+// hash returns a distinct hash in hex of a block or transaction numbered n of fork, an empty string for the canonical one
+func hash(kind string, n uint64, fork string) string {
+	return crypto.Keccak256Hash([]byte(fmt.Sprintf("%s/%d/%s", kind, n, fork))).Hex()
+}
+
+// newBlock creates the block numbered n of fork with txs
+func newBlock(n uint64, fork string, txs ...eth.Transaction) *eth.Block {
+	return &eth.Block{
+		Num:          n,
+		Hash:         hash("block", n, fork),
+		ParentHash:   hash("block", n-1, fork),
+		Time:         1700000000 + 3*n,
+		Transactions: txs,
+	}
+}
+
+// newTx creates a transaction numbered n of fork sending value from one address to another
+func newTx(n uint64, fork, from, to string, value int64) eth.Transaction {
+	return eth.Transaction{Hash: hash("tx", n, fork), From: from, To: to, Value: eth.NewWei(big.NewInt(value))}
+}
+
+// newSQLiteDB opens an in-memory SQLite DB migrated to the latest version, which lives until the test ends
+func newSQLiteDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	gdb, err := db.InitDB(db.DriverSQLite, "", fmt.Sprintf("file:%s?mode=memory&cache=shared", name), "", "", "")
+	if err != nil {
+		t.Fatalf("failed to open sqlite DB: %v", err)
+	}
+	if err := db.Migrate(gdb, db.LatestVersion(), db.MigrateOptions{}); err != nil {
+		t.Fatalf("failed to migrate sqlite DB: %v", err)
+	}
+	t.Cleanup(func() {
+		if pool, err := gdb.DB(); err == nil {
+			pool.Close()
+		}
+	})
+	return gdb
+}
+
+// newTestChain creates a chain served from store, falling back to client
+func newTestChain(t *testing.T, store db.Store, client eth.Client) Chain {
+	t.Helper()
+	i, err := indexer.New(store, client, indexer.Config{WorkerNum: 2})
+	if err != nil {
+		t.Fatalf("failed to create indexer: %v", err)
+	}
+	return Chain{Store: store, EthClient: client, Indexer: i}
+}
+
+// getJSON serves a GET request of path to r, decoding its JSON body into v unless it is nil
+func getJSON(t *testing.T, r http.Handler, path string, v interface{}) int {
+	t.Helper()
+	w := serve(r, http.MethodGet, path)
+	if v != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("failed to decode response of %s: %v: %s", path, err, w.Body)
+		}
+	}
+	return w.Code
+}
+
+func TestIndexAndServeSQLite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	gdb := newSQLiteDB(t)
+	store := db.NewSQLStore(gdb, chainID)
+
+	client := eth.NewMemoryClient(chainID)
+	client.AddToken(&eth.Token{Address: token, Name: "Wrapped BNB", Symbol: "WBNB", Decimals: 18, TotalSupply: "1000"})
+	transfer := newTx(1, "", alice, token, 0)
+	transfer.Value = eth.NewWei(new(big.Int).Exp(big.NewInt(10), big.NewInt(30), nil))
+	transfer.Logs = []eth.Log{{
+		Address: token,
+		Topics: eth.Topics{eth.TransferTopic,
+			common.BytesToHash(common.FromHex(alice)).Hex(), common.BytesToHash(common.FromHex(bob)).Hex()},
+		Data: common.BytesToHash(big.NewInt(1000).Bytes()).Hex(),
+	}}
+	client.AddBlock(newBlock(1, ""))
+	client.AddBlock(newBlock(2, "", transfer))
+	client.AddBlock(newBlock(3, "", newTx(2, "", bob, alice, 7)))
+
+	chain := newTestChain(t, store, client)
+	if _, err := chain.Indexer.IndexRecentBlocks(ctx, 1); err != nil {
+		t.Fatalf("failed to index blocks: %v", err)
+	}
+	r := New(Options{Auth: Auth{Keys: db.NewSQLKeyStore(gdb)}}, chain)
+	defer r.Close()
+
+	var blocks struct {
+		Blocks   []eth.Block `json:"blocks"`
+		Earliest uint64      `json:"earliest_block_num"`
+	}
+	if code := getJSON(t, r, "/blocks/?limit=2", &blocks); code != http.StatusOK {
+		t.Fatalf("GET /blocks/ = %d", code)
+	}
+	if len(blocks.Blocks) != 2 || blocks.Blocks[0].Num != 3 || blocks.Earliest != 1 {
+		t.Errorf("blocks = %+v", blocks)
+	}
+
+	var block eth.Block
+	if code := getJSON(t, r, "/blocks/"+hash("block", 2, ""), &block); code != http.StatusOK {
+		t.Fatalf("GET /blocks/:id = %d", code)
+	}
+	if block.Num != 2 || len(block.TransactionIDs) != 1 || block.TransactionIDs[0] != transfer.Hash {
+		t.Errorf("block = %+v", block)
+	}
+
+	// values beyond float64 precision and logs are kept
+	var tx eth.Transaction
+	if code := getJSON(t, r, "/transaction/"+transfer.Hash, &tx); code != http.StatusOK {
+		t.Fatalf("GET /transaction/:txHash = %d", code)
+	}
+	if tx.Value.String() != transfer.Value.String() || len(tx.Logs) != 1 {
+		t.Errorf("transaction = %+v", tx)
+	}
+
+	var txs struct {
+		Transactions []eth.Transaction `json:"transactions"`
+	}
+	if code := getJSON(t, r, "/address/"+alice+"/transactions?sort=value", &txs); code != http.StatusOK {
+		t.Fatalf("GET /address/:addr/transactions = %d", code)
+	}
+	if len(txs.Transactions) != 2 || txs.Transactions[0].Hash != transfer.Hash {
+		t.Errorf("transactions = %+v", txs.Transactions)
+	}
+
+	var tok eth.Token
+	if code := getJSON(t, r, "/tokens/"+token, &tok); code != http.StatusOK || tok.Symbol != "WBNB" {
+		t.Errorf("GET /tokens/:address = %d, %+v", code, tok)
+	}
+	if code := getJSON(t, r, fmt.Sprintf("/chains/%d/blocks/%s", chainID, hash("block", 3, "")), nil); code != http.StatusOK {
+		t.Errorf("GET /chains/:chainId/blocks/:id = %d", code)
+	}
+
+	// reorganized blocks are replaced with their transactions
+	client.AddBlock(newBlock(3, "fork", newTx(3, "fork", alice, bob, 1)))
+	if err := chain.Indexer.IndexBlockByNum(ctx, 3); err != nil {
+		t.Fatalf("failed to index reorganized block: %v", err)
+	}
+	if b, err := store.GetBlockByNumber(ctx, 3); err != nil || b.Hash != hash("block", 3, "fork") {
+		t.Errorf("block 3 = %+v, %v", b, err)
+	}
+	if _, err := store.GetTransaction(ctx, hash("tx", 2, "")); err == nil {
+		t.Error("transaction of reorganized block is kept")
+	}
+
+	// blocks missing in DB are fetched from the RPC endpoint and indexed
+	client.AddBlock(newBlock(4, ""))
+	if code := getJSON(t, r, "/blocks/"+hash("block", 4, ""), &block); code != http.StatusOK || block.Num != 4 {
+		t.Fatalf("GET /blocks/:id of block not indexed = %d, %+v", code, block)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := store.GetBlockByHash(ctx, hash("block", 4, "")); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("fetched block is not indexed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/r04922101/portto/logging"
+)
+
+// requestIDHeader carries the ID of a request, which is given by the caller or generated otherwise
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen limits the length of request IDs given by callers
+const maxRequestIDLen = 64
+
+// logRequest is a middleware putting a logger with the request ID into the request context,
+// and logging the request after it is handled
+func logRequest(c *gin.Context) {
+	start := time.Now()
+	id := c.GetHeader(requestIDHeader)
+	if id == "" || len(id) > maxRequestIDLen {
+		id = newRequestID()
+	}
+	c.Header(requestIDHeader, id)
+	logger := logging.New("request_id", id)
+	c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), logger))
+
+	c.Next()
+
+	logger.Info("handled request", "method", c.Request.Method, "path", c.Request.URL.Path,
+		"status", c.Writer.Status(), "latency", time.Since(start), "ip", c.ClientIP())
+}
+
+// newRequestID returns a random ID of 16 hex digits
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// recoverRequest is a middleware responding 500 to a request, whose handler panics, logging the panic
+var recoverRequest = gin.CustomRecoveryWithWriter(ioutil.Discard, func(c *gin.Context, err interface{}) {
+	logging.FromContext(c.Request.Context()).Error("handler panicked", "err", err, "stack", string(debug.Stack()))
+	c.AbortWithStatus(http.StatusInternalServerError)
+})
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/gin-gonic/gin"
+	"github.com/r04922101/portto/logging"
+)
+
+// recordLogs records logs of the root logger until the test ends
+func recordLogs(t *testing.T) func() []*log.Record {
+	t.Helper()
+	var (
+		mu      sync.Mutex
+		records []*log.Record
+	)
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		mu.Lock()
+		defer mu.Unlock()
+		records = append(records, r)
+		return nil
+	}))
+	t.Cleanup(func() {
+		if err := logging.Setup("info", logging.FormatText); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return func() []*log.Record {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*log.Record(nil), records...)
+	}
+}
+
+// fields returns fields of a record by key
+func fields(r *log.Record) map[string]interface{} {
+	ret := map[string]interface{}{}
+	for i := 0; i+1 < len(r.Ctx); i += 2 {
+		ret[r.Ctx[i].(string)] = r.Ctx[i+1]
+	}
+	return ret
+}
+
+func TestLogRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(logRequest, recoverRequest, respondError)
+	r.GET("/fine", func(c *gin.Context) {
+		logging.FromContext(c.Request.Context()).Info("handling")
+		c.Status(http.StatusOK)
+	})
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	records := recordLogs(t)
+
+	tests := []struct {
+		path, id string
+		status   int
+		given    bool
+	}{
+		{"/fine", "caller-id", http.StatusOK, true},
+		{"/fine", "", http.StatusOK, false},
+		{"/fine", strings.Repeat("x", maxRequestIDLen+1), http.StatusOK, false},
+		{"/panic", "panicking", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		before := len(records())
+		w := serve(r, http.MethodGet, tt.path, requestIDHeader, tt.id)
+		id := w.Header().Get(requestIDHeader)
+		if w.Code != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.status)
+		}
+		if (id == tt.id) != tt.given || len(id) == 0 || len(id) > maxRequestIDLen {
+			t.Errorf("request ID = %q, given %q", id, tt.id)
+		}
+
+		// every record of the request has its ID, and the last one is of handling it
+		logged := records()[before:]
+		if len(logged) != 2 {
+			t.Fatalf("GET %s logged %d records, want 2", tt.path, len(logged))
+		}
+		for _, rec := range logged {
+			if fields(rec)["request_id"] != id {
+				t.Errorf("record %q of GET %s has request ID %v, want %s", rec.Msg, tt.path, fields(rec)["request_id"], id)
+			}
+		}
+		last := fields(logged[1])
+		if logged[1].Msg != "handled request" || last["status"] != tt.status || last["path"] != tt.path {
+			t.Errorf("record %q has fields %v", logged[1].Msg, last)
+		}
+		if tt.path == "/panic" && (logged[0].Lvl != log.LvlError || logged[0].Msg != "handler panicked") {
+			t.Errorf("record of panic = %s %q", logged[0].Lvl, logged[0].Msg)
+		}
+	}
+}
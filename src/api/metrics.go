@@ -0,0 +1,23 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/r04922101/portto/metrics"
+)
+
+// observeRequest is a middleware recording counts and latencies of requests by route,
+// which is the route pattern rather than the path to keep the # of series bounded
+func observeRequest(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	metrics.HTTPRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+	metrics.HTTPDuration.WithLabelValues(route, c.Request.Method).Observe(metrics.Since(start))
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/r04922101/portto/metrics"
+)
+
+func TestObserveRequest(t *testing.T) {
+	r, _ := newTestRouter(t, 97)
+	tests := []struct {
+		path, route, status string
+	}{
+		{"/chain", "/chain", "200"},
+		{"/chains/97/chain", "/chains/:chainId/chain", "200"},
+		{"/chains/56/chain", "/chains/:chainId/chain", "404"},
+		{"/nowhere", "unmatched", "404"},
+	}
+	for _, tt := range tests {
+		requests := metrics.HTTPRequests.WithLabelValues(tt.route, http.MethodGet, tt.status)
+		before := testutil.ToFloat64(requests)
+		serve(r, http.MethodGet, tt.path)
+		if got := testutil.ToFloat64(requests) - before; got != 1 {
+			t.Errorf("GET %s: requests of %s %s = %v, want 1", tt.path, tt.route, tt.status, got)
+		}
+	}
+
+	w := serve(r, http.MethodGet, "/metrics")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", w.Code)
+	}
+	for _, want := range []string{
+		`portto_http_requests_total{method="GET",route="/chains/:chainId/chain",status="404"}`,
+		`portto_http_request_duration_seconds_count{method="GET",route="/chain"}`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics miss %s", want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/logging"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// limiterIdle is the time after which limiters of idle clients, whose buckets are full by then, are dropped
+	limiterIdle = 10 * time.Minute
+	// usageFlush is the interval of adding usage accounted in memory to the key store
+	usageFlush = 10 * time.Second
+)
+
+// limiter is a token bucket of a client with the time it is used last
+type limiter struct {
+	*rate.Limiter
+	seen time.Time
+}
+
+// limiters are token buckets of clients, e.g. IPs or API keys
+type limiters struct {
+	mu        sync.Mutex
+	buckets   map[string]*limiter
+	lastSweep time.Time
+}
+
+func newLimiters() *limiters {
+	return &limiters{buckets: map[string]*limiter{}, lastSweep: time.Now()}
+}
+
+// wait takes a token of the bucket of client, which refills r tokens per second up to burst,
+// returning 0 if it is taken, or the time until a token is available otherwise
+func (l *limiters) wait(client string, r float64, burst int) time.Duration {
+	if r <= 0 {
+		return 0
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > limiterIdle {
+		for k, b := range l.buckets {
+			if now.Sub(b.seen) > limiterIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &limiter{Limiter: rate.NewLimiter(rate.Limit(r), burst)}
+		l.buckets[client] = b
+	}
+	b.seen = now
+	res := b.ReserveN(now, 1)
+	if !res.OK() {
+		return time.Second
+	}
+	if d := res.DelayFrom(now); d > 0 {
+		// the token is not taken by a rejected request
+		res.CancelAt(now)
+		return d
+	}
+	return 0
+}
+
+// keyCount is the # of requests of a key in a day, of which pending ones are not added to the key store yet
+type keyCount struct {
+	requests, pending int64
+	lastUsed          time.Time
+}
+
+// usage accounts requests of API keys per UTC day in memory, adding them to the key store periodically,
+// so that daily quotas are checked without a DB write per request;
+// quotas are enforced per api server, which counts requests of others only when it loads the usage of a key
+type usage struct {
+	store db.KeyStore
+
+	mu     sync.Mutex
+	day    string
+	counts map[uint]*keyCount
+	// pending are requests of previous days not added yet
+	pending []db.KeyUsage
+
+	// stop stops flushing periodically, after which done is closed once pending requests are flushed
+	stop, done chan struct{}
+	closeOnce  sync.Once
+}
+
+func newUsage(store db.KeyStore) *usage {
+	u := &usage{
+		store:  store,
+		day:    db.UsageDay(time.Now()),
+		counts: map[uint]*keyCount{},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go u.run()
+	return u
+}
+
+// run flushes usage every usageFlush until close is called, flushing once more then
+func (u *usage) run() {
+	defer close(u.done)
+	ticker := time.NewTicker(usageFlush)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			u.flush(context.Background())
+		case <-u.stop:
+			u.flush(context.Background())
+			return
+		}
+	}
+}
+
+// close stops flushing usage periodically, returning once pending requests are added to the key store
+func (u *usage) close() {
+	u.closeOnce.Do(func() {
+		close(u.stop)
+	})
+	<-u.done
+}
+
+// take accounts a request of key, returning 0 if the key is within its daily quota,
+// or the time until the quota is reset otherwise
+func (u *usage) take(ctx context.Context, key *db.APIKey) (time.Duration, error) {
+	now := time.Now()
+	day := db.UsageDay(now)
+	u.mu.Lock()
+	u.rollover(day)
+	count, ok := u.counts[key.ID]
+	u.mu.Unlock()
+	if !ok {
+		// requests already made today, e.g. before a restart, count towards the quota
+		loaded, err := u.store.GetUsage(ctx, key.ID, day)
+		if err != nil {
+			return 0, err
+		}
+		u.mu.Lock()
+		u.rollover(day)
+		if count, ok = u.counts[key.ID]; !ok {
+			count = &keyCount{requests: loaded.Requests}
+			u.counts[key.ID] = count
+		}
+		u.mu.Unlock()
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if key.DailyQuota > 0 && count.requests >= key.DailyQuota {
+		t, _ := time.Parse("2006-01-02", day)
+		return t.AddDate(0, 0, 1).Sub(now), nil
+	}
+	count.requests++
+	count.pending++
+	count.lastUsed = now
+	return 0, nil
+}
+
+// rollover starts accounting requests of day, keeping pending requests of the previous day,
+// which is called with mu held
+func (u *usage) rollover(day string) {
+	if day == u.day {
+		return
+	}
+	u.pending = append(u.pending, u.drain()...)
+	u.day, u.counts = day, map[uint]*keyCount{}
+}
+
+// drain returns pending requests of the current day, which is called with mu held
+func (u *usage) drain() []db.KeyUsage {
+	var ret []db.KeyUsage
+	for id, c := range u.counts {
+		if c.pending > 0 {
+			ret = append(ret, db.KeyUsage{KeyID: id, Day: u.day, Requests: c.pending, LastUsedAt: c.lastUsed})
+			c.pending = 0
+		}
+	}
+	return ret
+}
+
+// flush adds pending requests to the key store, keeping them for the next flush if it fails
+func (u *usage) flush(ctx context.Context) {
+	u.mu.Lock()
+	u.rollover(db.UsageDay(time.Now()))
+	pending := append(u.pending, u.drain()...)
+	u.pending = nil
+	u.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	if err := u.store.AddUsage(ctx, pending); err != nil {
+		logging.Root().Error("failed to add usage of API keys", "keys", len(pending), "err", err)
+		u.mu.Lock()
+		u.pending = append(u.pending, pending...)
+		u.mu.Unlock()
+	}
+}
@@ -1,65 +1,265 @@
 package api
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	ginerror "github.com/r04922101/gin-error"
+	"github.com/r04922101/portto/cache"
 	"github.com/r04922101/portto/db"
 	"github.com/r04922101/portto/eth"
 	"github.com/r04922101/portto/indexer"
+	"github.com/r04922101/portto/logging"
+	"github.com/r04922101/portto/metrics"
+	"gorm.io/gorm"
 )
 
 // NewRouter creates a router for api svc
-func NewRouter(config Config) (*gin.Engine, error) {
-	gdb, err := db.InitDB(config.SQLHost, config.SQLDB, config.SQLPort, config.SQLUser, config.SQLPassword)
+func NewRouter(config Config) (*Router, error) {
+	if len(config.Chains) == 0 {
+		return nil, fmt.Errorf("no chain to serve")
+	}
+	if config.StartBlock > 0 && len(config.Chains) > 1 {
+		return nil, fmt.Errorf("start block requires a single chain")
+	}
+	gdb, err := db.InitDB(config.SQLDriver, config.SQLHost, config.SQLDB, config.SQLPort, config.SQLUser, config.SQLPassword)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to sql DB: %v", err)
 	}
+	// the indexer writes to the primary, while handlers read from replicas
+	replicaDBs := make([]*gorm.DB, 0, len(config.SQLReplicas))
+	for _, r := range config.SQLReplicas {
+		host, port := r, config.SQLPort
+		if h, p, err := net.SplitHostPort(r); err == nil {
+			host, port = h, p
+		}
+		rdb, err := db.InitDB(config.SQLDriver, host, config.SQLDB, port, config.SQLUser, config.SQLPassword)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to sql DB replica %s: %v", r, err)
+		}
+		replicaDBs = append(replicaDBs, rdb)
+	}
 
-	ethClient, err := eth.NewClient(config.RPCEndpoint)
+	responses, err := cache.Open(context.Background(), config.Cache)
 	if err != nil {
-		return nil, fmt.Errorf("failed to new eth client with endpoint %s: %v", config.RPCEndpoint, err)
+		return nil, fmt.Errorf("failed to open cache: %v", err)
+	}
+	if config.Cache.Size > 0 && config.Cache.Redis == "" {
+		// an indexer in another process deletes reorganized responses from the shared cache only
+		logging.Root().Warn("responses cached in process are kept on reorgs indexed by other processes until they expire, set a Redis cache to invalidate them")
 	}
 
-	indexer, err := indexer.NewIndexer(indexer.Config{
-		SQLHost:     config.SQLHost,
-		SQLDB:       config.SQLDB,
-		SQLUser:     config.SQLUser,
-		SQLPassword: config.SQLPassword,
-		SQLPort:     config.SQLPort,
-		RPCEndpoint: config.RPCEndpoint,
-		WorkerNum:   10,
+	chains := make([]Chain, 0, len(config.Chains))
+	for _, c := range config.Chains {
+		chain, err := newChain(config, c, gdb, replicaDBs, responses)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up chain %d: %v", c.ID, err)
+		}
+		chains = append(chains, chain)
+	}
+	r := New(Options{
+		Auth:    Auth{AuthConfig: config.Auth, Keys: db.NewSQLKeyStore(gdb)},
+		Cache:   responses,
+		Caching: config.Caching,
+	}, chains...)
+	if err := r.SetTrustedProxies(config.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("failed to set trusted proxies: %v", err)
+	}
+	return r, nil
+}
+
+// newChain creates the store, client and indexer of a chain, which shares the DBs and the cache of responses with other chains
+func newChain(config Config, c ChainConfig, gdb *gorm.DB, replicaDBs []*gorm.DB, responses cache.Cache) (Chain, error) {
+	ethClient, err := eth.NewClient(c.RPCEndpoint)
+	if err != nil {
+		return Chain{}, fmt.Errorf("failed to new eth client with endpoint %s: %v", c.RPCEndpoint, err)
+	}
+
+	store := db.NewSQLStore(gdb, c.ID)
+	if responses != nil {
+		// responses of reorganized blocks indexed by the server are dropped
+		store = cache.NewInvalidatingStore(store, responses)
+	}
+	replicas := make([]db.Store, len(replicaDBs))
+	for i, rdb := range replicaDBs {
+		replicas[i] = db.NewSQLStore(rdb, c.ID)
+	}
+	indexer, err := indexer.New(store, ethClient, indexer.Config{
+		ChainID:   c.ID,
+		WorkerNum: 10,
+
+		TrackBalances: config.TrackBalances,
+		TraceInternal: config.TraceInternal,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to new indexer: %v", err)
+		return Chain{}, fmt.Errorf("failed to new indexer: %v", err)
+	}
+	if err := indexer.CheckSchema(); err != nil {
+		return Chain{}, fmt.Errorf("failed to check schema: %v", err)
+	}
+	if err := indexer.CheckChain(); err != nil {
+		return Chain{}, fmt.Errorf("failed to check chain: %v", err)
+	}
+	if config.ABIDir != "" {
+		if err := indexer.Registry().LoadDir(config.ABIDir); err != nil {
+			return Chain{}, fmt.Errorf("failed to register ABIs: %v", err)
+		}
+	}
+	if config.Signatures != "" {
+		if err := indexer.Registry().LoadSignatures(config.Signatures); err != nil {
+			return Chain{}, fmt.Errorf("failed to load signatures: %v", err)
+		}
 	}
-	if err := indexer.CheckTables(); err != nil {
-		return nil, fmt.Errorf("failed to check required tables exist: %v", err)
+	// index newest blocks every minute, while blocks from the start block are indexed in background
+	if config.StartBlock > 0 {
+		go func() {
+			ret, err := indexer.IndexRecentBlocks(context.Background(), config.StartBlock)
+			if err != nil {
+				logging.Root().Error("failed to index recent blocks", "chain", c.ID, "from", config.StartBlock, "err", err)
+				return
+			}
+			logging.Root().Info("finished indexing blocks", "chain", c.ID, "from", config.StartBlock, "to", ret)
+		}()
 	}
-	// index newest blocks every minute
 	indexer.Cron("@every 1m")
 
-	s := &serviceImpl{
-		db:        gdb,
-		ethClient: ethClient,
-		indexer:   indexer,
+	return Chain{
+		Store:     db.NewReplicatedStore(store, replicas, config.MaxReplicaLag),
+		EthClient: ethClient,
+		Indexer:   indexer,
+	}, nil
+}
+
+// Chain defines a chain served by a router
+type Chain struct {
+	// Store holds data of the chain, whose ID it returns
+	Store     db.Store
+	EthClient eth.Client
+	Indexer   indexer.Indexer
+}
+
+// Options defines how a router authenticates requests and caches responses
+type Options struct {
+	Auth Auth
+	// Cache caches responses of blocks and transactions if not nil, which have ETags regardless
+	Cache   cache.Cache
+	Caching CacheConfig
+}
+
+// handler is a handler of a service serving a chain
+type handler func(s *serviceImpl, c *gin.Context)
+
+// Router serves chains, which is closed once it stops serving to flush usage of API keys
+type Router struct {
+	*gin.Engine
+	guard *guard
+}
+
+// Close stops accounting usage of API keys, adding usage pending in memory to the key store
+func (r *Router) Close() {
+	r.guard.close()
+}
+
+// New creates a router serving data of chains in their stores, falling back to their clients and indexing with their indexers;
+// the first chain is served by routes without a chain ID, and every chain by the same routes under /chains/:chainId,
+// which authenticate and limit requests as opts tells, unlike health and metrics routes
+func New(opts Options, chains ...Chain) *Router {
+	services := make(map[uint64]*serviceImpl, len(chains))
+	indexers := make([]indexer.Indexer, len(chains))
+	for j, c := range chains {
+		indexers[j] = c.Indexer
+		services[c.Store.ChainID()] = &serviceImpl{
+			store:     c.Store,
+			ethClient: c.EthClient,
+			indexer:   c.Indexer,
+			registry:  c.Indexer.Registry(),
+			responses: &responseCache{CacheConfig: opts.Caching, cache: opts.Cache, store: c.Store},
+			fetches:   newFetcher(),
+		}
 	}
+	defaultService := services[chains[0].Store.ChainID()]
+
+	r := gin.New()
+	// requests are logged, observed and traced after respondError writes their statuses
+	r.Use(logRequest, recoverRequest, observeRequest, traceRequest, respondError)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	// health of every chain is served the same way as by the indexer daemon
+	health := gin.WrapH(indexer.HealthHandler(indexers...))
+	r.GET("/healthz", health)
+	r.GET("/readyz", health)
+	r.GET("/status", health)
+	r.NoRoute(func(c *gin.Context) {
+		notFound(c, "no route for %s %s", c.Request.Method, c.Request.URL.Path)
+	})
 
-	r := gin.Default()
-	r.Use(ginerror.RespondError)
+	guard := newGuard(opts.Auth)
+	routes(r.Group("", guard.handle), func(h handler) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			h(defaultService, c)
+		}
+	})
+	routes(r.Group("/chains/:chainId", guard.handle), func(h handler) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			id, err := strconv.ParseUint(c.Param("chainId"), 10, 64)
+			s, ok := services[id]
+			if err != nil || !ok {
+				notFound(c, "unknown chain %s", c.Param("chainId"))
+				return
+			}
+			h(s, c)
+		}
+	})
+	return &Router{Engine: r, guard: guard}
+}
 
+// routes registers routes to g, whose handlers are bound to a service by bind
+func routes(g *gin.RouterGroup, bind func(h handler) gin.HandlerFunc) {
+	g.GET("/chain", bind((*serviceImpl).getChain))
 	// block group
-	blockGroup := r.Group("/blocks")
+	blockGroup := g.Group("/blocks")
 	{
-		blockGroup.GET("/", s.getBlocks)
-		blockGroup.GET("/:id", s.getBlockByHash)
+		blockGroup.GET("/", bind((*serviceImpl).getBlocks))
+		blockGroup.GET("/:id", bind((*serviceImpl).getBlockByHash))
 	}
 	// transaction group
-	transactionGroup := r.Group("/transaction")
+	transactionGroup := g.Group("/transaction")
 	{
-		transactionGroup.GET("/:txHash", s.getTransactionByHash)
+		transactionGroup.GET("/:txHash", bind((*serviceImpl).getTransactionByHash))
+		transactionGroup.GET("/:txHash/internal", bind((*serviceImpl).getInternalTransactionsByHash))
+	}
+	// transactions group
+	transactionsGroup := g.Group("/transactions")
+	{
+		transactionsGroup.GET("", bind((*serviceImpl).getTransactions))
+	}
+	// token group
+	tokenGroup := g.Group("/tokens")
+	{
+		tokenGroup.GET("/:address", bind((*serviceImpl).getToken))
+	}
+	// contract group
+	contractGroup := g.Group("/contracts")
+	{
+		contractGroup.GET("/:address", bind((*serviceImpl).getContract))
+	}
+	// abi group
+	abiGroup := g.Group("/abis")
+	{
+		abiGroup.GET("/:address", bind((*serviceImpl).getABI))
+		abiGroup.PUT("/:address", requireKey, bind((*serviceImpl).putABI))
+	}
+	// address group
+	addressGroup := g.Group("/address")
+	{
+		addressGroup.GET("/:addr/balance", bind((*serviceImpl).getBalance))
+		addressGroup.GET("/:addr/internal", bind((*serviceImpl).getInternalTransactionsByAddress))
+		addressGroup.GET("/:addr/transactions", bind((*serviceImpl).getTransactions))
+	}
+	// selector group
+	selectorGroup := g.Group("/selectors")
+	{
+		selectorGroup.GET("/:selector", bind((*serviceImpl).getSelector))
 	}
-
-	return r, nil
 }
@@ -1,43 +1,160 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/r04922101/portto/api"
+	"github.com/r04922101/portto/cache"
+	"github.com/r04922101/portto/config"
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/logging"
+	"github.com/r04922101/portto/tracing"
 )
 
-const defaultEndpoint = "https://data-seed-prebsc-2-s3.binance.org:8545/"
+// shutdownTimeout limits the time requests in flight are served on shutdown
+const shutdownTimeout = 10 * time.Second
 
 var (
-	port        = flag.String("port", ":3000", "local network address for the current service to listen on")
-	sqlHost     = flag.String("sqlHost", "localhost", "sql host")
-	sqlDB       = flag.String("sqlDB", "portto", "sql database name")
-	sqlUser     = flag.String("sqlUser", "root", "sql user")
-	sqlPassword = flag.String("sqlPassword", "portto", "sql user password")
-	sqlPort     = flag.String("sqlPort", "3306", "sql port")
-	rpcEndpoint = flag.String("rpcEndpoint", defaultEndpoint, "rpc endpoint")
+	autoMigrate = flag.Bool("autoMigrate", false, "apply schema migrations before serving, e.g. to a local SQLite file")
+	blockNumber = flag.Uint64("blockNumber", 0, "index blocks of the only configured chain from this block number in background")
 )
 
-func init() {
-	flag.Parse()
-}
-
 func main() {
-	config := api.Config{
-		SQLHost:     *sqlHost,
-		SQLDB:       *sqlDB,
-		SQLUser:     *sqlUser,
-		SQLPassword: *sqlPassword,
-		SQLPort:     *sqlPort,
-		RPCEndpoint: *rpcEndpoint,
+	cfg := config.Default()
+	flag.StringVar(&cfg.API.Port, "port", cfg.API.Port, "local network address for the current service to listen on")
+	if err := config.Load(cfg, flag.CommandLine, os.Args[1:]); err != nil {
+		logging.Root().Crit("failed to load config", "err", err)
+	}
+	if flag.Arg(0) == "config" {
+		if err := config.RunCommand(cfg, flag.Args()[1:]); err != nil {
+			logging.Root().Crit("failed to run config command", "err", err)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		logging.Root().Crit("invalid config", "err", err)
+	}
+	if err := logging.Setup(cfg.Log.Level, cfg.Log.Format); err != nil {
+		logging.Root().Crit("failed to set up logging", "err", err)
 	}
 
-	r, err := api.NewRouter(config)
+	shutdown, err := tracing.Init(context.Background(), "portto-api", tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
 	if err != nil {
-		log.Fatalf("failed to create api router: %v", err)
+		logging.Root().Crit("failed to set up tracing", "err", err)
 	}
-	if err := r.Run(*port); err != nil {
-		log.Fatalf("failed to start api server: %v", err)
+	// flush pending spans unless the process exits by a critical log
+	defer shutdown(context.Background())
+
+	if flag.Arg(0) == "migrate" {
+		gdb, err := db.InitDB(cfg.SQL.Driver, cfg.SQL.Host, cfg.SQL.DB, cfg.SQL.Port, cfg.SQL.User, cfg.SQL.Password.Reveal())
+		if err != nil {
+			logging.Root().Crit("failed to connect to sql DB", "err", err)
+		}
+		if err := db.RunMigrateCommand(gdb, flag.Args()[1:], db.LegacyChainOptions(cfg.ChainID, cfg.RPCEndpoint)); err != nil {
+			logging.Root().Crit("failed to migrate", "err", err)
+		}
+		return
+	}
+	if flag.Arg(0) == "keys" {
+		gdb, err := db.InitDB(cfg.SQL.Driver, cfg.SQL.Host, cfg.SQL.DB, cfg.SQL.Port, cfg.SQL.User, cfg.SQL.Password.Reveal())
+		if err != nil {
+			logging.Root().Crit("failed to connect to sql DB", "err", err)
+		}
+		if err := db.CheckSchema(gdb); err != nil {
+			logging.Root().Crit("failed to check schema", "err", err)
+		}
+		if err := db.RunKeysCommand(db.NewSQLKeyStore(gdb), os.Stdout, flag.Args()[1:]); err != nil {
+			logging.Root().Crit("failed to run keys command", "err", err)
+		}
+		return
+	}
+
+	if *autoMigrate {
+		gdb, err := db.InitDB(cfg.SQL.Driver, cfg.SQL.Host, cfg.SQL.DB, cfg.SQL.Port, cfg.SQL.User, cfg.SQL.Password.Reveal())
+		if err != nil {
+			logging.Root().Crit("failed to connect to sql DB", "err", err)
+		}
+		if err := db.Migrate(gdb, db.LatestVersion(), db.LegacyChainOptions(cfg.ChainID, cfg.RPCEndpoint)); err != nil {
+			logging.Root().Crit("failed to migrate", "err", err)
+		}
+	}
+
+	var chains []api.ChainConfig
+	for _, c := range cfg.AllChains() {
+		chains = append(chains, api.ChainConfig{ID: c.ID, RPCEndpoint: c.RPCEndpoint})
+	}
+	apiConfig := api.Config{
+		SQLDriver:     cfg.SQL.Driver,
+		SQLHost:       cfg.SQL.Host,
+		SQLDB:         cfg.SQL.DB,
+		SQLUser:       cfg.SQL.User,
+		SQLPassword:   cfg.SQL.Password.Reveal(),
+		SQLPort:       cfg.SQL.Port,
+		SQLReplicas:   cfg.SQL.Replicas,
+		MaxReplicaLag: cfg.SQL.MaxReplicaLag,
+		Chains:        chains,
+		ABIDir:        cfg.Indexer.ABIDir,
+		Signatures:    cfg.Indexer.Signatures,
+
+		TrackBalances: cfg.Indexer.Balances,
+		TraceInternal: cfg.Indexer.Trace,
+
+		StartBlock: *blockNumber,
+
+		Cache: cache.Config{
+			Size:          cfg.Cache.Size,
+			Redis:         cfg.Cache.Redis,
+			RedisPassword: cfg.Cache.RedisPassword.Reveal(),
+			RedisDB:       cfg.Cache.RedisDB,
+		},
+		Caching: api.CacheConfig{
+			Finality:     cfg.Cache.Finality,
+			HeadTTL:      time.Duration(cfg.Cache.HeadTTL) * time.Second,
+			FinalizedTTL: time.Duration(cfg.Cache.FinalizedTTL) * time.Second,
+		},
+
+		TrustedProxies: cfg.API.TrustedProxies,
+		Auth: api.AuthConfig{
+			Required: cfg.API.Auth.Required,
+			KeyRate:  cfg.API.Auth.KeyRate,
+			KeyBurst: cfg.API.Auth.KeyBurst,
+			IPRate:   cfg.API.Auth.IPRate,
+			IPBurst:  cfg.API.Auth.IPBurst,
+		},
+	}
+
+	r, err := api.NewRouter(apiConfig)
+	if err != nil {
+		logging.Root().Crit("failed to create api router", "err", err)
+	}
+	server := &http.Server{Addr: cfg.API.Port, Handler: r}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Root().Crit("failed to start api server", "err", err)
+		}
+	}()
+
+	// requests in flight are served before usage of API keys is flushed on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	logging.Root().Info("shutting down api server")
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(sctx); err != nil {
+		logging.Root().Error("failed to shut down api server", "err", err)
 	}
+	r.Close()
 }
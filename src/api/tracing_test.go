@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	recorder     = tracetest.NewInMemoryExporter()
+	recorderOnce sync.Once
+)
+
+// recordSpans records ended spans of the shared tracer, which is bound to the first global provider, from now on,
+// with W3C trace context propagated as tracing.Init sets it
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	recorderOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(recorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	recorder.Reset()
+	return recorder
+}
+
+func TestTraceRequest(t *testing.T) {
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		callerID = "00f067aa0ba902b7"
+	)
+	store := db.NewSQLStore(newSQLiteDB(t), chainID)
+	block := newBlock(1, "")
+	if err := store.WriteBlock(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+	client := eth.NewMemoryClient(chainID)
+	r := New(Options{}, newTestChain(t, store, client), newTestChain(t, failingStore{db.NewMemoryStore(1)}, eth.NewMemoryClient(1)))
+	spans := recordSpans(t)
+
+	w := serve(r, http.MethodGet, "/blocks/"+block.Hash, "traceparent", "00-"+traceID+"-"+callerID+"-01")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /blocks/%s = %d, %s", block.Hash, w.Code, w.Body)
+	}
+	var request sdktrace.ReadOnlySpan
+	queries := 0
+	for _, span := range spans.GetSpans().Snapshots() {
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("%s is not in the trace of the caller", span.Name())
+		}
+		switch {
+		case span.Name() == "GET /blocks/:id":
+			request = span
+		case strings.HasPrefix(span.Name(), "gorm."):
+			queries++
+		}
+	}
+	if request == nil {
+		t.Fatal("no span of the request")
+	}
+	if request.Parent().SpanID().String() != callerID || request.Status().Code == codes.Error {
+		t.Errorf("request span parent = %s, status = %+v", request.Parent().SpanID(), request.Status())
+	}
+	if queries == 0 {
+		t.Error("no span of DB queries in the request")
+	}
+
+	// server side errors fail the span of a new trace
+	spans.Reset()
+	if w := serve(r, http.MethodGet, "/chains/1/blocks/"+block.Hash); w.Code != http.StatusInternalServerError {
+		t.Fatalf("GET /chains/1/blocks/%s = %d", block.Hash, w.Code)
+	}
+	got := spans.GetSpans()
+	if len(got) != 1 || got[0].Name != "GET /chains/:chainId/blocks/:id" || got[0].Parent.IsValid() {
+		t.Fatalf("spans = %+v, want a root span of the request", got)
+	}
+	if got[0].Status.Code != codes.Error || len(got[0].Events) != 1 {
+		t.Errorf("request span status = %+v with %d events, want a recorded error", got[0].Status, len(got[0].Events))
+	}
+}
@@ -0,0 +1,185 @@
+// Package cache caches values by keys in process, in a Redis-compatible server shared by processes, or both,
+// e.g. responses of immutable blocks and transactions
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Cache defines an interface of caches expiring values after their TTLs
+type Cache interface {
+	// Get returns the value of key with the time until it expires, false if it is missing or expired
+	Get(ctx context.Context, key string) ([]byte, time.Duration, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete deletes keys, ignoring missing ones
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Config defines the caches to open, none if both Size and Redis are empty
+type Config struct {
+	// Size is the # of values cached in process, none if 0, which are invalidated by other processes only through Redis
+	Size int
+	// Redis is the host and port of a Redis-compatible server shared by processes, none if empty
+	Redis         string
+	RedisPassword string
+	RedisDB       int
+}
+
+// Open opens caches config tells, returning nil if none is;
+// if both are set, the in-process one is in front of the shared one,
+// and keys deleted from the shared one by any process are deleted from the in-process one until ctx is done
+func Open(ctx context.Context, config Config) (Cache, error) {
+	var r *redisCache
+	if config.Redis != "" {
+		var err error
+		if r, err = newRedis(ctx, config.Redis, config.RedisPassword, config.RedisDB); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case config.Size > 0 && r != nil:
+		local := NewLRU(config.Size)
+		if err := r.subscribe(ctx, local); err != nil {
+			return nil, err
+		}
+		return NewTiered(local, r), nil
+	case config.Size > 0:
+		return NewLRU(config.Size), nil
+	case r != nil:
+		return r, nil
+	}
+	return nil, nil
+}
+
+// BlockKey returns the key of the block with hash h of a chain
+func BlockKey(chainID uint64, h string) string {
+	return fmt.Sprintf("portto:%d:block:%s", chainID, h)
+}
+
+// TransactionKey returns the key of the transaction with hash h of a chain
+func TransactionKey(chainID uint64, h string) string {
+	return fmt.Sprintf("portto:%d:tx:%s", chainID, h)
+}
+
+// entry is a value of an LRU cache
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// lru keeps the most recently used values in memory
+type lru struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List // of *entry, most recently used first
+	entries map[string]*list.Element
+}
+
+// NewLRU creates an in-process cache of up to size values, evicting the least recently used ones,
+// which also stands in for a shared cache, e.g. in tests
+func NewLRU(size int) Cache {
+	return &lru{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *lru) Get(ctx context.Context, key string) ([]byte, time.Duration, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, 0, false, nil
+	}
+	e := el.Value.(*entry)
+	ttl := time.Until(e.expires)
+	if ttl <= 0 {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, 0, false, nil
+	}
+	c.order.MoveToFront(el)
+	return e.value, ttl, true, nil
+}
+
+func (c *lru) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &entry{key: key, value: value, expires: time.Now().Add(ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.entries, el.Value.(*entry).key)
+	}
+	return nil
+}
+
+func (c *lru) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range keys {
+		if el, ok := c.entries[k]; ok {
+			c.order.Remove(el)
+			delete(c.entries, k)
+		}
+	}
+	return nil
+}
+
+// tiered looks up caches in order, filling the ones in front with values found behind
+type tiered struct {
+	caches []Cache
+}
+
+// NewTiered creates a cache of caches, e.g. an in-process one in front of a shared one,
+// which writes and deletes values in every cache
+func NewTiered(caches ...Cache) Cache {
+	return &tiered{caches: caches}
+}
+
+func (t *tiered) Get(ctx context.Context, key string) ([]byte, time.Duration, bool, error) {
+	for i, c := range t.caches {
+		v, ttl, ok, err := c.Get(ctx, key)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if !ok {
+			continue
+		}
+		for _, front := range t.caches[:i] {
+			if err := front.Set(ctx, key, v, ttl); err != nil {
+				return nil, 0, false, err
+			}
+		}
+		return v, ttl, true, nil
+	}
+	return nil, 0, false, nil
+}
+
+func (t *tiered) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	for _, c := range t.caches {
+		if err := c.Set(ctx, key, value, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tiered) Delete(ctx context.Context, keys ...string) error {
+	// values behind are deleted first, so that they do not fill the ones in front again
+	for i := len(t.caches) - 1; i >= 0; i-- {
+		if err := t.caches[i].Delete(ctx, keys...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// get returns the value of key in c, failing the test on errors
+func get(t *testing.T, c Cache, key string) (string, bool) {
+	t.Helper()
+	v, _, ok, err := c.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("failed to get %s: %v", key, err)
+	}
+	return string(v), ok
+}
+
+// set sets key to value in c for ttl, failing the test on errors
+func set(t *testing.T, c Cache, key, value string, ttl time.Duration) {
+	t.Helper()
+	if err := c.Set(context.Background(), key, []byte(value), ttl); err != nil {
+		t.Fatalf("failed to set %s: %v", key, err)
+	}
+}
+
+// startRedis starts a Redis-compatible server in process, which is closed when the test ends
+func startRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("failed to start redis: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2)
+	set(t, c, "a", "1", time.Minute)
+	set(t, c, "b", "2", time.Minute)
+	// a is used more recently than b, which is evicted by c
+	get(t, c, "a")
+	set(t, c, "c", "3", time.Minute)
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := get(t, c, key); ok != want {
+			t.Errorf("%s cached = %v, want %v", key, ok, want)
+		}
+	}
+
+	set(t, c, "a", "4", time.Minute)
+	if v, ok := get(t, c, "a"); !ok || v != "4" {
+		t.Errorf("a = %q, %v, want 4", v, ok)
+	}
+	if _, ttl, _, _ := c.Get(ctx, "a"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("ttl = %v, want within a minute", ttl)
+	}
+	set(t, c, "c", "5", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok := get(t, c, "c"); ok {
+		t.Error("got an expired value")
+	}
+	if err := c.Delete(ctx, "a", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := get(t, c, "a"); ok {
+		t.Error("got a deleted value")
+	}
+}
+
+func TestTiered(t *testing.T) {
+	ctx := context.Background()
+	front, back := NewLRU(10), NewLRU(10)
+	c := NewTiered(front, back)
+
+	set(t, back, "a", "1", time.Minute)
+	if v, ok := get(t, c, "a"); !ok || v != "1" {
+		t.Fatalf("a = %q, %v, want 1", v, ok)
+	}
+	// a value found behind fills the caches in front
+	if v, ok := get(t, front, "a"); !ok || v != "1" {
+		t.Errorf("a in front = %q, %v, want 1", v, ok)
+	}
+
+	set(t, c, "b", "2", time.Minute)
+	if err := c.Delete(ctx, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	for _, cache := range []Cache{front, back} {
+		for _, key := range []string{"a", "b"} {
+			if _, ok := get(t, cache, key); ok {
+				t.Errorf("%s is not deleted from every cache", key)
+			}
+		}
+	}
+}
+
+func TestRedis(t *testing.T) {
+	ctx := context.Background()
+	s := startRedis(t)
+	if _, err := NewRedis(ctx, "127.0.0.1:1", "", 0); err == nil {
+		t.Error("connected to an unreachable redis")
+	}
+	c, err := NewRedis(ctx, s.Addr(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := get(t, c, "a"); ok {
+		t.Error("got a missing value")
+	}
+	set(t, c, "a", "1", time.Minute)
+	v, ttl, ok, err := c.Get(ctx, "a")
+	if err != nil || !ok || string(v) != "1" || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("a = %q, %v, %v, %v, want 1 within a minute", v, ttl, ok, err)
+	}
+	s.FastForward(time.Minute)
+	if _, ok := get(t, c, "a"); ok {
+		t.Error("got an expired value")
+	}
+
+	set(t, c, "b", "2", time.Minute)
+	sub := s.NewSubscriber()
+	defer sub.Close()
+	sub.Subscribe(invalidations)
+	// the server blocks publishing until the message is received
+	published := make(chan string, 1)
+	go func() {
+		published <- (<-sub.Messages()).Message
+	}()
+	if err := c.Delete(ctx, "b", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-published:
+		if msg != "b\nmissing" {
+			t.Errorf("published %q, want deleted keys", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("deleted keys are not published")
+	}
+	if _, ok := get(t, c, "b"); ok {
+		t.Error("got a deleted value")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := startRedis(t)
+
+	tests := []struct {
+		config Config
+		want   string
+	}{
+		{Config{}, "<nil>"},
+		{Config{Size: 10}, "*cache.lru"},
+		{Config{Redis: s.Addr()}, "*cache.redisCache"},
+		{Config{Size: 10, Redis: s.Addr()}, "*cache.tiered"},
+	}
+	for _, tt := range tests {
+		c, err := Open(ctx, tt.config)
+		if err != nil {
+			t.Fatalf("failed to open %+v: %v", tt.config, err)
+		}
+		if got := typeName(c); got != tt.want {
+			t.Errorf("Open(%+v) = %s, want %s", tt.config, got, tt.want)
+		}
+	}
+	if _, err := Open(ctx, Config{Size: 10, Redis: "127.0.0.1:1"}); err == nil {
+		t.Error("opened an unreachable redis")
+	}
+}
+
+func TestOpenInvalidates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := startRedis(t)
+	// a is cached by an api server in process, and deleted by an indexer in another process
+	server, err := Open(ctx, Config{Size: 10, Redis: s.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexer, err := Open(ctx, Config{Redis: s.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	set(t, server, "a", "1", time.Minute)
+	set(t, server, "b", "2", time.Minute)
+	local := server.(*tiered).caches[0]
+
+	if err := indexer.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, ok := get(t, local, "a"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("a deleted by another process is kept in process")
+		}
+	}
+	if v, ok := get(t, server, "b"); !ok || v != "2" {
+		t.Errorf("b = %q, %v, want 2", v, ok)
+	}
+}
+
+// typeName returns the type of c, <nil> if it is nil
+func typeName(c Cache) string {
+	return fmt.Sprintf("%T", c)
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisTimeout limits the time of connecting and subscribing to the shared cache
+const redisTimeout = 5 * time.Second
+
+// invalidations is the channel of keys deleted from the shared cache, one per line,
+// which processes caching values in front of it delete as well
+const invalidations = "portto:invalidations"
+
+// redisCache keeps values in a Redis-compatible server, e.g. Redis, Valkey or KeyDB
+type redisCache struct {
+	client *redis.Client
+}
+
+// NewRedis creates a cache in the database db of the Redis-compatible server at addr, checking it is reachable
+func NewRedis(ctx context.Context, addr, password string, db int) (Cache, error) {
+	return newRedis(ctx, addr, password, db)
+}
+
+func newRedis(ctx context.Context, addr, password string, db int) (*redisCache, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr, Password: password, DB: db})
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis at %s: %v", addr, err)
+	}
+	return &redisCache{client: client}, nil
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, time.Duration, bool, error) {
+	var (
+		get *redis.StringCmd
+		ttl *redis.DurationCmd
+	)
+	// the value and its TTL are read in a round trip
+	if _, err := c.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		get = p.Get(ctx, key)
+		ttl = p.PTTL(ctx, key)
+		return nil
+	}); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, false, fmt.Errorf("failed to get %s from redis: %v", key, err)
+	}
+	v, err := get.Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, 0, false, nil
+	} else if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to get %s from redis: %v", key, err)
+	}
+	// the key may expire between the commands
+	if ttl.Val() <= 0 {
+		return nil, 0, false, nil
+	}
+	return v, ttl.Val(), true, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := c.client.Set(ctx, key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set %s in redis: %v", key, err)
+	}
+	return nil
+}
+
+func (c *redisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to delete keys from redis: %v", err)
+	}
+	if err := c.client.Publish(ctx, invalidations, strings.Join(keys, "\n")).Err(); err != nil {
+		return fmt.Errorf("failed to publish deleted keys to redis: %v", err)
+	}
+	return nil
+}
+
+// subscribe deletes keys deleted from the shared cache by any process from local until ctx is done;
+// keys deleted while the subscription reconnects are kept in local until they expire
+func (c *redisCache) subscribe(ctx context.Context, local Cache) error {
+	sub := c.client.Subscribe(ctx, invalidations)
+	// no key deleted after subscribe returns is missed once the subscription is confirmed
+	rctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	if _, err := sub.Receive(rctx); err != nil {
+		sub.Close()
+		return fmt.Errorf("failed to subscribe to %s in redis: %v", invalidations, err)
+	}
+	ch := sub.Channel()
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				local.Delete(ctx, strings.Split(msg.Payload, "\n")...)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+	"github.com/r04922101/portto/logging"
+)
+
+// invalidatingStore deletes cached values of blocks replaced by blocks of different hashes or deleted, and of their transactions
+type invalidatingStore struct {
+	db.Store
+	cache Cache
+}
+
+// NewInvalidatingStore creates a store writing to store, which invalidates values of reorganized, deleted and pruned blocks in cache
+func NewInvalidatingStore(store db.Store, cache Cache) db.Store {
+	return &invalidatingStore{Store: store, cache: cache}
+}
+
+func (s *invalidatingStore) WriteBlock(ctx context.Context, block *eth.Block) error {
+	old, err := s.replaced(ctx, []*eth.Block{block})
+	if err != nil {
+		return err
+	}
+	if err := s.Store.WriteBlock(ctx, block); err != nil {
+		return err
+	}
+	s.invalidate(ctx, old)
+	return nil
+}
+
+func (s *invalidatingStore) WriteBlocks(ctx context.Context, blocks []*eth.Block, batchSize int) error {
+	old, err := s.replaced(ctx, blocks)
+	if err != nil {
+		return err
+	}
+	if err := s.Store.WriteBlocks(ctx, blocks, batchSize); err != nil {
+		return err
+	}
+	s.invalidate(ctx, old)
+	return nil
+}
+
+func (s *invalidatingStore) DeleteRange(ctx context.Context, from, to uint64) error {
+	old, err := s.Store.ListBlockHashes(ctx, from, to, true)
+	if err != nil {
+		return err
+	}
+	if err := s.Store.DeleteRange(ctx, from, to); err != nil {
+		return err
+	}
+	s.invalidate(ctx, old)
+	return nil
+}
+
+// Prune lists hashes of each chunk before pruning it, so that their values are invalidated chunk by chunk
+func (s *invalidatingStore) Prune(ctx context.Context, before uint64, chunkSize int) error {
+	latest, err := s.Store.LatestNum(ctx)
+	if err != nil {
+		return err
+	}
+	earliest, err := s.Store.EarliestNum(ctx)
+	if err != nil {
+		return err
+	}
+	if latest == 0 || earliest >= before {
+		return s.Store.Prune(ctx, before, chunkSize)
+	}
+	if chunkSize <= 0 {
+		chunkSize = db.DefaultChunkSize
+	}
+	for from := earliest; from < before; from += uint64(chunkSize) {
+		to := from + uint64(chunkSize)
+		if to > before {
+			to = before
+		}
+		old, err := s.Store.ListBlockHashes(ctx, from, to-1, true)
+		if err != nil {
+			return err
+		}
+		if err := s.Store.Prune(ctx, to, chunkSize); err != nil {
+			return err
+		}
+		s.invalidate(ctx, old)
+	}
+	return nil
+}
+
+// replaced returns hashes of blocks of the numbers of blocks but different hashes, which blocks replace,
+// with hashes of their transactions, which are listed only if there are such blocks
+func (s *invalidatingStore) replaced(ctx context.Context, blocks []*eth.Block) ([]db.BlockHashes, error) {
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+	hashes := make(map[uint64]string, len(blocks))
+	from, to := blocks[0].Num, blocks[0].Num
+	for _, b := range blocks {
+		hashes[b.Num] = b.Hash
+		if b.Num < from {
+			from = b.Num
+		}
+		if b.Num > to {
+			to = b.Num
+		}
+	}
+	existing, err := s.Store.ListBlockHashes(ctx, from, to, false)
+	if err != nil {
+		return nil, err
+	}
+	var reorged []uint64
+	for _, e := range existing {
+		if h, ok := hashes[e.Num]; ok && h != e.Hash {
+			reorged = append(reorged, e.Num)
+		}
+	}
+	if len(reorged) == 0 {
+		return nil, nil
+	}
+
+	old, err := s.Store.ListBlockHashes(ctx, reorged[0], reorged[len(reorged)-1], true)
+	if err != nil {
+		return nil, err
+	}
+	ret := old[:0]
+	for _, o := range old {
+		if h, ok := hashes[o.Num]; ok && h != o.Hash {
+			ret = append(ret, o)
+		}
+	}
+	return ret, nil
+}
+
+// invalidate deletes values of blocks and their transactions
+func (s *invalidatingStore) invalidate(ctx context.Context, blocks []db.BlockHashes) {
+	if len(blocks) == 0 {
+		return
+	}
+	chainID := s.Store.ChainID()
+	var keys []string
+	for _, b := range blocks {
+		keys = append(keys, BlockKey(chainID, b.Hash))
+		for _, h := range b.Transactions {
+			keys = append(keys, TransactionKey(chainID, h))
+		}
+	}
+	if err := s.cache.Delete(ctx, keys...); err != nil {
+		// values expire after their TTLs anyway, which are short for blocks not finalized yet
+		logging.FromContext(ctx).Warn("failed to invalidate cache of replaced or deleted blocks", "chain", chainID,
+			"from", blocks[0].Num, "to", blocks[len(blocks)-1].Num, "err", err)
+	}
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+)
+
+const chainID = 97
+
+// hash returns a distinct hash in hex of a block or transaction, e.g. hash("block", 1, "")
+func hash(kind string, n uint64, fork string) string {
+	return crypto.Keccak256Hash([]byte(fmt.Sprintf("%s/%d/%s", kind, n, fork))).Hex()
+}
+
+// newBlock creates the block numbered n of fork, an empty string for the canonical one, with a transaction
+func newBlock(n uint64, fork string) *eth.Block {
+	return &eth.Block{
+		Num:          n,
+		Hash:         hash("block", n, fork),
+		ParentHash:   hash("block", n-1, fork),
+		Time:         1700000000 + 3*n,
+		Transactions: []eth.Transaction{{Hash: hash("tx", n, fork)}},
+	}
+}
+
+// hashOnlyStore fails reads of whole blocks, which invalidation does without
+type hashOnlyStore struct {
+	db.Store
+}
+
+func (hashOnlyStore) GetBlockByNumber(ctx context.Context, n uint64) (*eth.Block, error) {
+	return nil, fmt.Errorf("block %d is read with its transactions", n)
+}
+
+// newCachedStore creates an invalidating store of canonical blocks numbered from 1 to n in memory,
+// with values of every block and transaction in cache
+func newCachedStore(t *testing.T, n uint64) (db.Store, Cache) {
+	t.Helper()
+	c := NewLRU(100)
+	s := NewInvalidatingStore(hashOnlyStore{db.NewMemoryStore(chainID)}, c)
+	for i := uint64(1); i <= n; i++ {
+		if err := s.WriteBlock(context.Background(), newBlock(i, "")); err != nil {
+			t.Fatal(err)
+		}
+		set(t, c, BlockKey(chainID, hash("block", i, "")), "block", time.Minute)
+		set(t, c, TransactionKey(chainID, hash("tx", i, "")), "tx", time.Minute)
+	}
+	return s, c
+}
+
+// cached returns numbers of canonical blocks from 1 to n, whose values and transaction values are cached
+func cached(t *testing.T, c Cache, n uint64) []uint64 {
+	t.Helper()
+	var ret []uint64
+	for i := uint64(1); i <= n; i++ {
+		_, block := get(t, c, BlockKey(chainID, hash("block", i, "")))
+		_, tx := get(t, c, TransactionKey(chainID, hash("tx", i, "")))
+		if block != tx {
+			t.Errorf("block %d cached = %v, but its transaction = %v", i, block, tx)
+		}
+		if block {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+func TestInvalidatingStore(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		write func(s db.Store) error
+		want  []uint64
+	}{
+		{"rewrite", func(s db.Store) error {
+			return s.WriteBlock(ctx, newBlock(3, ""))
+		}, []uint64{1, 2, 3, 4, 5}},
+		{"reorg", func(s db.Store) error {
+			return s.WriteBlock(ctx, newBlock(3, "fork"))
+		}, []uint64{1, 2, 4, 5}},
+		{"bulk reorg", func(s db.Store) error {
+			return s.WriteBlocks(ctx, []*eth.Block{newBlock(4, "fork"), newBlock(5, ""), newBlock(6, "")}, 10)
+		}, []uint64{1, 2, 3, 5}},
+		{"delete range", func(s db.Store) error {
+			return s.DeleteRange(ctx, 4, 10)
+		}, []uint64{1, 2, 3}},
+		{"prune", func(s db.Store) error {
+			return s.Prune(ctx, 4, 2)
+		}, []uint64{4, 5}},
+		{"prune in default chunks", func(s db.Store) error {
+			return s.Prune(ctx, 4, 0)
+		}, []uint64{4, 5}},
+	}
+	for _, tt := range tests {
+		s, c := newCachedStore(t, 5)
+		if err := tt.write(s); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := cached(t, c, 5); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: cached blocks = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// pruning deletes blocks as the store does
+	s, _ := newCachedStore(t, 5)
+	if err := s.Prune(ctx, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.EarliestNum(ctx); err != nil || n != 4 {
+		t.Errorf("earliest block = %d, %v, want 4", n, err)
+	}
+}
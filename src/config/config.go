@@ -0,0 +1,389 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// defaultChainID is BSC testnet served by defaultEndpoint
+	defaultChainID  = 97
+	defaultEndpoint = "https://data-seed-prebsc-2-s3.binance.org:8545/"
+)
+
+// supported SQL drivers, which are the ones of package db
+var drivers = []string{"mysql", "postgres", "sqlite"}
+
+// Config defines settings shared by the api server and the indexer
+type Config struct {
+	SQL SQL `yaml:"sql" toml:"sql"`
+	// ChainID is the ID of the chain served by RPCEndpoint, which is the one API routes without a chain ID serve
+	ChainID     uint64 `yaml:"chain_id" toml:"chain_id"`
+	RPCEndpoint string `yaml:"rpc_endpoint" toml:"rpc_endpoint"`
+	// Chains are more chains indexed side by side in the same DB
+	Chains  Chains  `yaml:"chains" toml:"chains"`
+	Indexer Indexer `yaml:"indexer" toml:"indexer"`
+	API     API     `yaml:"api" toml:"api"`
+	Tracing Tracing `yaml:"tracing" toml:"tracing"`
+	Log     Log     `yaml:"log" toml:"log"`
+	Cache   Cache   `yaml:"cache" toml:"cache"`
+}
+
+// Chain defines a chain to index
+type Chain struct {
+	ID          uint64 `yaml:"id" toml:"id"`
+	RPCEndpoint string `yaml:"rpc_endpoint" toml:"rpc_endpoint"`
+}
+
+// Chains is a list of chains, which is set by flags and env vars as comma separated `<id>=<rpc endpoint>`
+type Chains []Chain
+
+func (cs Chains) String() string {
+	s := make([]string, len(cs))
+	for i, c := range cs {
+		s[i] = fmt.Sprintf("%d=%s", c.ID, c.RPCEndpoint)
+	}
+	return strings.Join(s, ",")
+}
+
+// Set implements the flag.Value interface
+func (cs *Chains) Set(v string) error {
+	var ret Chains
+	for _, s := range splitList(v) {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("bad chain %q, <id>=<rpc endpoint>", s)
+		}
+		id, err := strconv.ParseUint(kv[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad chain ID %q", kv[0])
+		}
+		ret = append(ret, Chain{ID: id, RPCEndpoint: kv[1]})
+	}
+	*cs = ret
+	return nil
+}
+
+// AllChains returns the chain of ChainID followed by Chains
+func (c *Config) AllChains() []Chain {
+	return append([]Chain{{ID: c.ChainID, RPCEndpoint: c.RPCEndpoint}}, c.Chains...)
+}
+
+// SQL defines settings of the SQL DB
+type SQL struct {
+	Driver string `yaml:"driver" toml:"driver"` // mysql, postgres or sqlite
+	Host   string `yaml:"host" toml:"host"`
+	// Port defaults to 3306 for mysql and 5432 for postgres if empty
+	Port string `yaml:"port" toml:"port"`
+	// DB is the database name, or the file path for sqlite
+	DB       string `yaml:"db" toml:"db"`
+	User     string `yaml:"user" toml:"user"`
+	Password Secret `yaml:"password" toml:"password"`
+	// Replicas are hosts of read replicas, optionally with ports, sharing the other settings
+	Replicas []string `yaml:"replicas" toml:"replicas"`
+	// MaxReplicaLag is the # of blocks replicas may lag behind the primary to serve reads of recent data
+	MaxReplicaLag uint64 `yaml:"max_replica_lag" toml:"max_replica_lag"`
+}
+
+// Indexer defines settings of indexing blocks
+type Indexer struct {
+	Workers int `yaml:"workers" toml:"workers"`
+	// Balances enables the stage storing native balances of addresses touched in each block
+	Balances bool `yaml:"balances" toml:"balances"`
+	// Trace enables the stage storing internal transactions traced by `debug_traceBlockByNumber`
+	Trace bool `yaml:"trace" toml:"trace"`
+	// ABIDir is a directory of `<address>.json` ABI files to register, skipped if empty
+	ABIDir string `yaml:"abi_dir" toml:"abi_dir"`
+	// Signatures is a file of method signatures to decode inputs with, skipped if empty
+	Signatures string    `yaml:"signatures" toml:"signatures"`
+	Retention  Retention `yaml:"retention" toml:"retention"`
+	// Listen is the local network address, on which the indexer daemon serves health, status and metrics
+	Listen string `yaml:"listen" toml:"listen"`
+}
+
+// Retention defines the policy pruning old blocks, ignoring zero limits
+type Retention struct {
+	Blocks        uint64 `yaml:"blocks" toml:"blocks"`
+	Days          int    `yaml:"days" toml:"days"`
+	PartitionSize uint64 `yaml:"partition_size" toml:"partition_size"`
+	PruneChunk    int    `yaml:"prune_chunk" toml:"prune_chunk"`
+}
+
+// API defines settings of the api server
+type API struct {
+	// Port is the local network address to listen on
+	Port string `yaml:"port" toml:"port"`
+	// TrustedProxies are IPs or CIDRs of proxies, whose X-Forwarded-For headers give client IPs, none if empty
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	Auth           Auth     `yaml:"auth" toml:"auth"`
+}
+
+// Auth defines API key authentication and rate limits of requests per second, which are disabled if 0
+type Auth struct {
+	// Required rejects requests without a valid API key, which are limited by client IP otherwise
+	Required bool `yaml:"required" toml:"required"`
+	// KeyRate and KeyBurst limit requests with each API key, unless the key has its own limits
+	KeyRate  float64 `yaml:"key_rate" toml:"key_rate"`
+	KeyBurst int     `yaml:"key_burst" toml:"key_burst"`
+	// IPRate and IPBurst limit requests without a valid API key from each client IP
+	IPRate  float64 `yaml:"ip_rate" toml:"ip_rate"`
+	IPBurst int     `yaml:"ip_burst" toml:"ip_burst"`
+}
+
+// Tracing defines where OpenTelemetry spans are exported to
+type Tracing struct {
+	// Exporter is none, stdout or otlp
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// Endpoint is the host and port of the OTLP/HTTP collector, and Insecure disables TLS to it
+	Endpoint string `yaml:"endpoint" toml:"endpoint"`
+	Insecure bool   `yaml:"insecure" toml:"insecure"`
+	// SampleRatio is the ratio of traces started by the process to sample
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// Cache defines caches of block and transaction responses of the api server,
+// which the indexer invalidates on reorgs if they are shared
+type Cache struct {
+	// Size is the # of responses cached in process by the api server, none if 0,
+	// which are invalidated on reorgs indexed by other processes only through Redis
+	Size int `yaml:"size" toml:"size"`
+	// Redis is the host and port of a Redis-compatible server shared by processes, none if empty
+	Redis         string `yaml:"redis" toml:"redis"`
+	RedisPassword Secret `yaml:"redis_password" toml:"redis_password"`
+	RedisDB       int    `yaml:"redis_db" toml:"redis_db"`
+	// Finality is the # of blocks the latest indexed block is ahead of finalized blocks, whose data is immutable
+	Finality uint64 `yaml:"finality" toml:"finality"`
+	// HeadTTL and FinalizedTTL are the seconds responses of data not finalized and finalized are cached for
+	HeadTTL      int `yaml:"head_ttl" toml:"head_ttl"`
+	FinalizedTTL int `yaml:"finalized_ttl" toml:"finalized_ttl"`
+}
+
+// Log defines how logs are written
+type Log struct {
+	// Level is debug, info, warn or error, and Format is text or json
+	Level  string `yaml:"level" toml:"level"`
+	Format string `yaml:"format" toml:"format"`
+}
+
+// supported log levels and formats, which are the ones of package logging
+var (
+	logLevels  = []string{"debug", "info", "warn", "error"}
+	logFormats = []string{"text", "json"}
+)
+
+// supported tracing exporters, which are the ones of package tracing
+var exporters = []string{"none", "stdout", "otlp"}
+
+// Default returns the config used for settings given by neither files, env vars nor flags
+func Default() *Config {
+	return &Config{
+		SQL: SQL{
+			Driver:        "mysql",
+			Host:          "localhost",
+			DB:            "portto",
+			User:          "root",
+			MaxReplicaLag: 10,
+		},
+		ChainID:     defaultChainID,
+		RPCEndpoint: defaultEndpoint,
+		Indexer: Indexer{
+			Workers: runtime.NumCPU(),
+			Retention: Retention{
+				PruneChunk: 1000,
+			},
+			Listen: ":3001",
+		},
+		API: API{
+			Port: ":3000",
+			Auth: Auth{
+				KeyRate:  20,
+				KeyBurst: 40,
+				IPRate:   5,
+				IPBurst:  10,
+			},
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+		},
+		Cache: Cache{
+			Size:         10000,
+			Finality:     64,
+			HeadTTL:      3,
+			FinalizedTTL: 86400,
+		},
+	}
+}
+
+// ValidationError lists problems of a config
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid config:\n  - " + strings.Join(e, "\n  - ")
+}
+
+// Validate returns a ValidationError listing every invalid setting, nil if there is none
+func (c *Config) Validate() error {
+	var errs ValidationError
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	sqlite := c.SQL.Driver == "sqlite"
+	if !oneOf(c.SQL.Driver, drivers) {
+		add("sql.driver %q is not one of %s", c.SQL.Driver, strings.Join(drivers, ", "))
+	}
+	if c.SQL.DB == "" {
+		add("sql.db is required")
+	}
+	if !sqlite {
+		if c.SQL.Host == "" {
+			add("sql.host is required for %s", c.SQL.Driver)
+		}
+		if c.SQL.User == "" {
+			add("sql.user is required for %s", c.SQL.Driver)
+		}
+		if c.SQL.Password == "" {
+			add("sql.password is required for %s, set it in a config file or PORTTO_SQL_PASSWORD", c.SQL.Driver)
+		}
+	}
+	if p := c.SQL.Port; p != "" {
+		if _, err := strconv.ParseUint(p, 10, 16); err != nil {
+			add("sql.port %q is not a port number", p)
+		}
+	}
+	if sqlite && len(c.SQL.Replicas) > 0 {
+		add("sql.replicas are not supported by sqlite")
+	}
+	if c.RPCEndpoint == "" {
+		add("rpc_endpoint is required")
+	}
+	seen := map[uint64]bool{}
+	for i, chain := range c.AllChains() {
+		// the first chain is the one of chain_id and rpc_endpoint
+		name := "chain_id"
+		if i > 0 {
+			name = fmt.Sprintf("chains[%d].id", i-1)
+			if chain.RPCEndpoint == "" {
+				add("chains[%d].rpc_endpoint is required", i-1)
+			}
+		}
+		if chain.ID == 0 {
+			add("%s is required", name)
+		} else if seen[chain.ID] {
+			add("%s %d is duplicated", name, chain.ID)
+		}
+		seen[chain.ID] = true
+	}
+	if c.Indexer.Workers <= 0 {
+		add("indexer.workers %d must be positive", c.Indexer.Workers)
+	}
+	if r := c.Indexer.Retention; r.Days < 0 {
+		add("indexer.retention.days %d must not be negative", r.Days)
+	}
+	if r := c.Indexer.Retention; r.PruneChunk <= 0 {
+		add("indexer.retention.prune_chunk %d must be positive", r.PruneChunk)
+	}
+	if r := c.Indexer.Retention; r.PartitionSize > 0 && c.SQL.Driver != "mysql" {
+		add("indexer.retention.partition_size is only supported by mysql")
+	}
+	if c.Indexer.Listen == "" {
+		add("indexer.listen is required")
+	}
+	if c.API.Port == "" {
+		add("api.port is required")
+	}
+	if a := c.API.Auth; a.KeyRate < 0 || a.KeyBurst < 0 {
+		add("api.auth.key_rate and key_burst must not be negative")
+	} else if a.KeyRate > 0 && a.KeyBurst == 0 {
+		add("api.auth.key_burst is required with key_rate")
+	}
+	if a := c.API.Auth; a.IPRate < 0 || a.IPBurst < 0 {
+		add("api.auth.ip_rate and ip_burst must not be negative")
+	} else if a.IPRate > 0 && a.IPBurst == 0 {
+		add("api.auth.ip_burst is required with ip_rate")
+	}
+	for _, p := range c.API.TrustedProxies {
+		if net.ParseIP(p) == nil {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				add("api.trusted_proxies %q is neither an IP nor a CIDR", p)
+			}
+		}
+	}
+	if !oneOf(c.Tracing.Exporter, exporters) {
+		add("tracing.exporter %q is not one of %s", c.Tracing.Exporter, strings.Join(exporters, ", "))
+	}
+	if c.Tracing.Exporter == "otlp" && c.Tracing.Endpoint == "" {
+		add("tracing.endpoint is required for otlp")
+	}
+	if r := c.Tracing.SampleRatio; r < 0 || r > 1 {
+		add("tracing.sample_ratio %v must be between 0 and 1", r)
+	}
+	if !oneOf(c.Log.Level, logLevels) {
+		add("log.level %q is not one of %s", c.Log.Level, strings.Join(logLevels, ", "))
+	}
+	if !oneOf(c.Log.Format, logFormats) {
+		add("log.format %q is not one of %s", c.Log.Format, strings.Join(logFormats, ", "))
+	}
+	if c.Cache.Size < 0 {
+		add("cache.size %d must not be negative", c.Cache.Size)
+	}
+	if c.Cache.RedisDB < 0 {
+		add("cache.redis_db %d must not be negative", c.Cache.RedisDB)
+	}
+	if c.Cache.HeadTTL <= 0 {
+		add("cache.head_ttl %d must be positive", c.Cache.HeadTTL)
+	}
+	if c.Cache.FinalizedTTL <= 0 {
+		add("cache.finalized_ttl %d must be positive", c.Cache.FinalizedTTL)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// oneOf returns if v is one of values
+func oneOf(v string, values []string) bool {
+	for _, s := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the config in YAML with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// RunCommand runs the `config` subcommand with its args:
+//
+//	config print  prints the effective config with secrets redacted, and problems of it
+func RunCommand(c *Config, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("usage: config print")
+	}
+	if err := c.Print(os.Stdout); err != nil {
+		return err
+	}
+	return c.Validate()
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err == nil || !strings.Contains(err.Error(), "sql.password is required") {
+		t.Errorf("default config without password is validated: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"sqlite without password", func(c *Config) { c.SQL.Driver, c.SQL.Password = "sqlite", "" }, ""},
+		{"driver", func(c *Config) { c.SQL.Driver = "oracle" }, "sql.driver"},
+		{"port", func(c *Config) { c.SQL.Port = "70000" }, "sql.port"},
+		{"sqlite replicas", func(c *Config) { c.SQL.Driver, c.SQL.Replicas = "sqlite", []string{"r1"} }, "sql.replicas"},
+		{"endpoint", func(c *Config) { c.RPCEndpoint = "" }, "rpc_endpoint"},
+		{"duplicated chain", func(c *Config) { c.Chains = Chains{{ID: 97, RPCEndpoint: "x"}} }, "chains[0].id 97 is duplicated"},
+		{"chain endpoint", func(c *Config) { c.Chains = Chains{{ID: 56}} }, "chains[0].rpc_endpoint"},
+		{"workers", func(c *Config) { c.Indexer.Workers = 0 }, "indexer.workers"},
+		{"chunk", func(c *Config) { c.Indexer.Retention.PruneChunk = 0 }, "prune_chunk"},
+		{"partitions", func(c *Config) { c.SQL.Driver, c.Indexer.Retention.PartitionSize = "postgres", 100 }, "partition_size"},
+		{"burst", func(c *Config) { c.API.Auth.KeyBurst = 0 }, "key_burst is required"},
+		{"proxy", func(c *Config) { c.API.TrustedProxies = []string{"10.0.0.0/33"} }, "trusted_proxies"},
+		{"otlp", func(c *Config) { c.Tracing.Exporter = "otlp" }, "tracing.endpoint"},
+		{"log level", func(c *Config) { c.Log.Level = "trace" }, "log.level"},
+		{"ttl", func(c *Config) { c.Cache.HeadTTL = 0 }, "cache.head_ttl"},
+	}
+	for _, tt := range tests {
+		c := Default()
+		c.SQL.Password = "password"
+		tt.modify(c)
+		err := c.Validate()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: config is invalid: %v", tt.name, err)
+			}
+			continue
+		}
+		var verr ValidationError
+		if !errors.As(err, &verr) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// every problem is listed
+	c := Default()
+	c.SQL.Driver, c.Log.Format = "oracle", "xml"
+	if err := c.Validate(); err == nil || len(err.(ValidationError)) != 3 {
+		t.Errorf("problems = %v", err)
+	}
+}
+
+func TestSecretRedacted(t *testing.T) {
+	c := Default()
+	c.SQL.Password = "hunter2"
+	var buf bytes.Buffer
+	if err := c.Print(&buf); err != nil {
+		t.Fatalf("failed to print config: %v", err)
+	}
+	for _, s := range []string{buf.String(), fmt.Sprint(c.SQL), fmt.Sprintf("%+v", c.SQL), fmt.Sprintf("%#v", c.SQL)} {
+		if strings.Contains(s, "hunter2") {
+			t.Errorf("secret is revealed in %s", s)
+		}
+	}
+	if !strings.Contains(buf.String(), "password: '******'") && !strings.Contains(buf.String(), `password: "******"`) {
+		t.Errorf("password is not redacted in %s", buf.String())
+	}
+	if c.SQL.Password.Reveal() != "hunter2" {
+		t.Errorf("revealed secret = %s", c.SQL.Password.Reveal())
+	}
+}
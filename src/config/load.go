@@ -0,0 +1,188 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix prefixes env vars of settings, e.g. PORTTO_SQL_PASSWORD for sql.password
+const EnvPrefix = "PORTTO"
+
+// stringList is a flag of comma separated strings
+type stringList struct {
+	list *[]string
+}
+
+func (l stringList) String() string {
+	if l.list == nil {
+		return ""
+	}
+	return strings.Join(*l.list, ",")
+}
+
+func (l stringList) Set(v string) error {
+	*l.list = splitList(v)
+	return nil
+}
+
+// splitList splits comma separated strings, ignoring empty ones
+func splitList(s string) []string {
+	var ret []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// bindFlags defines flags of settings shared by the api server and the indexer on fs
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.SQL.Driver, "sqlDriver", c.SQL.Driver, "sql driver, mysql, postgres or sqlite")
+	fs.StringVar(&c.SQL.Host, "sqlHost", c.SQL.Host, "sql host")
+	fs.StringVar(&c.SQL.DB, "sqlDB", c.SQL.DB, "sql database name, or file path for sqlite")
+	fs.StringVar(&c.SQL.User, "sqlUser", c.SQL.User, "sql user")
+	fs.Var(&c.SQL.Password, "sqlPassword", "sql user password, preferably set in a config file or "+EnvPrefix+"_SQL_PASSWORD")
+	fs.StringVar(&c.SQL.Port, "sqlPort", c.SQL.Port, "sql port, defaults to 3306 for mysql and 5432 for postgres")
+	fs.Var(stringList{&c.SQL.Replicas}, "sqlReplicas", "comma separated hosts of read replicas, optionally with ports")
+	fs.Uint64Var(&c.SQL.MaxReplicaLag, "maxReplicaLag", c.SQL.MaxReplicaLag, "# of blocks read replicas may lag behind to serve recent data")
+	fs.Uint64Var(&c.ChainID, "chainID", c.ChainID, "ID of the chain served by rpcEndpoint")
+	fs.StringVar(&c.RPCEndpoint, "rpcEndpoint", c.RPCEndpoint, "rpc endpoint")
+	fs.Var(&c.Chains, "chains", "comma separated <id>=<rpc endpoint> of more chains to index")
+	fs.IntVar(&c.Indexer.Workers, "worker", c.Indexer.Workers, "# of worker")
+	fs.BoolVar(&c.Indexer.Balances, "balances", c.Indexer.Balances, "track native balances of addresses touched in each block")
+	fs.BoolVar(&c.Indexer.Trace, "trace", c.Indexer.Trace, "trace internal transactions with debug_traceBlockByNumber")
+	fs.StringVar(&c.Indexer.ABIDir, "abiDir", c.Indexer.ABIDir, "directory of <address>.json ABI files to register")
+	fs.StringVar(&c.Indexer.Signatures, "signatures", c.Indexer.Signatures, "file of method signatures to decode inputs with")
+	fs.Uint64Var(&c.Indexer.Retention.Blocks, "retainBlocks", c.Indexer.Retention.Blocks, "keep the most recent # of blocks, all if 0")
+	fs.IntVar(&c.Indexer.Retention.Days, "retainDays", c.Indexer.Retention.Days, "keep blocks mined in the last # of days, all if 0")
+	fs.Uint64Var(&c.Indexer.Retention.PartitionSize, "partitionSize", c.Indexer.Retention.PartitionSize, "range partition transactions and logs by every # of blocks, mysql only")
+	fs.IntVar(&c.Indexer.Retention.PruneChunk, "pruneChunk", c.Indexer.Retention.PruneChunk, "# of blocks deleted in a DB transaction when pruning")
+	fs.StringVar(&c.Indexer.Listen, "listen", c.Indexer.Listen, "local network address of the indexer daemon serving health, status and metrics")
+	fs.StringVar(&c.Tracing.Exporter, "tracingExporter", c.Tracing.Exporter, "exporter of OpenTelemetry spans, none, stdout or otlp")
+	fs.StringVar(&c.Tracing.Endpoint, "tracingEndpoint", c.Tracing.Endpoint, "host and port of the OTLP/HTTP collector")
+	fs.BoolVar(&c.API.Auth.Required, "requireKey", c.API.Auth.Required, "reject api requests without a valid API key")
+	fs.Float64Var(&c.API.Auth.KeyRate, "keyRate", c.API.Auth.KeyRate, "api requests per second of each API key, unlimited if 0")
+	fs.Float64Var(&c.API.Auth.IPRate, "ipRate", c.API.Auth.IPRate, "api requests per second without a valid API key of each client IP, unlimited if 0")
+	fs.Var(stringList{&c.API.TrustedProxies}, "trustedProxies", "comma separated IPs or CIDRs of proxies giving client IPs in X-Forwarded-For")
+	fs.IntVar(&c.Cache.Size, "cacheSize", c.Cache.Size, "# of block and transaction responses cached in process, none if 0")
+	fs.StringVar(&c.Cache.Redis, "redis", c.Cache.Redis, "host and port of a Redis-compatible cache shared by processes")
+	fs.Uint64Var(&c.Cache.Finality, "finality", c.Cache.Finality, "# of blocks after which blocks are finalized and cached as immutable")
+	fs.StringVar(&c.Log.Level, "logLevel", c.Log.Level, "log level, debug, info, warn or error")
+	fs.StringVar(&c.Log.Format, "logFormat", c.Log.Format, "log format, text or json")
+}
+
+// loadFile overrides settings with the ones in a YAML or TOML file
+func (c *Config) loadFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, c)
+	case ".toml":
+		var md toml.MetaData
+		if md, err = toml.Decode(string(b), c); err == nil {
+			if undecoded := md.Undecoded(); len(undecoded) > 0 {
+				err = fmt.Errorf("unknown keys %v", undecoded)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported config file extension %q, yaml, yml or toml", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// loadEnv overrides settings with env vars named by their YAML keys, e.g. PORTTO_INDEXER_RETENTION_DAYS
+func loadEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		name := prefix + "_" + strings.ToUpper(key)
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := loadEnv(field, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		var err error
+		if v, ok := field.Addr().Interface().(flag.Value); ok {
+			// types parsing flags parse env vars alike
+			if err := v.Set(s); err != nil {
+				return fmt.Errorf("bad env var %s: %v", name, err)
+			}
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(s)
+		case reflect.Bool:
+			var b bool
+			b, err = strconv.ParseBool(s)
+			field.SetBool(b)
+		case reflect.Int:
+			var n int64
+			n, err = strconv.ParseInt(s, 10, 0)
+			field.SetInt(n)
+		case reflect.Uint64:
+			var n uint64
+			n, err = strconv.ParseUint(s, 10, 64)
+			field.SetUint(n)
+		case reflect.Float64:
+			var f float64
+			f, err = strconv.ParseFloat(s, 64)
+			field.SetFloat(f)
+		case reflect.Slice:
+			field.Set(reflect.ValueOf(splitList(s)))
+		default:
+			err = fmt.Errorf("unsupported type %s", field.Type())
+		}
+		if err != nil {
+			return fmt.Errorf("bad env var %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Load parses args with fs, on which flags of c are defined along with the ones already there,
+// and loads settings into c from, in increasing precedence,
+// defaults, the config file given by -config or PORTTO_CONFIG, env vars and flags set in args
+func Load(c *Config, fs *flag.FlagSet, args []string) error {
+	c.bindFlags(fs)
+	path := fs.String("config", os.Getenv(EnvPrefix+"_CONFIG"), "YAML or TOML config file")
+	// parse for the config file path first
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	*c = *Default()
+	if *path != "" {
+		if err := c.loadFile(*path); err != nil {
+			return err
+		}
+	}
+	if err := loadEnv(reflect.ValueOf(c).Elem(), EnvPrefix); err != nil {
+		return err
+	}
+	// parse again for flags to take precedence
+	return fs.Parse(args)
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFile writes content to a file named name in a temporary directory, returning its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// load loads a config from args with a new flag set
+func load(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	var c Config
+	err := Load(&c, flag.NewFlagSet("test", flag.ContinueOnError), args)
+	return &c, err
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "portto.yaml", `
+sql:
+  host: file-host
+  user: file-user
+  db: file-db
+indexer:
+  workers: 3
+  retention:
+    days: 7
+`)
+	t.Setenv("PORTTO_CONFIG", path)
+	t.Setenv("PORTTO_SQL_USER", "env-user")
+	t.Setenv("PORTTO_SQL_DB", "env-db")
+	t.Setenv("PORTTO_SQL_PASSWORD", "env-password")
+	t.Setenv("PORTTO_INDEXER_RETENTION_DAYS", "14")
+	t.Setenv("PORTTO_SQL_REPLICAS", "r1, r2")
+	t.Setenv("PORTTO_CHAINS", "56=https://bsc")
+
+	c, err := load(t, "--sqlDB=flag-db", "--worker=5")
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"default", c.API.Port, ":3000"},
+		{"file", c.SQL.Host, "file-host"},
+		{"env over file", c.SQL.User, "env-user"},
+		{"flag over env", c.SQL.DB, "flag-db"},
+		{"flag over file", c.Indexer.Workers, 5},
+		{"nested env", c.Indexer.Retention.Days, 14},
+		{"secret env", c.SQL.Password.Reveal(), "env-password"},
+		{"list env", c.SQL.Replicas, []string{"r1", "r2"}},
+		{"chains env", c.Chains, Chains{{ID: 56, RPCEndpoint: "https://bsc"}}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("loaded config is invalid: %v", err)
+	}
+}
+
+func TestLoadFlagOverConfigFlag(t *testing.T) {
+	path := writeFile(t, "portto.toml", `
+chain_id = 56
+
+[sql]
+driver = "sqlite"
+`)
+	// -config takes precedence over PORTTO_CONFIG
+	t.Setenv("PORTTO_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	c, err := load(t, "-config", path, "--chains=1=https://eth,10=https://op")
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if c.ChainID != 56 || c.SQL.Driver != "sqlite" || len(c.AllChains()) != 3 {
+		t.Errorf("config = %+v", c)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown yaml key": writeFile(t, "a.yaml", "sql:\n  hots: x\n"),
+		"unknown toml key": writeFile(t, "a.toml", "[sql]\nhots = \"x\"\n"),
+		"bad extension":    writeFile(t, "a.json", "{}"),
+		"missing file":     filepath.Join(t.TempDir(), "missing.yaml"),
+	}
+	for name, path := range tests {
+		if _, err := load(t, "-config", path); err == nil {
+			t.Errorf("loaded config of %s", name)
+		}
+	}
+
+	for env, v := range map[string]string{
+		"PORTTO_INDEXER_WORKERS":          "many",
+		"PORTTO_INDEXER_BALANCES":         "maybe",
+		"PORTTO_INDEXER_RETENTION_BLOCKS": "-1",
+		"PORTTO_CHAINS":                   "56",
+		"PORTTO_API_AUTH_REQUIRED":        "maybe",
+	} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, v)
+			if _, err := load(t); err == nil {
+				t.Errorf("loaded bad %s=%s", env, v)
+			}
+		})
+	}
+}
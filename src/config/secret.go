@@ -0,0 +1,45 @@
+package config
+
+const redacted = "******"
+
+// Secret is a string, which is redacted when it is printed, logged or marshalled
+type Secret string
+
+// Reveal returns the secret in plaintext
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString redacts the secret formatted with %#v
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// Set implements the flag.Value interface
+func (s *Secret) Set(v string) error {
+	*s = Secret(v)
+	return nil
+}
+
+// MarshalText implements the encoding.TextMarshaler interface used by JSON and TOML
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface
+func (s *Secret) UnmarshalText(b []byte) error {
+	*s = Secret(b)
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
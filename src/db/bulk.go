@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/r04922101/portto/eth"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultBatchSize is the # of rows in a multi-row insert of WriteBlocks given no positive batch size
+const DefaultBatchSize = 1000
+
+// secondaryIndexes lists fields of bulk inserted models, which are indexed but not primary keys
+var secondaryIndexes = []struct {
+	model interface{}
+	field string
+}{
+	{&eth.Block{}, "Hash"},
+	{&eth.Transaction{}, "BlockNum"},
+	{&eth.Transaction{}, "To"},
+	{&eth.Log{}, "Address"},
+}
+
+func (s *sqlStore) WriteBlocks(ctx context.Context, blocks []*eth.Block, batchSize int) error {
+	defer observeWrite(s.chainID, "write_blocks", time.Now())
+
+	if len(blocks) == 0 {
+		return nil
+	}
+	// gorm inserts no rows in batches of no size
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	var (
+		txs  []*eth.Transaction
+		logs []*eth.Log
+	)
+	for _, b := range blocks {
+		b.ChainID = s.chainID
+		for i := range b.Transactions {
+			t := &b.Transactions[i]
+			t.ChainID = s.chainID
+			t.BlockNum = b.Num
+			txs = append(txs, t)
+			for j := range t.Logs {
+				l := &t.Logs[j]
+				l.ChainID = s.chainID
+				l.TransactionHash = t.Hash
+				l.BlockNum = b.Num
+				logs = append(logs, l)
+			}
+		}
+	}
+
+	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// associations are inserted table by table instead of block by block
+		tx = tx.Omit(clause.Associations).Clauses(clause.OnConflict{UpdateAll: true}).Session(&gorm.Session{})
+		if err := tx.CreateInBatches(blocks, batchSize).Error; err != nil {
+			return fmt.Errorf("failed to insert blocks: %v", err)
+		}
+		if len(txs) > 0 {
+			if err := tx.CreateInBatches(txs, batchSize).Error; err != nil {
+				return fmt.Errorf("failed to insert transactions: %v", err)
+			}
+		}
+		if len(logs) > 0 {
+			if err := tx.CreateInBatches(logs, batchSize).Error; err != nil {
+				return fmt.Errorf("failed to insert logs: %v", err)
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to write blocks %d-%d to DB: %v", blocks[0].Num, blocks[len(blocks)-1].Num, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) DropSecondaryIndexes(ctx context.Context) error {
+	m := s.db.WithContext(ctx).Migrator()
+	for _, idx := range secondaryIndexes {
+		if !m.HasIndex(idx.model, idx.field) {
+			continue
+		}
+		if err := m.DropIndex(idx.model, idx.field); err != nil {
+			return fmt.Errorf("failed to drop index of %T.%s: %v", idx.model, idx.field, err)
+		}
+	}
+	s.log.Info("dropped secondary indexes, which must be created again after bulk inserts")
+	return nil
+}
+
+func (s *sqlStore) CreateSecondaryIndexes(ctx context.Context) error {
+	m := s.db.WithContext(ctx).Migrator()
+	for _, idx := range secondaryIndexes {
+		if m.HasIndex(idx.model, idx.field) {
+			continue
+		}
+		s.log.Info("creating index", "model", fmt.Sprintf("%T", idx.model), "field", idx.field)
+		if err := m.CreateIndex(idx.model, idx.field); err != nil {
+			return fmt.Errorf("failed to create index of %T.%s: %v", idx.model, idx.field, err)
+		}
+	}
+	return nil
+}
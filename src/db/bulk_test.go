@@ -0,0 +1,39 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSecondaryIndexes(t *testing.T) {
+	gdb := newSQLiteDB(t)
+	migrate(t, gdb, LatestVersion())
+	s := NewSQLStore(gdb, testChainID)
+	ctx := context.Background()
+	m := gdb.Migrator()
+
+	if err := s.DropSecondaryIndexes(ctx); err != nil {
+		t.Fatalf("failed to drop indexes: %v", err)
+	}
+	for _, idx := range secondaryIndexes {
+		if m.HasIndex(idx.model, idx.field) {
+			t.Errorf("index of %T.%s is not dropped", idx.model, idx.field)
+		}
+	}
+	// dropping missing indexes is a no-op
+	if err := s.DropSecondaryIndexes(ctx); err != nil {
+		t.Errorf("failed to drop missing indexes: %v", err)
+	}
+
+	if err := s.CreateSecondaryIndexes(ctx); err != nil {
+		t.Fatalf("failed to create indexes: %v", err)
+	}
+	for _, idx := range secondaryIndexes {
+		if !m.HasIndex(idx.model, idx.field) {
+			t.Errorf("index of %T.%s is not created", idx.model, idx.field)
+		}
+	}
+	if err := CheckSchema(gdb); err != nil {
+		t.Errorf("schema check fails after creating indexes: %v", err)
+	}
+}
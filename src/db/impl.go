@@ -2,10 +2,14 @@ package db
 
 import (
 	"fmt"
-	"log"
+	"strings"
 	"time"
 
+	"github.com/r04922101/portto/logging"
+	"github.com/r04922101/portto/metrics"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
@@ -13,27 +17,64 @@ const (
 	attempts = 5
 )
 
+// supported SQL drivers
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	// DriverSQLite stores data in the local file named by DB name, which requires cgo
+	DriverSQLite = "sqlite"
+)
+
 var (
 	sleep = 3 * time.Second
 )
 
-// InitDB instantiates sql client
-func InitDB(dbHost, dbName, dbPort, dbUser, dbPassword string) (*gorm.DB, error) {
-	// connect to db
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=UTC", dbUser, dbPassword, dbHost, dbPort, dbName)
+// dialector creates a gorm dialector of driver, using its default port if dbPort is empty
+func dialector(driver, dbHost, dbName, dbPort, dbUser, dbPassword string) (gorm.Dialector, string, error) {
+	switch driver {
+	case DriverMySQL:
+		if dbPort == "" {
+			dbPort = "3306"
+		}
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=UTC", dbUser, dbPassword, dbHost, dbPort, dbName)
+		return mysql.Open(dsn), dsn, nil
+	case DriverPostgres:
+		if dbPort == "" {
+			dbPort = "5432"
+		}
+		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable TimeZone=UTC", dbHost, dbPort, dbUser, dbPassword, dbName)
+		return postgres.Open(dsn), dsn, nil
+	case DriverSQLite:
+		// wait for the lock rather than failing concurrent writes
+		sep := "?"
+		if strings.Contains(dbName, "?") {
+			sep = "&"
+		}
+		dsn := dbName + sep + "_busy_timeout=5000"
+		return sqlite.Open(dsn), dsn, nil
+	}
+	return nil, "", fmt.Errorf("unsupported sql driver %q", driver)
+}
+
+// InitDB instantiates sql client of driver
+func InitDB(driver, dbHost, dbName, dbPort, dbUser, dbPassword string) (*gorm.DB, error) {
+	d, _, err := dialector(driver, dbHost, dbName, dbPort, dbUser, dbPassword)
+	if err != nil {
+		return nil, err
+	}
+	// the DSN in errors has the password redacted
+	_, dsn, _ := dialector(driver, dbHost, dbName, dbPort, dbUser, "******")
 
 	// connect to db with retry mechanism
-	var (
-		db  *gorm.DB
-		err error
-	)
+	var db *gorm.DB
 	for i := 0; i < attempts; i++ {
 		if i > 0 {
-			log.Println("retrying after error:", err)
+			logging.Root().Warn("retrying to connect to DB", "attempt", i+1, "err", err)
+			metrics.DBConnectRetries.Inc()
 			time.Sleep(sleep)
 			sleep *= 2
 		}
-		db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+		db, err = gorm.Open(d, &gorm.Config{Logger: gormLogger{}})
 		if db != nil && err == nil {
 			break
 		}
@@ -43,10 +84,19 @@ func InitDB(dbHost, dbName, dbPort, dbUser, dbPassword string) (*gorm.DB, error)
 		return nil, fmt.Errorf("failed to open DB with DSN %s: %v", dsn, err)
 	}
 
+	if err := registerTracing(db); err != nil {
+		return nil, fmt.Errorf("failed to register tracing callbacks: %v", err)
+	}
+
 	pool, err := db.DB()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get sql.DB: %v", err)
 	}
+	if driver == DriverSQLite {
+		// SQLite allows a single writer, and an in-memory DB lives as long as its connection
+		pool.SetMaxOpenConns(1)
+		return db, nil
+	}
 	// SetMaxIdleConns sets the maximum number of connections in the idle connection pool.
 	pool.SetMaxIdleConns(25)
 	// SetMaxOpenConns sets the maximum number of open connections to the database.
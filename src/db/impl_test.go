@@ -0,0 +1,54 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDialector(t *testing.T) {
+	tests := []struct {
+		driver, host, name, port string
+		dsn                      string
+	}{
+		{DriverMySQL, "mysql", "portto", "", "user:pass@tcp(mysql:3306)/portto?charset=utf8mb4&parseTime=True&loc=UTC"},
+		{DriverMySQL, "mysql", "portto", "3307", "user:pass@tcp(mysql:3307)/portto?charset=utf8mb4&parseTime=True&loc=UTC"},
+		{DriverPostgres, "pg", "portto", "", "host=pg port=5432 user=user password=pass dbname=portto sslmode=disable TimeZone=UTC"},
+		{DriverSQLite, "", "portto.db", "", "portto.db?_busy_timeout=5000"},
+		{DriverSQLite, "", "file:portto?mode=memory", "", "file:portto?mode=memory&_busy_timeout=5000"},
+	}
+	for _, tt := range tests {
+		d, dsn, err := dialector(tt.driver, tt.host, tt.name, tt.port, "user", "pass")
+		if err != nil {
+			t.Errorf("failed to create %s dialector: %v", tt.driver, err)
+			continue
+		}
+		if d.Name() != tt.driver {
+			t.Errorf("dialector of %s is %s", tt.driver, d.Name())
+		}
+		if dsn != tt.dsn {
+			t.Errorf("DSN of %s = %q, want %q", tt.driver, dsn, tt.dsn)
+		}
+	}
+
+	if _, _, err := dialector("oracle", "", "", "", "", ""); err == nil {
+		t.Error("created dialector of unsupported driver")
+	}
+}
+
+func TestInitSQLite(t *testing.T) {
+	gdb, err := InitDB(DriverSQLite, "", filepath.Join(t.TempDir(), "portto.db"), "", "", "")
+	if err != nil {
+		t.Fatalf("failed to open sqlite DB: %v", err)
+	}
+	pool, err := gdb.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if err := pool.Ping(); err != nil {
+		t.Errorf("failed to ping sqlite DB: %v", err)
+	}
+	if n := pool.Stats().MaxOpenConnections; n != 1 {
+		t.Errorf("max open connections = %d, want 1", n)
+	}
+}
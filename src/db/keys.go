@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// keyPrefix prefixes API keys, which makes them recognizable, e.g. by secret scanners
+const keyPrefix = "portto_"
+
+// APIKey defines a key authenticating requests to the api server, whose secret is stored as its SHA-256 hash
+type APIKey struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Name string `json:"name"`
+	// Prefix is the beginning of the secret, which identifies the key to its owner
+	Prefix string `json:"prefix"`
+	Hash   string `gorm:"size:64;uniqueIndex" json:"-"`
+	// Rate and Burst limit requests per second, overriding the default limits if positive
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	// DailyQuota limits requests per UTC day, unlimited if 0
+	DailyQuota int64      `json:"daily_quota"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// KeyUsage defines the # of requests made with a key in a UTC day
+type KeyUsage struct {
+	KeyID uint `gorm:"primaryKey;autoIncrement:false"`
+	// Day is formatted as 2006-01-02
+	Day        string `gorm:"primaryKey;size:10"`
+	Requests   int64
+	LastUsedAt time.Time
+}
+
+func (KeyUsage) TableName() string {
+	return "api_key_usage"
+}
+
+// UsageDay returns the UTC day of t, by which usage is accounted
+func UsageDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// HashKey returns the hash of a secret, by which the key is stored
+func HashKey(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// KeyStore defines an interface managing API keys, which are shared by chains
+type KeyStore interface {
+	// CreateKey generates the secret of key, returning it, which is not stored and cannot be shown again
+	CreateKey(ctx context.Context, key *APIKey) (string, error)
+	// GetKey returns the key of secret, revoked or not, ErrNotFound if there is none
+	GetKey(ctx context.Context, secret string) (*APIKey, error)
+	ListKeys(ctx context.Context) ([]*APIKey, error)
+	// RevokeKey revokes the key with id, ErrNotFound if there is none
+	RevokeKey(ctx context.Context, id uint) error
+	// AddUsage adds requests of usages to the ones of their keys and days
+	AddUsage(ctx context.Context, usages []KeyUsage) error
+	// GetUsage returns the usage of the key with id in day, which is empty if there is none
+	GetUsage(ctx context.Context, id uint, day string) (*KeyUsage, error)
+}
+
+type sqlKeyStore struct {
+	db *gorm.DB
+}
+
+// NewSQLKeyStore creates a key store backed by a SQL DB
+func NewSQLKeyStore(gdb *gorm.DB) KeyStore {
+	return &sqlKeyStore{db: gdb}
+}
+
+func (s *sqlKeyStore) CreateKey(ctx context.Context, key *APIKey) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %v", err)
+	}
+	secret := keyPrefix + hex.EncodeToString(b)
+	key.Prefix = secret[:len(keyPrefix)+8]
+	key.Hash = HashKey(secret)
+	if err := s.db.WithContext(ctx).Create(key).Error; err != nil {
+		return "", fmt.Errorf("failed to insert API key to DB: %v", err)
+	}
+	return secret, nil
+}
+
+func (s *sqlKeyStore) GetKey(ctx context.Context, secret string) (*APIKey, error) {
+	var key APIKey
+	if err := first(s.db.WithContext(ctx), &key, "hash = ?", HashKey(secret)); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to find API key in DB: %v", err)
+	}
+	return &key, nil
+}
+
+func (s *sqlKeyStore) ListKeys(ctx context.Context) ([]*APIKey, error) {
+	var keys []*APIKey
+	if err := s.db.WithContext(ctx).Order("id").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to find API keys in DB: %v", err)
+	}
+	return keys, nil
+}
+
+func (s *sqlKeyStore) RevokeKey(ctx context.Context, id uint) error {
+	ret := s.db.WithContext(ctx).Model(&APIKey{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now())
+	if ret.Error != nil {
+		return fmt.Errorf("failed to revoke API key %d in DB: %v", id, ret.Error)
+	}
+	if ret.RowsAffected == 0 {
+		// revoking a revoked key succeeds
+		if err := first(s.db.WithContext(ctx), &APIKey{}, "id = ?", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlKeyStore) AddUsage(ctx context.Context, usages []KeyUsage) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i := range usages {
+			u := usages[i]
+			if err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "key_id"}, {Name: "day"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"requests":     gorm.Expr("api_key_usage.requests + ?", u.Requests),
+					"last_used_at": u.LastUsedAt,
+				}),
+			}).Create(&u).Error; err != nil {
+				return fmt.Errorf("failed to add usage of API key %d to DB: %v", u.KeyID, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlKeyStore) GetUsage(ctx context.Context, id uint, day string) (*KeyUsage, error) {
+	usage := KeyUsage{KeyID: id, Day: day}
+	if err := first(s.db.WithContext(ctx), &usage, "key_id = ? AND day = ?", id, day); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("failed to find usage of API key %d in DB: %v", id, err)
+	}
+	return &usage, nil
+}
+
+// RunKeysCommand runs the `keys` subcommand with its args, writing results to w:
+//
+//	keys create [-rate r] [-burst b] [-quota q] <name>  creates a key, printing its secret once
+//	keys list                                           lists keys with their usage today
+//	keys revoke <id>                                    revokes a key
+func RunKeysCommand(store KeyStore, w io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: keys create|list|revoke")
+	}
+	ctx := context.Background()
+	switch args[0] {
+	case "create":
+		var key APIKey
+		fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		fs.Float64Var(&key.Rate, "rate", 0, "requests per second, the default of the api server if 0")
+		fs.IntVar(&key.Burst, "burst", 0, "requests in a burst, the default of the api server if 0")
+		fs.Int64Var(&key.DailyQuota, "quota", 0, "requests per UTC day, unlimited if 0")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New("usage: keys create [-rate r] [-burst b] [-quota q] <name>")
+		}
+		if key.Rate < 0 || key.Burst < 0 || key.DailyQuota < 0 {
+			return errors.New("rate, burst and quota must not be negative")
+		}
+		key.Name = fs.Arg(0)
+		secret, err := store.CreateKey(ctx, &key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "created API key %d %q, which is not shown again:\n%s\n", key.ID, key.Name, secret)
+		return nil
+	case "list":
+		keys, err := store.ListKeys(ctx)
+		if err != nil {
+			return err
+		}
+		day := UsageDay(time.Now())
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tPREFIX\tRATE\tBURST\tQUOTA\tTODAY\tLAST USED\tCREATED\tREVOKED")
+		for _, k := range keys {
+			usage, err := store.GetUsage(ctx, k.ID, day)
+			if err != nil {
+				return err
+			}
+			lastUsed, revoked := "-", "-"
+			if !usage.LastUsedAt.IsZero() {
+				lastUsed = usage.LastUsedAt.UTC().Format(time.RFC3339)
+			}
+			if k.RevokedAt != nil {
+				revoked = k.RevokedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%v\t%d\t%d\t%d\t%s\t%s\t%s\n", k.ID, k.Name, k.Prefix, k.Rate, k.Burst, k.DailyQuota,
+				usage.Requests, lastUsed, k.CreatedAt.UTC().Format(time.RFC3339), revoked)
+		}
+		return tw.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New("usage: keys revoke <id>")
+		}
+		id, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("bad key ID %q: %v", args[1], err)
+		}
+		if err := store.RevokeKey(ctx, uint(id)); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return fmt.Errorf("API key %d is not found", id)
+			}
+			return err
+		}
+		fmt.Fprintf(w, "revoked API key %d\n", id)
+		return nil
+	}
+	return fmt.Errorf("unknown keys command %q", args[0])
+}
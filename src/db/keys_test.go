@@ -0,0 +1,151 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newKeyStore creates a key store backed by a migrated sqlite DB
+func newKeyStore(t *testing.T) KeyStore {
+	t.Helper()
+	gdb := newSQLiteDB(t)
+	migrate(t, gdb, LatestVersion())
+	return NewSQLKeyStore(gdb)
+}
+
+func TestKeyStore(t *testing.T) {
+	ctx := context.Background()
+	s := newKeyStore(t)
+
+	key := &APIKey{Name: "dapp", Rate: 5, DailyQuota: 100}
+	secret, err := s.CreateKey(ctx, key)
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	if !strings.HasPrefix(secret, keyPrefix) || !strings.HasPrefix(secret, key.Prefix) || key.Hash != HashKey(secret) || key.ID == 0 {
+		t.Errorf("key = %+v of secret %s", key, secret)
+	}
+	got, err := s.GetKey(ctx, secret)
+	if err != nil || got.ID != key.ID || got.Name != "dapp" || got.Rate != 5 || got.DailyQuota != 100 || got.RevokedAt != nil {
+		t.Errorf("GetKey = %+v, %v", got, err)
+	}
+	if _, err := s.GetKey(ctx, keyPrefix+"unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetKey of an unknown secret err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.CreateKey(ctx, &APIKey{Name: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := s.ListKeys(ctx); err != nil || len(keys) != 2 || keys[0].ID != key.ID {
+		t.Errorf("ListKeys = %v, %v", keys, err)
+	}
+
+	// revoking a revoked key succeeds, unlike revoking a missing one
+	for i := 0; i < 2; i++ {
+		if err := s.RevokeKey(ctx, key.ID); err != nil {
+			t.Errorf("failed to revoke key: %v", err)
+		}
+	}
+	if got, err := s.GetKey(ctx, secret); err != nil || got.RevokedAt == nil {
+		t.Errorf("revoked key = %+v, %v", got, err)
+	}
+	if err := s.RevokeKey(ctx, 100); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RevokeKey of a missing key err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestKeyUsage(t *testing.T) {
+	ctx := context.Background()
+	s := newKeyStore(t)
+	now := time.Now()
+
+	if u, err := s.GetUsage(ctx, 1, "2024-01-01"); err != nil || u.Requests != 0 || !u.LastUsedAt.IsZero() {
+		t.Errorf("usage without requests = %+v, %v", u, err)
+	}
+	// usages of the same key and day add up
+	for _, usages := range [][]KeyUsage{
+		{{KeyID: 1, Day: "2024-01-01", Requests: 3, LastUsedAt: now.Add(-time.Hour)}, {KeyID: 2, Day: "2024-01-01", Requests: 1, LastUsedAt: now}},
+		{{KeyID: 1, Day: "2024-01-01", Requests: 2, LastUsedAt: now}, {KeyID: 1, Day: "2024-01-02", Requests: 7, LastUsedAt: now}},
+	} {
+		if err := s.AddUsage(ctx, usages); err != nil {
+			t.Fatalf("failed to add usage: %v", err)
+		}
+	}
+	tests := []struct {
+		id       uint
+		day      string
+		requests int64
+	}{
+		{1, "2024-01-01", 5},
+		{1, "2024-01-02", 7},
+		{2, "2024-01-01", 1},
+		{2, "2024-01-02", 0},
+	}
+	for _, tt := range tests {
+		u, err := s.GetUsage(ctx, tt.id, tt.day)
+		if err != nil || u.Requests != tt.requests {
+			t.Errorf("usage of key %d in %s = %+v, %v, want %d requests", tt.id, tt.day, u, err, tt.requests)
+		}
+	}
+	if u, _ := s.GetUsage(ctx, 1, "2024-01-01"); !u.LastUsedAt.Equal(now) {
+		t.Errorf("last used at %v, want %v", u.LastUsedAt, now)
+	}
+}
+
+func TestRunKeysCommand(t *testing.T) {
+	s := newKeyStore(t)
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := RunKeysCommand(s, &out, args)
+		return out.String(), err
+	}
+
+	out, err := run("create", "-rate", "2", "-quota", "1000", "dapp")
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `API key 1 "dapp"`) || !strings.HasPrefix(lines[1], keyPrefix) {
+		t.Fatalf("output of create = %q", out)
+	}
+	if _, err := s.GetKey(context.Background(), lines[1]); err != nil {
+		t.Errorf("failed to get created key: %v", err)
+	}
+	if err := s.AddUsage(context.Background(), []KeyUsage{{KeyID: 1, Day: UsageDay(time.Now()), Requests: 42, LastUsedAt: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err = run("revoke", "1"); err != nil || out != "revoked API key 1\n" {
+		t.Errorf("revoke = %q, %v", out, err)
+	}
+	out, err = run("list")
+	if err != nil {
+		t.Fatalf("failed to list keys: %v", err)
+	}
+	lines = strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") {
+		t.Fatalf("output of list = %q", out)
+	}
+	fields := strings.Fields(lines[1])
+	if len(fields) != 10 || fields[0] != "1" || fields[1] != "dapp" || fields[3] != "2" || fields[5] != "1000" || fields[6] != "42" || fields[9] == "-" {
+		t.Errorf("listed key = %q", lines[1])
+	}
+
+	for _, args := range [][]string{
+		nil,
+		{"rotate"},
+		{"create"},
+		{"create", "-rate", "-1", "dapp"},
+		{"create", "-burst", "x", "dapp"},
+		{"revoke"},
+		{"revoke", "one"},
+		{"revoke", "100"},
+	} {
+		if _, err := run(args...); err == nil {
+			t.Errorf("keys %v succeeded", args)
+		}
+	}
+}
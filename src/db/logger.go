@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/r04922101/portto/logging"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// slowThreshold is the latency, above which statements are logged as slow
+const slowThreshold = 200 * time.Millisecond
+
+// gormLogger writes logs of gorm with the logger of the context given by WithContext,
+// logging every statement at debug level, slow ones at warn level, and failed ones at error level
+type gormLogger struct{}
+
+func (l gormLogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	// levels are filtered by the logging setup
+	return l
+}
+
+func (gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	logging.FromContext(ctx).Info(fmt.Sprintf(msg, args...))
+}
+
+func (gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	logging.FromContext(ctx).Warn(fmt.Sprintf(msg, args...))
+}
+
+func (gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	logging.FromContext(ctx).Error(fmt.Sprintf(msg, args...))
+}
+
+func (gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	elapsed := time.Since(begin)
+	sql, rows := fc()
+	logger := logging.FromContext(ctx)
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		logger.Error("SQL statement failed", "sql", sql, "rows", rows, "elapsed", elapsed, "err", err)
+	case elapsed > slowThreshold:
+		logger.Warn("slow SQL statement", "sql", sql, "rows", rows, "elapsed", elapsed)
+	default:
+		logger.Debug("SQL statement", "sql", sql, "rows", rows, "elapsed", elapsed)
+	}
+}
@@ -0,0 +1,515 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/r04922101/portto/eth"
+)
+
+type memoryStore struct {
+	chainID uint64
+
+	mu        sync.RWMutex
+	blocks    map[uint64]*eth.Block
+	txs       map[string]*eth.Transaction
+	tokens    map[string]*eth.Token
+	abis      map[string]*eth.ContractABI
+	balances  map[string][]eth.Balance // by address, sorted by block number
+	internals map[string][]eth.InternalTransaction
+	contracts map[string]*eth.Contract
+}
+
+// NewMemoryStore creates a store keeping data of the chain with chainID in memory, e.g. for tests
+func NewMemoryStore(chainID uint64) Store {
+	return &memoryStore{
+		chainID:   chainID,
+		blocks:    map[uint64]*eth.Block{},
+		txs:       map[string]*eth.Transaction{},
+		tokens:    map[string]*eth.Token{},
+		abis:      map[string]*eth.ContractABI{},
+		balances:  map[string][]eth.Balance{},
+		internals: map[string][]eth.InternalTransaction{},
+		contracts: map[string]*eth.Contract{},
+	}
+}
+
+// copyBlock copies a block with its transactions without logs, like a block read from DB
+func copyBlock(b *eth.Block) *eth.Block {
+	ret := *b
+	ret.Transactions = make([]eth.Transaction, len(b.Transactions))
+	for i, t := range b.Transactions {
+		t.Logs = nil
+		ret.Transactions[i] = t
+	}
+	return &ret
+}
+
+func (m *memoryStore) ChainID() uint64 {
+	return m.chainID
+}
+
+func (m *memoryStore) CheckSchema(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryStore) GetBlockByNumber(ctx context.Context, n uint64) (*eth.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	b, ok := m.blocks[n]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyBlock(b), nil
+}
+
+func (m *memoryStore) GetBlockByHash(ctx context.Context, h string) (*eth.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, b := range m.blocks {
+		if b.Hash == h {
+			return copyBlock(b), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryStore) ListBlocks(ctx context.Context, limit int) ([]*eth.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nums := make([]uint64, 0, len(m.blocks))
+	for n := range m.blocks {
+		nums = append(nums, n)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] > nums[j] })
+	if len(nums) > limit {
+		nums = nums[:limit]
+	}
+	ret := make([]*eth.Block, len(nums))
+	for i, n := range nums {
+		ret[i] = copyBlock(m.blocks[n])
+	}
+	return ret, nil
+}
+
+func (m *memoryStore) ListBlockHashes(ctx context.Context, from, to uint64, withTxs bool) ([]BlockHashes, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var ret []BlockHashes
+	for n, b := range m.blocks {
+		if n < from || n > to {
+			continue
+		}
+		hashes := BlockHashes{Num: n, Hash: b.Hash}
+		if withTxs {
+			for _, t := range b.Transactions {
+				hashes.Transactions = append(hashes.Transactions, t.Hash)
+			}
+		}
+		ret = append(ret, hashes)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Num < ret[j].Num })
+	return ret, nil
+}
+
+func (m *memoryStore) GetTransaction(ctx context.Context, h string) (*eth.Transaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.txs[h]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	ret := *t
+	ret.Logs = append([]eth.Log(nil), t.Logs...)
+	return &ret, nil
+}
+
+func (m *memoryStore) ListTransactions(ctx context.Context, query TransactionQuery) ([]*eth.Transaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var ret []*eth.Transaction
+	for _, t := range m.txs {
+		if query.Address != "" && t.From != query.Address && t.To != query.Address {
+			continue
+		}
+		if query.MinValue != nil && t.Value.Int().Cmp(query.MinValue) < 0 {
+			continue
+		}
+		if query.MaxValue != nil && t.Value.Int().Cmp(query.MaxValue) > 0 {
+			continue
+		}
+		tx := *t
+		tx.Logs = nil
+		ret = append(ret, &tx)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		if query.Ascending {
+			a, b = b, a
+		}
+		if query.SortByValue {
+			if c := a.Value.Int().Cmp(b.Value.Int()); c != 0 {
+				return c > 0
+			}
+		}
+		if a.BlockNum != b.BlockNum {
+			return a.BlockNum > b.BlockNum
+		}
+		return a.Hash > b.Hash
+	})
+	if len(ret) > query.Limit {
+		ret = ret[:query.Limit]
+	}
+	return ret, nil
+}
+
+// deleteRange deletes blocks in range and data derived from them, holding the lock
+func (m *memoryStore) deleteRange(from, to uint64) {
+	for n, b := range m.blocks {
+		if n < from || n > to {
+			continue
+		}
+		for _, t := range b.Transactions {
+			delete(m.txs, t.Hash)
+			delete(m.internals, t.Hash)
+		}
+		delete(m.blocks, n)
+	}
+	for a, balances := range m.balances {
+		kept := balances[:0]
+		for _, b := range balances {
+			if b.BlockNum < from || b.BlockNum > to {
+				kept = append(kept, b)
+			}
+		}
+		m.balances[a] = kept
+	}
+	for a, c := range m.contracts {
+		if c.BlockNum >= from && c.BlockNum <= to {
+			delete(m.contracts, a)
+		}
+	}
+}
+
+func (m *memoryStore) WriteBlock(ctx context.Context, block *eth.Block) error {
+	// fill foreign keys like gorm does when creating associations
+	b := *block
+	b.ChainID = m.chainID
+	b.Transactions = make([]eth.Transaction, len(block.Transactions))
+	for i, t := range block.Transactions {
+		t.ChainID = m.chainID
+		t.BlockNum = b.Num
+		t.Logs = append([]eth.Log(nil), t.Logs...)
+		for j := range t.Logs {
+			t.Logs[j].ChainID = m.chainID
+			t.Logs[j].TransactionHash = t.Hash
+			t.Logs[j].BlockNum = b.Num
+		}
+		b.Transactions[i] = t
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.blocks[b.Num]; ok {
+		observeReorg(m.chainID, b.Num, old.Hash, b.Hash)
+	}
+	m.deleteRange(b.Num, b.Num)
+	m.blocks[b.Num] = &b
+	for i := range b.Transactions {
+		m.txs[b.Transactions[i].Hash] = &b.Transactions[i]
+	}
+	return nil
+}
+
+func (m *memoryStore) WriteBlocks(ctx context.Context, blocks []*eth.Block, batchSize int) error {
+	for _, b := range blocks {
+		if err := m.WriteBlock(ctx, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) DropSecondaryIndexes(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryStore) CreateSecondaryIndexes(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryStore) DeleteRange(ctx context.Context, from, to uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteRange(from, to)
+	return nil
+}
+
+func (m *memoryStore) LatestNum(ctx context.Context) (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var latest uint64
+	for n := range m.blocks {
+		if n > latest {
+			latest = n
+		}
+	}
+	return latest, nil
+}
+
+func (m *memoryStore) EarliestNum(ctx context.Context) (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.blocks) == 0 {
+		return 0, nil
+	}
+	earliest := ^uint64(0)
+	for n := range m.blocks {
+		if n < earliest {
+			earliest = n
+		}
+	}
+	return earliest, nil
+}
+
+func (m *memoryStore) FirstNumSince(ctx context.Context, t uint64) (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var (
+		first uint64
+		found bool
+	)
+	for n, b := range m.blocks {
+		if b.Time >= t && (!found || n < first) {
+			first, found = n, true
+		}
+	}
+	if !found {
+		return 0, ErrNotFound
+	}
+	return first, nil
+}
+
+func (m *memoryStore) Prune(ctx context.Context, before uint64, chunkSize int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for n, b := range m.blocks {
+		if n >= before {
+			continue
+		}
+		for _, t := range b.Transactions {
+			delete(m.txs, t.Hash)
+			delete(m.internals, t.Hash)
+		}
+		delete(m.blocks, n)
+	}
+	return nil
+}
+
+func (m *memoryStore) Partition(ctx context.Context, size uint64) error {
+	return nil
+}
+
+func (m *memoryStore) ContiguousHead(ctx context.Context) (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.blocks) == 0 {
+		return 0, nil
+	}
+	earliest := ^uint64(0)
+	for n := range m.blocks {
+		if n < earliest {
+			earliest = n
+		}
+	}
+	head := earliest
+	for {
+		if _, ok := m.blocks[head+1]; !ok {
+			return head, nil
+		}
+		head++
+	}
+}
+
+func (m *memoryStore) GetToken(ctx context.Context, address string) (*eth.Token, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.tokens[address]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	ret := *t
+	return &ret, nil
+}
+
+func (m *memoryStore) SaveToken(ctx context.Context, token *eth.Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tokens[token.Address]; !ok {
+		t := *token
+		t.ChainID = m.chainID
+		m.tokens[token.Address] = &t
+	}
+	return nil
+}
+
+func (m *memoryStore) ListABIs(ctx context.Context) ([]*eth.ContractABI, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ret := make([]*eth.ContractABI, 0, len(m.abis))
+	for _, a := range m.abis {
+		c := *a
+		ret = append(ret, &c)
+	}
+	return ret, nil
+}
+
+func (m *memoryStore) SaveABI(ctx context.Context, abi *eth.ContractABI) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := *abi
+	a.ChainID = m.chainID
+	m.abis[abi.Address] = &a
+	return nil
+}
+
+func (m *memoryStore) GetBalance(ctx context.Context, address string, blockNum uint64) (*eth.Balance, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	balances := m.balances[address]
+	for i := len(balances) - 1; i >= 0; i-- {
+		if balances[i].BlockNum <= blockNum {
+			b := balances[i]
+			return &b, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memoryStore) SaveBalances(ctx context.Context, balances []eth.Balance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range balances {
+		b.ChainID = m.chainID
+		history := m.balances[b.Address]
+		i := sort.Search(len(history), func(i int) bool { return history[i].BlockNum >= b.BlockNum })
+		if i < len(history) && history[i].BlockNum == b.BlockNum {
+			history[i] = b
+			continue
+		}
+		history = append(history, eth.Balance{})
+		copy(history[i+1:], history[i:])
+		history[i] = b
+		m.balances[b.Address] = history
+	}
+	return nil
+}
+
+func (m *memoryStore) ListInternalTransactionsByHash(ctx context.Context, h string) ([]*eth.InternalTransaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	internals := m.internals[h]
+	ret := make([]*eth.InternalTransaction, len(internals))
+	for i := range internals {
+		t := internals[i]
+		ret[i] = &t
+	}
+	return ret, nil
+}
+
+func (m *memoryStore) ListInternalTransactionsByAddress(ctx context.Context, address string, limit int) ([]*eth.InternalTransaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var ret []*eth.InternalTransaction
+	for _, internals := range m.internals {
+		for i := range internals {
+			if t := internals[i]; t.From == address || t.To == address {
+				ret = append(ret, &t)
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].BlockNum > ret[j].BlockNum })
+	if len(ret) > limit {
+		ret = ret[:limit]
+	}
+	return ret, nil
+}
+
+func (m *memoryStore) ListInternalCreations(ctx context.Context, blockNum uint64) ([]*eth.InternalTransaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var ret []*eth.InternalTransaction
+	for _, internals := range m.internals {
+		for i := range internals {
+			t := internals[i]
+			if t.BlockNum == blockNum && (t.Type == "CREATE" || t.Type == "CREATE2") && t.Error == "" {
+				ret = append(ret, &t)
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (m *memoryStore) SaveInternalTransactions(ctx context.Context, internals []eth.InternalTransaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byHash := map[string][]eth.InternalTransaction{}
+	for _, t := range internals {
+		t.ChainID = m.chainID
+		byHash[t.TransactionHash] = append(byHash[t.TransactionHash], t)
+	}
+	for h, ts := range byHash {
+		m.internals[h] = ts
+	}
+	return nil
+}
+
+func (m *memoryStore) GetContract(ctx context.Context, address string) (*eth.Contract, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.contracts[address]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	ret := *c
+	return &ret, nil
+}
+
+func (m *memoryStore) GetContractActivity(ctx context.Context, address string) (*ContractActivity, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var activity ContractActivity
+	for _, t := range m.txs {
+		if t.To == address {
+			activity.Transactions++
+			if t.BlockNum > activity.LastBlockNum {
+				activity.LastBlockNum = t.BlockNum
+			}
+		}
+		for _, l := range t.Logs {
+			if l.Address == address {
+				activity.Logs++
+			}
+		}
+	}
+	for _, internals := range m.internals {
+		for _, t := range internals {
+			if t.To == address {
+				activity.InternalTransactions++
+			}
+		}
+	}
+	return &activity, nil
+}
+
+func (m *memoryStore) SaveContracts(ctx context.Context, contracts []*eth.Contract) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range contracts {
+		contract := *c
+		contract.ChainID = m.chainID
+		m.contracts[c.Address] = &contract
+	}
+	return nil
+}
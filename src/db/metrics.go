@@ -0,0 +1,22 @@
+package db
+
+import (
+	"time"
+
+	"github.com/r04922101/portto/logging"
+	"github.com/r04922101/portto/metrics"
+)
+
+// observeWrite records the latency of a write operation to DB of a chain started at start
+func observeWrite(chainID uint64, operation string, start time.Time) {
+	metrics.DBWriteDuration.WithLabelValues(metrics.Chain(chainID), operation).Observe(metrics.Since(start))
+}
+
+// observeReorg records an indexed block of hash old replaced by another block of the same number
+func observeReorg(chainID, num uint64, old, new string) {
+	if old == "" || old == new {
+		return
+	}
+	logging.Root().Warn("block is reorganized", "chain", chainID, "block", num, "old", old, "new", new)
+	metrics.Reorgs.WithLabelValues(metrics.Chain(chainID)).Inc()
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/r04922101/portto/metrics"
+)
+
+func TestObserveReorgs(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		reorgs := metrics.Reorgs.WithLabelValues(metrics.Chain(testChainID))
+		before := testutil.ToFloat64(reorgs)
+
+		// rewriting the same block or a new one is not a reorg
+		writeBlocks(t, s, 1, 2)
+		writeBlocks(t, s, 2, 3)
+		if got := testutil.ToFloat64(reorgs) - before; got != 0 {
+			t.Errorf("reorgs = %v, want 0", got)
+		}
+		if err := s.WriteBlock(ctx, testBlock(2, "fork")); err != nil {
+			t.Fatal(err)
+		}
+		if got := testutil.ToFloat64(reorgs) - before; got != 1 {
+			t.Errorf("reorgs = %v, want 1", got)
+		}
+	})
+}
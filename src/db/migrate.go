@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/r04922101/portto/eth"
+	"github.com/r04922101/portto/logging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	lockTimeout = 10 * time.Minute
+	lockPoll    = time.Second
+)
+
+// Migration defines a versioned schema change
+type Migration struct {
+	Version     uint
+	Description string
+	Up          func(tx *gorm.DB) error
+	Down        func(tx *gorm.DB) error
+}
+
+// MigrateOptions defines settings of the deployment, which migrations depend on
+type MigrateOptions struct {
+	// LegacyChainID returns the ID of the chain of data indexed before chain IDs were added,
+	// which is required to migrate a DB holding any, e.g. by checking the configured RPC endpoint serves it
+	LegacyChainID func() (uint64, error)
+}
+
+// LegacyChainOptions creates options assigning data indexed before chain IDs were added to the chain of chainID,
+// once the RPC endpoint at endpoint turns out to serve it, which is how both binaries resolve the legacy chain
+func LegacyChainOptions(chainID uint64, endpoint string) MigrateOptions {
+	return MigrateOptions{LegacyChainID: func() (uint64, error) {
+		return chainID, eth.CheckChainID(context.Background(), endpoint, chainID)
+	}}
+}
+
+// SchemaMigration defines a data structure representing an applied migration
+type SchemaMigration struct {
+	Version     uint `gorm:"primaryKey;autoIncrement:false"`
+	Description string
+	AppliedAt   time.Time
+}
+
+// schemaLock defines the row held by the process running migrations
+type schemaLock struct {
+	ID       uint `gorm:"primaryKey;autoIncrement:false"`
+	LockedAt time.Time
+}
+
+func (schemaLock) TableName() string {
+	return "schema_migrations_lock"
+}
+
+// LatestVersion returns the schema version this binary expects
+func LatestVersion() uint {
+	migrations := newMigrations(MigrateOptions{})
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the latest migration applied to DB, 0 if none is
+func SchemaVersion(gdb *gorm.DB) (uint, error) {
+	if !gdb.Migrator().HasTable(&SchemaMigration{}) {
+		return 0, nil
+	}
+	var version uint
+	if err := gdb.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %v", err)
+	}
+	return version, nil
+}
+
+// CheckSchema returns an error unless DB is migrated to the version this binary expects
+func CheckSchema(gdb *gorm.DB) error {
+	version, err := SchemaVersion(gdb)
+	if err != nil {
+		return err
+	}
+	switch latest := LatestVersion(); {
+	case version < latest:
+		return fmt.Errorf("schema version %d is older than %d, run `migrate up` first", version, latest)
+	case version > latest:
+		return fmt.Errorf("schema version %d is newer than %d supported by this binary", version, latest)
+	}
+	return nil
+}
+
+// lock takes the migration lock, waiting for other processes running migrations,
+// and taking over a lock left by a crashed one after lockTimeout
+func lock(gdb *gorm.DB) error {
+	if err := gdb.AutoMigrate(&schemaLock{}); err != nil {
+		return fmt.Errorf("failed to create migration lock table: %v", err)
+	}
+	for {
+		if err := gdb.Where("locked_at < ?", time.Now().Add(-lockTimeout)).Delete(&schemaLock{}).Error; err != nil {
+			return fmt.Errorf("failed to expire migration lock: %v", err)
+		}
+		ret := gdb.Clauses(clause.OnConflict{DoNothing: true}).Create(&schemaLock{ID: 1, LockedAt: time.Now()})
+		if ret.Error != nil {
+			return fmt.Errorf("failed to take migration lock: %v", ret.Error)
+		}
+		if ret.RowsAffected == 1 {
+			return nil
+		}
+		logging.Root().Info("waiting for migrations run by another process")
+		time.Sleep(lockPoll)
+	}
+}
+
+func unlock(gdb *gorm.DB) {
+	if err := gdb.Delete(&schemaLock{ID: 1}).Error; err != nil {
+		logging.Root().Error("failed to release migration lock", "err", err)
+	}
+}
+
+// Migrate applies or reverts migrations with opts in order until DB is at the target version
+func Migrate(gdb *gorm.DB, target uint, opts MigrateOptions) error {
+	if target > LatestVersion() {
+		return fmt.Errorf("unknown schema version %d, latest is %d", target, LatestVersion())
+	}
+	if err := lock(gdb); err != nil {
+		return err
+	}
+	defer unlock(gdb)
+
+	if err := gdb.AutoMigrate(&SchemaMigration{}); err != nil {
+		return fmt.Errorf("failed to create schema version table: %v", err)
+	}
+	version, err := SchemaVersion(gdb)
+	if err != nil {
+		return err
+	}
+
+	migrations := newMigrations(opts)
+	for _, m := range migrations {
+		if m.Version <= version || m.Version > target {
+			continue
+		}
+		logging.Root().Info("applying migration", "version", m.Version, "description", m.Description)
+		if err := gdb.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Description: m.Description, AppliedAt: time.Now()}).Error
+		}); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %v", m.Version, err)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > version || m.Version <= target {
+			continue
+		}
+		logging.Root().Info("reverting migration", "version", m.Version, "description", m.Description)
+		if err := gdb.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{Version: m.Version}).Error
+		}); err != nil {
+			return fmt.Errorf("failed to revert migration %d: %v", m.Version, err)
+		}
+	}
+	return nil
+}
+
+// RunMigrateCommand runs the `migrate` subcommand with its args and opts:
+//
+//	migrate up [version]    applies migrations up to version, the latest by default
+//	migrate down [version]  reverts migrations down to version, the previous one by default
+//	migrate version         prints the current and latest schema versions
+func RunMigrateCommand(gdb *gorm.DB, args []string, opts MigrateOptions) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down|version [version]")
+	}
+
+	version, err := SchemaVersion(gdb)
+	if err != nil {
+		return err
+	}
+	var target uint
+	switch args[0] {
+	case "up":
+		target = LatestVersion()
+	case "down":
+		if version > 0 {
+			target = version - 1
+		}
+	case "version":
+		fmt.Printf("schema version %d, latest %d\n", version, LatestVersion())
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	if len(args) > 1 {
+		v, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("bad version %q: %v", args[1], err)
+		}
+		target = uint(v)
+	}
+	if (args[0] == "up" && target < version) || (args[0] == "down" && target > version) {
+		return fmt.Errorf("cannot migrate %s from version %d to %d", args[0], version, target)
+	}
+
+	if err := Migrate(gdb, target, opts); err != nil {
+		return err
+	}
+	logging.Root().Info("migrated schema", "from", version, "to", target)
+	return nil
+}
@@ -0,0 +1,293 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// newSQLiteDB opens an empty in-memory SQLite DB, which lives until the test ends
+func newSQLiteDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	gdb, err := InitDB(DriverSQLite, "", fmt.Sprintf("file:%s?mode=memory&cache=shared", name), "", "", "")
+	if err != nil {
+		t.Fatalf("failed to open sqlite DB: %v", err)
+	}
+	t.Cleanup(func() {
+		if pool, err := gdb.DB(); err == nil {
+			pool.Close()
+		}
+	})
+	return gdb
+}
+
+// testOptions assign data indexed before chain IDs were added to the test chain
+var testOptions = MigrateOptions{LegacyChainID: func() (uint64, error) { return testChainID, nil }}
+
+// migrate migrates gdb to version, failing the test if it fails
+func migrate(t *testing.T, gdb *gorm.DB, version uint) {
+	t.Helper()
+	if err := Migrate(gdb, version, testOptions); err != nil {
+		t.Fatalf("failed to migrate to version %d: %v", version, err)
+	}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	gdb := newSQLiteDB(t)
+	if err := CheckSchema(gdb); err == nil {
+		t.Error("empty DB passes schema check")
+	}
+
+	migrate(t, gdb, LatestVersion())
+	if err := CheckSchema(gdb); err != nil {
+		t.Errorf("migrated DB fails schema check: %v", err)
+	}
+	for _, table := range []string{"blocks", "transactions", "logs", "api_keys"} {
+		if !gdb.Migrator().HasTable(table) {
+			t.Errorf("table %s is not created", table)
+		}
+	}
+
+	// every migration is reverted and applied again
+	migrate(t, gdb, 0)
+	if v, err := SchemaVersion(gdb); err != nil || v != 0 {
+		t.Errorf("schema version = %d, %v", v, err)
+	}
+	if gdb.Migrator().HasTable("blocks") {
+		t.Error("table blocks is not dropped")
+	}
+	migrate(t, gdb, LatestVersion())
+	if v, err := SchemaVersion(gdb); err != nil || v != LatestVersion() {
+		t.Errorf("schema version = %d, %v", v, err)
+	}
+
+	if err := Migrate(gdb, LatestVersion()+1, testOptions); err == nil {
+		t.Error("migrated to unknown version")
+	}
+}
+
+func TestMigrateKeepsData(t *testing.T) {
+	gdb := newSQLiteDB(t)
+	migrate(t, gdb, 1)
+	tx1, tx2 := strings.Repeat("1", 64), strings.Repeat("2", 64)
+	for _, row := range []interface{}{
+		&v1Block{Num: 10, Hash: "0xb10"},
+		&v1Transaction{BlockNum: 10, Hash: tx1, Value: "123"},
+		// values of transactions indexed before are empty strings
+		&v1Transaction{BlockNum: 10, Hash: tx2, Value: ""},
+		&v1Log{TransactionHash: tx1, Index: 0, Topics: "[]"},
+	} {
+		// decoded columns are NULL unless decoded
+		if err := gdb.Omit("DecodedInput", "Decoded").Create(row).Error; err != nil {
+			t.Fatalf("failed to insert %T: %v", row, err)
+		}
+	}
+
+	migrate(t, gdb, LatestVersion())
+	store := NewSQLStore(gdb, testChainID)
+	ctx := context.Background()
+	tx, err := store.GetTransaction(ctx, tx1)
+	if err != nil {
+		t.Fatalf("failed to get migrated transaction: %v", err)
+	}
+	if tx.ChainID != testChainID || tx.Value.String() != "123" {
+		t.Errorf("transaction = %+v", tx)
+	}
+	// block numbers of logs are filled from their transactions
+	if len(tx.Logs) != 1 || tx.Logs[0].BlockNum != 10 {
+		t.Errorf("logs = %+v", tx.Logs)
+	}
+	if tx, err := store.GetTransaction(ctx, tx2); err != nil || tx.Value.String() != "0" {
+		t.Errorf("transaction of empty value = %+v, %v", tx, err)
+	}
+	if b, err := store.GetBlockByNumber(ctx, 10); err != nil || len(b.Transactions) != 2 {
+		t.Errorf("block = %+v, %v", b, err)
+	}
+
+	// data survives reverting to the first version
+	migrate(t, gdb, 1)
+	var count int64
+	if err := gdb.Model(&v1Transaction{}).Count(&count).Error; err != nil || count != 2 {
+		t.Errorf("# of transactions = %d, %v", count, err)
+	}
+}
+
+func TestMigrateTakesOverExpiredLock(t *testing.T) {
+	gdb := newSQLiteDB(t)
+	if err := gdb.AutoMigrate(&schemaLock{}); err != nil {
+		t.Fatal(err)
+	}
+	// left by a crashed process
+	if err := gdb.Create(&schemaLock{ID: 1, LockedAt: time.Now().Add(-2 * lockTimeout)}).Error; err != nil {
+		t.Fatal(err)
+	}
+	migrate(t, gdb, LatestVersion())
+	var count int64
+	if err := gdb.Model(&schemaLock{}).Count(&count).Error; err != nil || count != 0 {
+		t.Errorf("migration lock is not released: %d, %v", count, err)
+	}
+}
+
+func TestRunMigrateCommand(t *testing.T) {
+	gdb := newSQLiteDB(t)
+	for _, args := range [][]string{nil, {"sideways"}, {"up", "x"}, {"up", "99"}} {
+		if err := RunMigrateCommand(gdb, args, MigrateOptions{}); err == nil {
+			t.Errorf("migrate %v succeeded", args)
+		}
+	}
+
+	steps := []struct {
+		args    []string
+		version uint
+	}{
+		{[]string{"up", "2"}, 2},
+		{[]string{"up"}, LatestVersion()},
+		{[]string{"down"}, LatestVersion() - 1},
+		{[]string{"down", "1"}, 1},
+		{[]string{"version"}, 1},
+	}
+	for _, s := range steps {
+		if err := RunMigrateCommand(gdb, s.args, MigrateOptions{}); err != nil {
+			t.Fatalf("migrate %v failed: %v", s.args, err)
+		}
+		if v, _ := SchemaVersion(gdb); v != s.version {
+			t.Errorf("schema version after migrate %v = %d, want %d", s.args, v, s.version)
+		}
+	}
+
+	// up and down do not go the other way
+	if err := RunMigrateCommand(gdb, []string{"down", "2"}, MigrateOptions{}); err == nil {
+		t.Error("migrated down to a later version")
+	}
+	if err := RunMigrateCommand(gdb, []string{"up", "0"}, MigrateOptions{}); err == nil {
+		t.Error("migrated up to an earlier version")
+	}
+}
+
+func TestMigrateSQLiteWei(t *testing.T) {
+	gdb := newSQLiteDB(t)
+	migrate(t, gdb, 2)
+	for i, v := range []string{"123", "1000000000000000000000000000000"} {
+		row := &v2Transaction{BlockNum: 1, Hash: fmt.Sprint(i), Value: v}
+		if err := gdb.Omit("DecodedInput").Create(row).Error; err != nil {
+			t.Fatalf("failed to insert transaction: %v", err)
+		}
+	}
+
+	migrate(t, gdb, 3)
+	var values []string
+	if err := gdb.Raw("SELECT value FROM transactions WHERE typeof(value) = 'text' ORDER BY hash").Scan(&values).Error; err != nil {
+		t.Fatal(err)
+	}
+	// values overflowing int64 were approximated already
+	if len(values) != 2 || values[0] != "123" || len(values[1]) != 31 || values[1][:15] != "100000000000000" {
+		t.Errorf("values = %v", values)
+	}
+
+	migrate(t, gdb, 2)
+	var n int64
+	if err := gdb.Raw("SELECT value FROM transactions WHERE hash = '0' AND typeof(value) = 'integer'").Scan(&n).Error; err != nil || n != 123 {
+		t.Errorf("reverted value = %d, %v", n, err)
+	}
+}
+
+// primaryKey returns the columns of the primary key of a sqlite table in order
+func primaryKey(t *testing.T, gdb *gorm.DB, table string) string {
+	t.Helper()
+	var columns []string
+	if err := gdb.Raw("SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk", table).Scan(&columns).Error; err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(columns, ", ")
+}
+
+func TestMigrateBlockNumPrimaryKeys(t *testing.T) {
+	gdb := newSQLiteDB(t)
+	migrate(t, gdb, 4)
+	h := testHash("tx", 1, "")
+	for _, row := range []interface{}{
+		&v1Block{Num: 1},
+		&v3Transaction{BlockNum: 1, Hash: h, Value: "1"},
+		&v4Log{TransactionHash: h, BlockNum: 1, Topics: "[]"},
+	} {
+		if err := gdb.Omit("DecodedInput", "Decoded").Create(row).Error; err != nil {
+			t.Fatalf("failed to insert %T: %v", row, err)
+		}
+	}
+
+	migrate(t, gdb, 5)
+	if pk := primaryKey(t, gdb, "transactions"); pk != "hash, block_num" {
+		t.Errorf("primary key of transactions = %s", pk)
+	}
+	if pk := primaryKey(t, gdb, "logs"); pk != "transaction_hash, index, block_num" {
+		t.Errorf("primary key of logs = %s", pk)
+	}
+	// rows of the rebuilt tables are assigned to the test chain by the next migration
+	migrate(t, gdb, 6)
+	tx, err := NewSQLStore(gdb, testChainID).GetTransaction(context.Background(), h)
+	if err != nil || tx.BlockNum != 1 || tx.Value.String() != "1" || len(tx.Logs) != 1 {
+		t.Errorf("migrated transaction = %+v, %v", tx, err)
+	}
+	var kind string
+	if err := gdb.Raw("SELECT typeof(value) FROM transactions").Scan(&kind).Error; err != nil || kind != "text" {
+		t.Errorf("type of values = %s, %v", kind, err)
+	}
+
+	migrate(t, gdb, 4)
+	if pk := primaryKey(t, gdb, "transactions"); pk != "hash" {
+		t.Errorf("reverted primary key of transactions = %s", pk)
+	}
+	var count int64
+	if err := gdb.Model(&v4Log{}).Where("transaction_hash = ?", h).Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("# of reverted logs = %d, %v", count, err)
+	}
+}
+
+func TestMigrateLegacyChainID(t *testing.T) {
+	// a new DB is migrated without the chain, whose RPC endpoint may not be reachable
+	if err := Migrate(newSQLiteDB(t), LatestVersion(), MigrateOptions{}); err != nil {
+		t.Fatalf("failed to migrate empty DB: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    MigrateOptions
+		chainID uint64
+	}{
+		{"unknown", MigrateOptions{}, 0},
+		{"unreachable", MigrateOptions{LegacyChainID: func() (uint64, error) { return 0, errors.New("connection refused") }}, 0},
+		{"unreachable endpoint", LegacyChainOptions(56, "http://127.0.0.1:1"), 0},
+		{"resolved", MigrateOptions{LegacyChainID: func() (uint64, error) { return 56, nil }}, 56},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gdb := newSQLiteDB(t)
+			migrate(t, gdb, 5)
+			if err := gdb.Create(&v1Block{Num: 1, Hash: "0xb1"}).Error; err != nil {
+				t.Fatal(err)
+			}
+			err := Migrate(gdb, LatestVersion(), tt.opts)
+			if tt.chainID == 0 {
+				if err == nil {
+					t.Fatal("assigned data indexed before to an unknown chain")
+				}
+				if v, _ := SchemaVersion(gdb); v != 5 {
+					t.Errorf("schema version = %d, want 5", v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to migrate: %v", err)
+			}
+			if b, err := NewSQLStore(gdb, tt.chainID).GetBlockByNumber(context.Background(), 1); err != nil || b.Hash != "0xb1" {
+				t.Errorf("block of chain %d = %+v, %v", tt.chainID, b, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,644 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// newMigrations returns migrations with opts, which are applied in order, and must never be edited once released;
+// models of each migration are snapshots of the schema at that version
+func newMigrations(opts MigrateOptions) []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "create tables, adopting ones created by AutoMigrate",
+			Up: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(v1Tables...)
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(v1Tables...)
+			},
+		},
+		{
+			Version:     2,
+			Description: "store wei amounts as decimal(78,0)",
+			Up: func(tx *gorm.DB) error {
+				return alterColumns(tx, true, &v2Transaction{}, &v2InternalTransaction{}, &v2Balance{})
+			},
+			Down: func(tx *gorm.DB) error {
+				return alterColumns(tx, false, &v1Transaction{}, &v1InternalTransaction{}, &v1Balance{})
+			},
+		},
+		{
+			Version:     3,
+			Description: "store wei amounts as text on sqlite, which approximates decimals as floating points",
+			Up: func(tx *gorm.DB) error {
+				// values are approximated already, so they are only kept as they read
+				return alterSQLiteWei(tx, "text", "CASE typeof(?) WHEN 'real' THEN printf('%.0f', ?) ELSE CAST(? AS TEXT) END")
+			},
+			Down: func(tx *gorm.DB) error {
+				return alterSQLiteWei(tx, "decimal(78,0)", "?")
+			},
+		},
+		{
+			Version:     4,
+			Description: "add block numbers to logs",
+			Up: func(tx *gorm.DB) error {
+				if err := tx.Migrator().AddColumn(&v4Log{}, "BlockNum"); err != nil {
+					return err
+				}
+				if err := tx.Exec("UPDATE logs SET block_num = COALESCE((SELECT block_num FROM transactions WHERE transactions.hash = logs.transaction_hash), 0)").Error; err != nil {
+					return fmt.Errorf("failed to fill block numbers of logs: %v", err)
+				}
+				return tx.Migrator().CreateIndex(&v4Log{}, "BlockNum")
+			},
+			Down: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropIndex(&v4Log{}, "BlockNum"); err != nil {
+					return err
+				}
+				return tx.Migrator().DropColumn(&v4Log{}, "BlockNum")
+			},
+		},
+		{
+			Version:     5,
+			Description: "add block numbers to primary keys of transactions and logs, which partitioning requires",
+			Up: func(tx *gorm.DB) error {
+				return rebuildTables(tx, []interface{}{&v3Transaction{}, &v4Log{}}, []interface{}{&v5Transaction{}, &v5Log{}}, nil)
+			},
+			Down: func(tx *gorm.DB) error {
+				// partitioned tables keep block numbers in their primary keys
+				return rebuildTables(tx, []interface{}{&v5Transaction{}, &v5Log{}}, []interface{}{&v3Transaction{}, &v4Log{}}, nil)
+			},
+		},
+		{
+			Version:     6,
+			Description: "add chain IDs to every table and primary key",
+			Up: func(tx *gorm.DB) error {
+				chainID, err := legacyChainID(tx, opts)
+				if err != nil {
+					return err
+				}
+				return rebuildTables(tx, v5Tables, v6Tables, map[string]interface{}{"chain_id": chainID})
+			},
+			Down: func(tx *gorm.DB) error {
+				// primary keys without chain IDs cannot hold the same keys of different chains
+				var chains []uint64
+				if err := tx.Model(&v6Block{}).Distinct("chain_id").Pluck("chain_id", &chains).Error; err != nil {
+					return fmt.Errorf("failed to list chains: %v", err)
+				}
+				if len(chains) > 1 {
+					return fmt.Errorf("cannot revert with blocks of chains %v, delete all but one first", chains)
+				}
+				return rebuildTables(tx, v6Tables, v5Tables, nil)
+			},
+		},
+		{
+			Version:     7,
+			Description: "add API keys and their usage",
+			Up: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(v7Tables...)
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(v7Tables...)
+			},
+		},
+	}
+}
+
+// legacyChainID returns the chain of data indexed before chain IDs were added, which is resolved by opts
+// only if tables hold any, since the RPC endpoint may not be reachable, e.g. when migrating a new DB
+func legacyChainID(tx *gorm.DB, opts MigrateOptions) (uint64, error) {
+	empty := true
+	for _, model := range v5Tables {
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(model); err != nil {
+			return 0, err
+		}
+		var found []int
+		if err := tx.Raw("SELECT 1 FROM ? LIMIT 1", clause.Table{Name: stmt.Table}).Scan(&found).Error; err != nil {
+			return 0, fmt.Errorf("failed to find rows of %s: %v", stmt.Table, err)
+		}
+		empty = empty && len(found) == 0
+	}
+	if empty {
+		return 0, nil
+	}
+	if opts.LegacyChainID == nil {
+		return 0, errors.New("cannot assign data indexed before to an unknown chain")
+	}
+	chainID, err := opts.LegacyChainID()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the chain of data indexed before: %v", err)
+	}
+	return chainID, nil
+}
+
+// rebuildTables replaces the table of each model in from with the one of the model in to at the same position,
+// which changes primary keys portably, copying columns of both models and setting the other columns to values
+func rebuildTables(tx *gorm.DB, from, to []interface{}, values map[string]interface{}) error {
+	olds := make([]string, len(from))
+	for i := range from {
+		old, err := rebuildTable(tx, from[i], to[i], values)
+		if err != nil {
+			return err
+		}
+		olds[i] = old
+	}
+	// drop tables in reverse, since tables adopted from AutoMigrate have foreign keys to preceding ones
+	for i := len(olds) - 1; i >= 0; i-- {
+		if err := tx.Migrator().DropTable(olds[i]); err != nil {
+			return fmt.Errorf("failed to drop %s: %v", olds[i], err)
+		}
+	}
+	return nil
+}
+
+// rebuildTable renames the table of from, creates the one of to, partitioned like the renamed one,
+// and copies rows to it, returning the name of the renamed table to be dropped
+func rebuildTable(tx *gorm.DB, from, to interface{}, values map[string]interface{}) (string, error) {
+	src, dst := &gorm.Statement{DB: tx}, &gorm.Statement{DB: tx}
+	if err := src.Parse(from); err != nil {
+		return "", err
+	}
+	if err := dst.Parse(to); err != nil {
+		return "", err
+	}
+	m := tx.Migrator()
+	old := src.Table + "_old"
+
+	// index names are unique per schema in postgres and sqlite, so they are freed for the new table
+	for _, idx := range src.Schema.ParseIndexes() {
+		if m.HasIndex(from, idx.Name) {
+			if err := m.DropIndex(from, idx.Name); err != nil {
+				return "", fmt.Errorf("failed to drop index %s: %v", idx.Name, err)
+			}
+		}
+	}
+	if err := m.RenameTable(src.Table, old); err != nil {
+		return "", fmt.Errorf("failed to rename %s: %v", src.Table, err)
+	}
+	if tx.Dialector.Name() == DriverPostgres {
+		if err := tx.Exec("ALTER INDEX IF EXISTS ? RENAME TO ?",
+			clause.Table{Name: src.Table + "_pkey"}, clause.Table{Name: old + "_pkey"}).Error; err != nil {
+			return "", fmt.Errorf("failed to rename primary key of %s: %v", src.Table, err)
+		}
+	}
+	if err := m.CreateTable(to); err != nil {
+		return "", fmt.Errorf("failed to create %s: %v", dst.Table, err)
+	}
+	if err := copyPartitions(tx, old, dst.Table); err != nil {
+		return "", err
+	}
+
+	var (
+		columns, selects []string
+		args             []interface{}
+	)
+	for _, name := range dst.Schema.DBNames {
+		if v, ok := values[name]; ok {
+			columns, selects, args = append(columns, tx.Statement.Quote(name)), append(selects, "?"), append(args, v)
+		} else if src.Schema.LookUpField(name) != nil {
+			columns, selects = append(columns, tx.Statement.Quote(name)), append(selects, tx.Statement.Quote(name))
+		}
+	}
+	if err := tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", tx.Statement.Quote(dst.Table),
+		strings.Join(columns, ", "), strings.Join(selects, ", "), tx.Statement.Quote(old)), args...).Error; err != nil {
+		return "", fmt.Errorf("failed to copy %s: %v", src.Table, err)
+	}
+	return old, nil
+}
+
+// weiFields maps tables to their columns of wei amounts
+var weiFields = map[string]string{
+	"transactions":          "Value",
+	"internal_transactions": "Value",
+	"balances":              "Balance",
+}
+
+// alterColumns changes wei columns to their types in models, converting existing values,
+// after replacing empty strings, which are not numbers, with 0 if fillEmpty is set
+func alterColumns(tx *gorm.DB, fillEmpty bool, models ...interface{}) error {
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+		field := stmt.Schema.LookUpField(weiFields[stmt.Table])
+		column := clause.Column{Name: field.DBName}
+
+		if fillEmpty {
+			if err := tx.Table(stmt.Table).Where(clause.Eq{Column: column, Value: ""}).
+				Update(field.DBName, "0").Error; err != nil {
+				return fmt.Errorf("failed to fill empty %s.%s: %v", stmt.Table, field.DBName, err)
+			}
+		}
+		var (
+			table    = clause.Table{Name: stmt.Table}
+			dataType = tx.Dialector.DataTypeOf(field)
+			err      error
+		)
+		switch tx.Dialector.Name() {
+		case DriverPostgres:
+			// postgres casts values only with an explicit USING clause
+			err = tx.Exec("ALTER TABLE ? ALTER COLUMN ? TYPE "+dataType+" USING ?::"+dataType, table, column, column).Error
+		case DriverSQLite:
+			// values are converted by the affinity of the new column
+			err = replaceColumn(tx, stmt.Table, field.DBName, dataType, "?")
+		default:
+			err = tx.Migrator().AlterColumn(model, field.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to alter %s.%s: %v", stmt.Table, field.DBName, err)
+		}
+	}
+	return nil
+}
+
+// alterSQLiteWei changes wei columns to dataType on sqlite, converting values by the expression of the column
+// in placeholders, while the columns of the other drivers are kept as decimals
+func alterSQLiteWei(tx *gorm.DB, dataType, convert string) error {
+	if tx.Dialector.Name() != DriverSQLite {
+		return nil
+	}
+	for table, field := range weiFields {
+		column := tx.NamingStrategy.ColumnName(table, field)
+		if err := replaceColumn(tx, table, column, dataType, convert); err != nil {
+			return fmt.Errorf("failed to alter %s.%s: %v", table, column, err)
+		}
+	}
+	return nil
+}
+
+// replaceColumn replaces a column of a sqlite table with one of dataType, whose values are set by the expression
+// of the old column in placeholders, since the sqlite migrator fails to rewrite types with commas
+func replaceColumn(tx *gorm.DB, table, column, dataType, convert string) error {
+	t, old, tmp := clause.Table{Name: table}, clause.Column{Name: column}, clause.Column{Name: column + "_new"}
+	if err := tx.Exec("ALTER TABLE ? ADD COLUMN ? "+dataType, t, tmp).Error; err != nil {
+		return err
+	}
+	args := make([]interface{}, strings.Count(convert, "?"))
+	for i := range args {
+		args[i] = old
+	}
+	if err := tx.Exec("UPDATE ? SET ? = "+convert, append([]interface{}{t, tmp}, args...)...).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("ALTER TABLE ? DROP COLUMN ?", t, old).Error; err != nil {
+		return err
+	}
+	return tx.Exec("ALTER TABLE ? RENAME COLUMN ? TO ?", t, tmp, old).Error
+}
+
+var v1Tables = []interface{}{
+	&v1Block{}, &v1Transaction{}, &v1Log{}, &v1Token{}, &v1ContractABI{},
+	&v1Balance{}, &v1InternalTransaction{}, &v1Contract{},
+}
+
+type v1Block struct {
+	Num        uint64 `gorm:"primaryKey"`
+	Hash       string `gorm:"index"`
+	Time       uint64
+	ParentHash string
+}
+
+func (v1Block) TableName() string { return "blocks" }
+
+type v1Transaction struct {
+	BlockNum        uint64 `gorm:"index"`
+	Hash            string `gorm:"primaryKey"`
+	From            string
+	To              string `gorm:"index"`
+	Nounce          uint64
+	Data            string
+	Value           string
+	ContractAddress string
+	Method          string
+	DecodedInput    string `gorm:"type:text"`
+}
+
+func (v1Transaction) TableName() string { return "transactions" }
+
+type v1Log struct {
+	TransactionHash string `gorm:"primaryKey"`
+	Address         string `gorm:"index"`
+	Topics          string `gorm:"type:text"`
+	Index           uint   `gorm:"primaryKey;autoIncrement:false"`
+	Data            string
+	Event           string
+	Decoded         string `gorm:"type:text"`
+}
+
+func (v1Log) TableName() string { return "logs" }
+
+type v1Token struct {
+	Address     string `gorm:"primaryKey"`
+	Name        string
+	Symbol      string
+	Decimals    uint8
+	TotalSupply string
+}
+
+func (v1Token) TableName() string { return "tokens" }
+
+type v1ContractABI struct {
+	Address string `gorm:"primaryKey"`
+	ABI     string `gorm:"type:text"`
+}
+
+func (v1ContractABI) TableName() string { return "contract_abis" }
+
+type v1Balance struct {
+	Address  string `gorm:"primaryKey"`
+	BlockNum uint64 `gorm:"primaryKey"`
+	Balance  string
+}
+
+func (v1Balance) TableName() string { return "balances" }
+
+type v1InternalTransaction struct {
+	TransactionHash string `gorm:"primaryKey"`
+	Index           uint   `gorm:"primaryKey"`
+	BlockNum        uint64 `gorm:"index"`
+	Type            string
+	From            string `gorm:"index"`
+	To              string `gorm:"index"`
+	Value           string
+	Depth           uint
+	Error           string
+}
+
+func (v1InternalTransaction) TableName() string { return "internal_transactions" }
+
+type v1Contract struct {
+	Address         string `gorm:"primaryKey"`
+	Deployer        string `gorm:"index"`
+	TransactionHash string `gorm:"index"`
+	BlockNum        uint64 `gorm:"index"`
+	BytecodeHash    string `gorm:"index"`
+	Standard        string
+}
+
+func (v1Contract) TableName() string { return "contracts" }
+
+type v2Transaction struct {
+	BlockNum        uint64 `gorm:"index"`
+	Hash            string `gorm:"primaryKey"`
+	From            string
+	To              string `gorm:"index"`
+	Nounce          uint64
+	Data            string
+	Value           string `gorm:"type:decimal(78,0)"`
+	ContractAddress string
+	Method          string
+	DecodedInput    string `gorm:"type:text"`
+}
+
+func (v2Transaction) TableName() string { return "transactions" }
+
+type v2Balance struct {
+	Address  string `gorm:"primaryKey"`
+	BlockNum uint64 `gorm:"primaryKey"`
+	Balance  string `gorm:"type:decimal(78,0)"`
+}
+
+func (v2Balance) TableName() string { return "balances" }
+
+type v2InternalTransaction struct {
+	TransactionHash string `gorm:"primaryKey"`
+	Index           uint   `gorm:"primaryKey"`
+	BlockNum        uint64 `gorm:"index"`
+	Type            string
+	From            string `gorm:"index"`
+	To              string `gorm:"index"`
+	Value           string `gorm:"type:decimal(78,0)"`
+	Depth           uint
+	Error           string
+}
+
+func (v2InternalTransaction) TableName() string { return "internal_transactions" }
+
+type v4Log struct {
+	TransactionHash string `gorm:"primaryKey"`
+	BlockNum        uint64 `gorm:"index"`
+	Address         string `gorm:"index"`
+	Topics          string `gorm:"type:text"`
+	Index           uint   `gorm:"primaryKey;autoIncrement:false"`
+	Data            string
+	Event           string
+	Decoded         string `gorm:"type:text"`
+}
+
+func (v4Log) TableName() string { return "logs" }
+
+// v3Wei is a wei amount, stored as text on sqlite since version 3
+type v3Wei string
+
+func (v3Wei) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == DriverSQLite {
+		return "text"
+	}
+	return "decimal(78,0)"
+}
+
+type v3Transaction struct {
+	BlockNum        uint64 `gorm:"index"`
+	Hash            string `gorm:"primaryKey"`
+	From            string
+	To              string `gorm:"index"`
+	Nounce          uint64
+	Data            string
+	Value           v3Wei
+	ContractAddress string
+	Method          string
+	DecodedInput    string `gorm:"type:text"`
+}
+
+func (v3Transaction) TableName() string { return "transactions" }
+
+type v3Balance struct {
+	Address  string `gorm:"primaryKey"`
+	BlockNum uint64 `gorm:"primaryKey"`
+	Balance  v3Wei
+}
+
+func (v3Balance) TableName() string { return "balances" }
+
+type v3InternalTransaction struct {
+	TransactionHash string `gorm:"primaryKey"`
+	Index           uint   `gorm:"primaryKey"`
+	BlockNum        uint64 `gorm:"index"`
+	Type            string
+	From            string `gorm:"index"`
+	To              string `gorm:"index"`
+	Value           v3Wei
+	Depth           uint
+	Error           string
+}
+
+func (v3InternalTransaction) TableName() string { return "internal_transactions" }
+
+var v5Tables = []interface{}{
+	&v1Block{}, &v5Transaction{}, &v5Log{}, &v1Token{}, &v1ContractABI{},
+	&v3Balance{}, &v3InternalTransaction{}, &v1Contract{},
+}
+
+type v5Transaction struct {
+	Hash            string `gorm:"primaryKey"`
+	BlockNum        uint64 `gorm:"primaryKey;autoIncrement:false;index"`
+	From            string
+	To              string `gorm:"index"`
+	Nounce          uint64
+	Data            string
+	Value           v3Wei
+	ContractAddress string
+	Method          string
+	DecodedInput    string `gorm:"type:text"`
+}
+
+func (v5Transaction) TableName() string { return "transactions" }
+
+type v5Log struct {
+	TransactionHash string `gorm:"primaryKey"`
+	Address         string `gorm:"index"`
+	Topics          string `gorm:"type:text"`
+	Index           uint   `gorm:"primaryKey;autoIncrement:false"`
+	BlockNum        uint64 `gorm:"primaryKey;autoIncrement:false;index"`
+	Data            string
+	Event           string
+	Decoded         string `gorm:"type:text"`
+}
+
+func (v5Log) TableName() string { return "logs" }
+
+var v6Tables = []interface{}{
+	&v6Block{}, &v6Transaction{}, &v6Log{}, &v6Token{}, &v6ContractABI{},
+	&v6Balance{}, &v6InternalTransaction{}, &v6Contract{},
+}
+
+type v6Block struct {
+	ChainID    uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Num        uint64 `gorm:"primaryKey"`
+	Hash       string `gorm:"index"`
+	Time       uint64
+	ParentHash string
+}
+
+func (v6Block) TableName() string { return "blocks" }
+
+type v6Transaction struct {
+	ChainID         uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Hash            string `gorm:"primaryKey"`
+	BlockNum        uint64 `gorm:"primaryKey;autoIncrement:false;index"`
+	From            string
+	To              string `gorm:"index"`
+	Nounce          uint64
+	Data            string
+	Value           v3Wei
+	ContractAddress string
+	Method          string
+	DecodedInput    string `gorm:"type:text"`
+}
+
+func (v6Transaction) TableName() string { return "transactions" }
+
+type v6Log struct {
+	ChainID         uint64 `gorm:"primaryKey;autoIncrement:false"`
+	TransactionHash string `gorm:"primaryKey"`
+	Address         string `gorm:"index"`
+	Topics          string `gorm:"type:text"`
+	Index           uint   `gorm:"primaryKey;autoIncrement:false"`
+	BlockNum        uint64 `gorm:"primaryKey;autoIncrement:false;index"`
+	Data            string
+	Event           string
+	Decoded         string `gorm:"type:text"`
+}
+
+func (v6Log) TableName() string { return "logs" }
+
+type v6Token struct {
+	ChainID     uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Address     string `gorm:"primaryKey"`
+	Name        string
+	Symbol      string
+	Decimals    uint8
+	TotalSupply string
+}
+
+func (v6Token) TableName() string { return "tokens" }
+
+type v6ContractABI struct {
+	ChainID uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Address string `gorm:"primaryKey"`
+	ABI     string `gorm:"type:text"`
+}
+
+func (v6ContractABI) TableName() string { return "contract_abis" }
+
+type v6Balance struct {
+	ChainID  uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Address  string `gorm:"primaryKey"`
+	BlockNum uint64 `gorm:"primaryKey"`
+	Balance  v3Wei
+}
+
+func (v6Balance) TableName() string { return "balances" }
+
+type v6InternalTransaction struct {
+	ChainID         uint64 `gorm:"primaryKey;autoIncrement:false"`
+	TransactionHash string `gorm:"primaryKey"`
+	Index           uint   `gorm:"primaryKey"`
+	BlockNum        uint64 `gorm:"index"`
+	Type            string
+	From            string `gorm:"index"`
+	To              string `gorm:"index"`
+	Value           v3Wei
+	Depth           uint
+	Error           string
+}
+
+func (v6InternalTransaction) TableName() string { return "internal_transactions" }
+
+type v6Contract struct {
+	ChainID         uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Address         string `gorm:"primaryKey"`
+	Deployer        string `gorm:"index"`
+	TransactionHash string `gorm:"index"`
+	BlockNum        uint64 `gorm:"index"`
+	BytecodeHash    string `gorm:"index"`
+	Standard        string
+}
+
+func (v6Contract) TableName() string { return "contracts" }
+
+var v7Tables = []interface{}{
+	&v7APIKey{}, &v7KeyUsage{},
+}
+
+type v7APIKey struct {
+	ID         uint `gorm:"primaryKey"`
+	Name       string
+	Prefix     string
+	Hash       string `gorm:"size:64;uniqueIndex"`
+	Rate       float64
+	Burst      int
+	DailyQuota int64
+	CreatedAt  time.Time
+	RevokedAt  *time.Time
+}
+
+func (v7APIKey) TableName() string { return "api_keys" }
+
+type v7KeyUsage struct {
+	KeyID      uint   `gorm:"primaryKey;autoIncrement:false"`
+	Day        string `gorm:"primaryKey;size:10"`
+	Requests   int64
+	LastUsedAt time.Time
+}
+
+func (v7KeyUsage) TableName() string { return "api_key_usage" }
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/r04922101/portto/eth"
+	"gorm.io/gorm"
+)
+
+const maxValue = "MAXVALUE"
+
+// partitionedTables lists tables range partitioned by block number,
+// whose primary keys include block_num since mysql requires every unique key to include the partitioning column
+var partitionedTables = []string{"transactions", "logs"}
+
+// partition defines a range partition, holding rows of block numbers less than its bound
+type partition struct {
+	Name  string
+	Bound string
+}
+
+// partitions returns partitions of table in order, none if it is not partitioned
+func partitions(tx *gorm.DB, table string) ([]partition, error) {
+	var ps []partition
+	if err := tx.Raw("SELECT PARTITION_NAME AS name, PARTITION_DESCRIPTION AS bound FROM information_schema.PARTITIONS "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND PARTITION_NAME IS NOT NULL ORDER BY PARTITION_ORDINAL_POSITION",
+		table).Scan(&ps).Error; err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %v", table, err)
+	}
+	return ps, nil
+}
+
+// copyPartitions range partitions table by block number like src if it is partitioned on mysql,
+// extending the primary key of table by block_num if it is missing, as partitioning did before version 5
+func copyPartitions(tx *gorm.DB, src, table string) error {
+	if tx.Dialector.Name() != DriverMySQL {
+		return nil
+	}
+	ps, err := partitions(tx, src)
+	if err != nil || len(ps) == 0 {
+		return err
+	}
+
+	var keys []string
+	if err := tx.Raw("SELECT COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_NAME = 'PRIMARY' ORDER BY ORDINAL_POSITION",
+		table).Scan(&keys).Error; err != nil {
+		return fmt.Errorf("failed to list primary key of %s: %v", table, err)
+	}
+	extended := false
+	for _, k := range keys {
+		extended = extended || k == "block_num"
+	}
+	if !extended {
+		keys = append(keys, "block_num")
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE `%s` DROP PRIMARY KEY, ADD PRIMARY KEY (`%s`)",
+			table, strings.Join(keys, "`, `"))).Error; err != nil {
+			return fmt.Errorf("failed to extend primary key of %s: %v", table, err)
+		}
+	}
+
+	defs := make([]string, len(ps))
+	for i, p := range ps {
+		bound := p.Bound
+		if bound != maxValue {
+			bound = "(" + bound + ")"
+		}
+		defs[i] = fmt.Sprintf("PARTITION %s VALUES LESS THAN %s", p.Name, bound)
+	}
+	if err := tx.Exec(fmt.Sprintf("ALTER TABLE `%s` PARTITION BY RANGE (`block_num`) (%s)",
+		table, strings.Join(defs, ", "))).Error; err != nil {
+		return fmt.Errorf("failed to partition %s like %s: %v", table, src, err)
+	}
+	return nil
+}
+
+// partitionDefs defines partitions of size blocks with bounds from `from` until covering `until`,
+// followed by the one holding the rest
+func partitionDefs(from, until, size uint64) string {
+	var defs []string
+	for b := from; b <= until; b += size {
+		defs = append(defs, fmt.Sprintf("PARTITION p%d VALUES LESS THAN (%d)", b, b))
+	}
+	defs = append(defs, "PARTITION pmax VALUES LESS THAN "+maxValue)
+	return strings.Join(defs, ", ")
+}
+
+func (s *sqlStore) Partition(ctx context.Context, size uint64) error {
+	if s.db.Dialector.Name() != DriverMySQL {
+		return fmt.Errorf("partitioning is not supported by %s", s.db.Dialector.Name())
+	}
+	if size == 0 {
+		return fmt.Errorf("bad partition size 0")
+	}
+	// partitions hold rows of every chain, so they cover blocks of all chains
+	var bounds struct {
+		Earliest uint64
+		Latest   uint64
+	}
+	if err := s.db.WithContext(ctx).Model(&eth.Block{}).
+		Select("COALESCE(MIN(num), 0) AS earliest, COALESCE(MAX(num), 0) AS latest").
+		Scan(&bounds).Error; err != nil {
+		return fmt.Errorf("failed to get block range of all chains from DB: %v", err)
+	}
+	earliest, latest := bounds.Earliest, bounds.Latest
+	// keep a partition ahead of the latest block, so that pmax stays empty and cheap to split
+	until := (latest/size + 2) * size
+
+	tx := s.db.WithContext(ctx)
+	for _, t := range partitionedTables {
+		ps, err := partitions(tx, t)
+		if err != nil {
+			return err
+		}
+
+		if len(ps) == 0 {
+			// partitioned tables support no foreign keys, which AutoMigrate created for associations
+			var fks []string
+			if err := tx.Raw("SELECT CONSTRAINT_NAME FROM information_schema.TABLE_CONSTRAINTS "+
+				"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_TYPE = 'FOREIGN KEY'",
+				t).Scan(&fks).Error; err != nil {
+				return fmt.Errorf("failed to list foreign keys of %s: %v", t, err)
+			}
+			for _, fk := range fks {
+				if err := tx.Exec(fmt.Sprintf("ALTER TABLE `%s` DROP FOREIGN KEY `%s`", t, fk)).Error; err != nil {
+					return fmt.Errorf("failed to drop foreign key %s of %s: %v", fk, t, err)
+				}
+			}
+
+			s.log.Info("partitioning table, which rebuilds it", "table", t, "size", size)
+			if err := tx.Exec(fmt.Sprintf("ALTER TABLE `%s` PARTITION BY RANGE (`block_num`) (%s)",
+				t, partitionDefs((earliest/size+1)*size, until, size))).Error; err != nil {
+				return fmt.Errorf("failed to partition %s: %v", t, err)
+			}
+			continue
+		}
+
+		// split pmax to add partitions up to the one ahead of the latest block
+		var last uint64
+		for _, p := range ps {
+			if b, err := strconv.ParseUint(p.Bound, 10, 64); err == nil && b > last {
+				last = b
+			}
+		}
+		if last >= until {
+			continue
+		}
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE `%s` REORGANIZE PARTITION pmax INTO (%s)",
+			t, partitionDefs(last+size, until, size))).Error; err != nil {
+			return fmt.Errorf("failed to add partitions to %s: %v", t, err)
+		}
+	}
+	return nil
+}
+
+// dropPartitions drops partitions holding only blocks numbered below `before`,
+// which is much faster than deleting their rows, unless they hold rows of other chains as well
+func (s *sqlStore) dropPartitions(ctx context.Context, before uint64) error {
+	tx := s.db.WithContext(ctx)
+	var other eth.Block
+	if err := first(tx.Select("chain_id"), &other, "chain_id <> ?", s.chainID); err == nil {
+		s.log.Info("keeping partitions shared with another chain, deleting rows instead", "other_chain", other.ChainID)
+		return nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to find blocks of other chains in DB: %v", err)
+	}
+	for _, t := range partitionedTables {
+		ps, err := partitions(tx, t)
+		if err != nil {
+			return err
+		}
+		var names []string
+		for _, p := range ps {
+			if b, err := strconv.ParseUint(p.Bound, 10, 64); err == nil && b <= before {
+				names = append(names, p.Name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE `%s` DROP PARTITION %s", t, strings.Join(names, ", "))).Error; err != nil {
+			return fmt.Errorf("failed to drop partitions of %s: %v", t, err)
+		}
+		s.log.Info("dropped partitions", "table", t, "partitions", strings.Join(names, ", "))
+	}
+	return nil
+}
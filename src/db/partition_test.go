@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPartitionDefs(t *testing.T) {
+	tests := []struct {
+		from, until, size uint64
+		want              string
+	}{
+		{100, 300, 100, "PARTITION p100 VALUES LESS THAN (100), PARTITION p200 VALUES LESS THAN (200), " +
+			"PARTITION p300 VALUES LESS THAN (300), PARTITION pmax VALUES LESS THAN MAXVALUE"},
+		{100, 250, 100, "PARTITION p100 VALUES LESS THAN (100), PARTITION p200 VALUES LESS THAN (200), " +
+			"PARTITION pmax VALUES LESS THAN MAXVALUE"},
+		{300, 200, 100, "PARTITION pmax VALUES LESS THAN MAXVALUE"},
+	}
+	for _, tt := range tests {
+		if got := partitionDefs(tt.from, tt.until, tt.size); got != tt.want {
+			t.Errorf("partitionDefs(%d, %d, %d) = %q, want %q", tt.from, tt.until, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestPartitionUnsupported(t *testing.T) {
+	gdb := newSQLiteDB(t)
+	migrate(t, gdb, LatestVersion())
+	s := NewSQLStore(gdb, testChainID)
+	if err := s.Partition(context.Background(), 100); err == nil {
+		t.Error("partitioned sqlite tables")
+	}
+	// partitions are only copied on mysql
+	if err := copyPartitions(gdb, "transactions", "logs"); err != nil {
+		t.Errorf("failed to skip copying partitions: %v", err)
+	}
+}
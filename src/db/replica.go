@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/r04922101/portto/eth"
+	"github.com/r04922101/portto/logging"
+	"golang.org/x/sync/singleflight"
+)
+
+// headTTL is how long latest block numbers of stores are cached for routing reads
+const headTTL = time.Second
+
+// head caches the latest block number of a store
+type head struct {
+	store Store
+	// refresh coalesces concurrent lookups of an expired number, which are made without mu held
+	refresh singleflight.Group
+
+	mu        sync.Mutex
+	num       uint64
+	checkedAt time.Time
+}
+
+func (h *head) get(ctx context.Context) (uint64, error) {
+	h.mu.Lock()
+	n, checkedAt := h.num, h.checkedAt
+	h.mu.Unlock()
+	if time.Since(checkedAt) < headTTL {
+		return n, nil
+	}
+
+	ch := h.refresh.DoChan("", func() (interface{}, error) {
+		n, err := h.store.LatestNum(ctx)
+		if err != nil {
+			return uint64(0), err
+		}
+		h.mu.Lock()
+		h.num, h.checkedAt = n, time.Now()
+		h.mu.Unlock()
+		return n, nil
+	})
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return 0, r.Err
+		}
+		return r.Val.(uint64), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// replicatedStore writes to the primary, and routes reads to replicas caught up enough to serve them
+type replicatedStore struct {
+	Store
+	primary  *head
+	replicas []*head
+	maxLag   uint64
+	next     uint32
+}
+
+// NewReplicatedStore creates a store writing to primary and reading from replicas,
+// which are used for reads of recent data only if they lag behind primary by at most maxLag blocks,
+// and for reads at a block only if they have it; reads fall back to primary otherwise
+func NewReplicatedStore(primary Store, replicas []Store, maxLag uint64) Store {
+	if len(replicas) == 0 {
+		return primary
+	}
+	s := &replicatedStore{
+		Store:   primary,
+		primary: &head{store: primary},
+		maxLag:  maxLag,
+	}
+	for _, r := range replicas {
+		s.replicas = append(s.replicas, &head{store: r})
+	}
+	return s
+}
+
+// at returns a replica having blocks up to n in round robin, or primary if none does
+func (s *replicatedStore) at(ctx context.Context, n uint64) Store {
+	start := atomic.AddUint32(&s.next, 1)
+	for i := range s.replicas {
+		r := s.replicas[(int(start)+i)%len(s.replicas)]
+		h, err := r.get(ctx)
+		if err != nil {
+			logging.Root().Warn("failed to get latest block of replica", "chain", r.store.ChainID(), "err", err)
+			continue
+		}
+		if h >= n {
+			return r.store
+		}
+	}
+	return s.Store
+}
+
+// fresh returns a replica lagging behind primary by at most maxLag blocks, or primary if none does
+func (s *replicatedStore) fresh(ctx context.Context) Store {
+	h, err := s.primary.get(ctx)
+	if err != nil {
+		return s.Store
+	}
+	if h < s.maxLag {
+		return s.at(ctx, 0)
+	}
+	return s.at(ctx, h-s.maxLag)
+}
+
+// orPrimary reads from primary if store is a replica, which has not replicated the record
+func (s *replicatedStore) orPrimary(store Store, err error) bool {
+	return store != s.Store && errors.Is(err, ErrNotFound)
+}
+
+func (s *replicatedStore) GetBlockByNumber(ctx context.Context, n uint64) (*eth.Block, error) {
+	r := s.at(ctx, n)
+	block, err := r.GetBlockByNumber(ctx, n)
+	if s.orPrimary(r, err) {
+		return s.Store.GetBlockByNumber(ctx, n)
+	}
+	return block, err
+}
+
+func (s *replicatedStore) GetBlockByHash(ctx context.Context, h string) (*eth.Block, error) {
+	r := s.fresh(ctx)
+	block, err := r.GetBlockByHash(ctx, h)
+	if s.orPrimary(r, err) {
+		return s.Store.GetBlockByHash(ctx, h)
+	}
+	return block, err
+}
+
+func (s *replicatedStore) ListBlocks(ctx context.Context, limit int) ([]*eth.Block, error) {
+	return s.fresh(ctx).ListBlocks(ctx, limit)
+}
+
+func (s *replicatedStore) GetTransaction(ctx context.Context, h string) (*eth.Transaction, error) {
+	r := s.fresh(ctx)
+	tx, err := r.GetTransaction(ctx, h)
+	if s.orPrimary(r, err) {
+		return s.Store.GetTransaction(ctx, h)
+	}
+	return tx, err
+}
+
+func (s *replicatedStore) ListTransactions(ctx context.Context, query TransactionQuery) ([]*eth.Transaction, error) {
+	return s.fresh(ctx).ListTransactions(ctx, query)
+}
+
+func (s *replicatedStore) EarliestNum(ctx context.Context) (uint64, error) {
+	return s.fresh(ctx).EarliestNum(ctx)
+}
+
+func (s *replicatedStore) GetToken(ctx context.Context, address string) (*eth.Token, error) {
+	r := s.fresh(ctx)
+	token, err := r.GetToken(ctx, address)
+	if s.orPrimary(r, err) {
+		return s.Store.GetToken(ctx, address)
+	}
+	return token, err
+}
+
+func (s *replicatedStore) GetBalance(ctx context.Context, address string, blockNum uint64) (*eth.Balance, error) {
+	// a balance at a past block requires the replica to have the block,
+	// while the latest balance tolerates the lag like other recent data
+	r := s.fresh(ctx)
+	if h, err := s.primary.get(ctx); err == nil && blockNum < h {
+		r = s.at(ctx, blockNum)
+	}
+	balance, err := r.GetBalance(ctx, address, blockNum)
+	if s.orPrimary(r, err) {
+		return s.Store.GetBalance(ctx, address, blockNum)
+	}
+	return balance, err
+}
+
+func (s *replicatedStore) ListInternalTransactionsByHash(ctx context.Context, h string) ([]*eth.InternalTransaction, error) {
+	return s.fresh(ctx).ListInternalTransactionsByHash(ctx, h)
+}
+
+func (s *replicatedStore) ListInternalTransactionsByAddress(ctx context.Context, address string, limit int) ([]*eth.InternalTransaction, error) {
+	return s.fresh(ctx).ListInternalTransactionsByAddress(ctx, address, limit)
+}
+
+func (s *replicatedStore) GetContract(ctx context.Context, address string) (*eth.Contract, error) {
+	r := s.fresh(ctx)
+	contract, err := r.GetContract(ctx, address)
+	if s.orPrimary(r, err) {
+		return s.Store.GetContract(ctx, address)
+	}
+	return contract, err
+}
+
+func (s *replicatedStore) GetContractActivity(ctx context.Context, address string) (*ContractActivity, error) {
+	return s.fresh(ctx).GetContractActivity(ctx, address)
+}
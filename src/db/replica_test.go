@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/r04922101/portto/eth"
+)
+
+// failingStore fails to report its latest block
+type failingStore struct {
+	Store
+}
+
+func (failingStore) LatestNum(ctx context.Context) (uint64, error) {
+	return 0, errors.New("connection refused")
+}
+
+// newForkStore creates a memory store with blocks numbered from 1 to n of fork, which tells stores apart
+func newForkStore(t *testing.T, fork string, n uint64) Store {
+	t.Helper()
+	s := NewMemoryStore(testChainID)
+	for i := uint64(1); i <= n; i++ {
+		if err := s.WriteBlock(context.Background(), testBlock(i, fork)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// forkOf returns the fork of the block numbered n with hash h among forks
+func forkOf(n uint64, h string, forks ...string) string {
+	for _, f := range forks {
+		if testHash("block", n, f) == h {
+			return f
+		}
+	}
+	return "unknown"
+}
+
+func TestReplicatedStoreRouting(t *testing.T) {
+	primary := newForkStore(t, "primary", 10)
+	if s := NewReplicatedStore(primary, nil, 2); s != primary {
+		t.Error("store without replicas is not the primary")
+	}
+	// a lags behind by 1 block and b by 5
+	s := NewReplicatedStore(primary, []Store{newForkStore(t, "a", 9), newForkStore(t, "b", 5)}, 2)
+	ctx := context.Background()
+
+	tests := []struct {
+		n     uint64
+		forks map[string]bool
+	}{
+		{3, map[string]bool{"a": true, "b": true}},
+		{7, map[string]bool{"a": true}},
+		{10, map[string]bool{"primary": true}},
+	}
+	for _, tt := range tests {
+		got := map[string]bool{}
+		for i := 0; i < 4; i++ {
+			b, err := s.GetBlockByNumber(ctx, tt.n)
+			if err != nil {
+				t.Fatalf("failed to get block %d: %v", tt.n, err)
+			}
+			got[forkOf(tt.n, b.Hash, "primary", "a", "b")] = true
+		}
+		if len(got) != len(tt.forks) {
+			t.Errorf("block %d is read from %v, want %v", tt.n, got, tt.forks)
+		}
+		for f := range got {
+			if !tt.forks[f] {
+				t.Errorf("block %d is read from %v, want %v", tt.n, got, tt.forks)
+			}
+		}
+	}
+
+	// recent data is read from replicas lagging by at most 2 blocks
+	for i := 0; i < 4; i++ {
+		blocks, err := s.ListBlocks(ctx, 1)
+		if err != nil || len(blocks) != 1 || blocks[0].Num != 9 {
+			t.Fatalf("recent blocks = %v, %v", blocks, err)
+		}
+	}
+
+	// records missing in a replica are read from the primary
+	if b, err := s.GetBlockByHash(ctx, testHash("block", 10, "primary")); err != nil || b.Num != 10 {
+		t.Errorf("block not replicated = %+v, %v", b, err)
+	}
+	if _, err := s.GetTransaction(ctx, testHash("tx", 1, "primary")); err != nil {
+		t.Errorf("failed to get transaction not replicated: %v", err)
+	}
+	if _, err := s.GetTransaction(ctx, testHash("tx", 1, "other")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing transaction error = %v", err)
+	}
+}
+
+func TestReplicatedStoreFailingReplica(t *testing.T) {
+	primary := newForkStore(t, "primary", 10)
+	s := NewReplicatedStore(primary, []Store{failingStore{newForkStore(t, "a", 10)}}, 0)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		b, err := s.GetBlockByNumber(ctx, 5)
+		if err != nil || forkOf(5, b.Hash, "primary", "a") != "primary" {
+			t.Errorf("block of failing replica = %+v, %v", b, err)
+		}
+	}
+}
+
+func TestReplicatedStoreWrites(t *testing.T) {
+	primary, replica := NewMemoryStore(testChainID), NewMemoryStore(testChainID)
+	s := NewReplicatedStore(primary, []Store{replica}, 0)
+	ctx := context.Background()
+	if err := s.WriteBlock(ctx, testBlock(1, "")); err != nil {
+		t.Fatalf("failed to write block: %v", err)
+	}
+	if _, err := primary.GetBlockByNumber(ctx, 1); err != nil {
+		t.Errorf("block is not written to the primary: %v", err)
+	}
+	if _, err := replica.GetBlockByNumber(ctx, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("block is written to the replica: %v", err)
+	}
+	if err := s.SaveBalances(ctx, []eth.Balance{{Address: "0x1111111111111111111111111111111111111111", BlockNum: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	// the balance at the latest block tolerates the lag, while the replica has no block yet
+	if _, err := s.GetBalance(ctx, "0x1111111111111111111111111111111111111111", 1); err != nil {
+		t.Errorf("failed to get balance written to the primary: %v", err)
+	}
+}
+
+// slowStore reports its latest block once release is closed, counting the lookups
+type slowStore struct {
+	Store
+	release chan struct{}
+	lookups int32
+}
+
+func (s *slowStore) LatestNum(ctx context.Context) (uint64, error) {
+	atomic.AddInt32(&s.lookups, 1)
+	<-s.release
+	return s.Store.LatestNum(ctx)
+}
+
+func TestHeadRefresh(t *testing.T) {
+	slow := &slowStore{Store: newForkStore(t, "a", 3), release: make(chan struct{})}
+	h := &head{store: slow}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if n, err := h.get(context.Background()); err != nil || n != 3 {
+				t.Errorf("head = %d, %v, want 3", n, err)
+			}
+		}()
+	}
+	// a read giving up on the slow lookup does not wait for it
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := h.get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the deadline exceeded", err)
+	}
+	close(slow.release)
+	wg.Wait()
+	if slow.lookups != 1 {
+		t.Errorf("# of lookups = %d, want 1 shared by concurrent reads", slow.lookups)
+	}
+	if n, err := h.get(context.Background()); err != nil || n != 3 || slow.lookups != 1 {
+		t.Errorf("cached head = %d, %v after %d lookups, want 3 without another lookup", n, err, slow.lookups)
+	}
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/r04922101/portto/eth"
+	"gorm.io/gorm"
+)
+
+// DefaultChunkSize is the # of blocks deleted in a DB transaction by Prune given no positive chunk size
+const DefaultChunkSize = 1000
+
+func (s *sqlStore) EarliestNum(ctx context.Context) (uint64, error) {
+	var n uint64
+	if err := s.chain(ctx).Model(&eth.Block{}).Select("COALESCE(MIN(num), 0)").Scan(&n).Error; err != nil {
+		return 0, fmt.Errorf("failed to get earliest block from DB: %v", err)
+	}
+	return n, nil
+}
+
+func (s *sqlStore) FirstNumSince(ctx context.Context, t uint64) (uint64, error) {
+	var block eth.Block
+	if err := first(s.chain(ctx).Order("num"), &block, "time >= ?", t); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return 0, err
+		}
+		return 0, fmt.Errorf("failed to find first block since %d in DB: %v", t, err)
+	}
+	return block.Num, nil
+}
+
+// pruneRange deletes blocks of a chain in range with their transactions, logs and internal transactions within tx
+func pruneRange(tx *gorm.DB, chainID, from, to uint64) error {
+	for _, model := range []interface{}{&eth.Log{}, &eth.Transaction{}, &eth.InternalTransaction{}} {
+		if err := tx.Where("chain_id = ? AND block_num BETWEEN ? AND ?", chainID, from, to).Delete(model).Error; err != nil {
+			return fmt.Errorf("failed to delete %T: %v", model, err)
+		}
+	}
+	if err := tx.Where("chain_id = ? AND num BETWEEN ? AND ?", chainID, from, to).Delete(&eth.Block{}).Error; err != nil {
+		return fmt.Errorf("failed to delete blocks: %v", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) Prune(ctx context.Context, before uint64, chunkSize int) error {
+	defer observeWrite(s.chainID, "prune", time.Now())
+
+	if s.db.Dialector.Name() == DriverMySQL {
+		if err := s.dropPartitions(ctx, before); err != nil {
+			return err
+		}
+	}
+
+	earliest, err := s.EarliestNum(ctx)
+	if err != nil {
+		return err
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	// deleting in chunks keeps transactions and locks short
+	for from := earliest; from < before; from += uint64(chunkSize) {
+		to := from + uint64(chunkSize) - 1
+		if to >= before {
+			to = before - 1
+		}
+		if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return pruneRange(tx, s.chainID, from, to)
+		}); err != nil {
+			return fmt.Errorf("failed to prune blocks %d-%d from DB: %v", from, to, err)
+		}
+		s.log.Info("pruned blocks", "from", from, "to", to)
+	}
+	return nil
+}
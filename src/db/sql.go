@@ -0,0 +1,416 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/r04922101/portto/eth"
+	"github.com/r04922101/portto/logging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type sqlStore struct {
+	db      *gorm.DB
+	chainID uint64
+	log     logging.Logger
+}
+
+// NewSQLStore creates a store backed by a SQL DB, reading and writing data of the chain with chainID,
+// so that chains indexed side by side share the DB
+func NewSQLStore(gdb *gorm.DB, chainID uint64) Store {
+	return &sqlStore{db: gdb, chainID: chainID, log: logging.New("chain", chainID)}
+}
+
+// chain starts a query on rows of the chain of the store
+func (s *sqlStore) chain(ctx context.Context) *gorm.DB {
+	return s.db.WithContext(ctx).Where("chain_id = ?", s.chainID)
+}
+
+// preload preloads an association of rows of the chain of the store, which is keyed by chain IDs as well;
+// associations have single column foreign keys, since sqlite supports no IN conditions on multiple columns
+func (s *sqlStore) preload(q *gorm.DB, association string) *gorm.DB {
+	return q.Preload(association, "chain_id = ?", s.chainID)
+}
+
+// first finds the first record matching conds, returning ErrNotFound if there is none
+func first(query *gorm.DB, dest interface{}, conds ...interface{}) error {
+	ret := query.Limit(1).Find(dest, conds...)
+	if ret.Error != nil {
+		return ret.Error
+	} else if ret.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) ChainID() uint64 {
+	return s.chainID
+}
+
+func (s *sqlStore) CheckSchema(ctx context.Context) error {
+	return CheckSchema(s.db.WithContext(ctx))
+}
+
+func (s *sqlStore) GetBlockByNumber(ctx context.Context, n uint64) (*eth.Block, error) {
+	var block eth.Block
+	if err := first(s.preload(s.chain(ctx), "Transactions"), &block, "num = ?", n); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to find block %d in DB: %v", n, err)
+	}
+	return &block, nil
+}
+
+func (s *sqlStore) GetBlockByHash(ctx context.Context, h string) (*eth.Block, error) {
+	var block eth.Block
+	if err := first(s.preload(s.chain(ctx), "Transactions"), &block, "hash = ?", h); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to find block with hash %s in DB: %v", h, err)
+	}
+	return &block, nil
+}
+
+func (s *sqlStore) ListBlocks(ctx context.Context, limit int) ([]*eth.Block, error) {
+	var blocks []*eth.Block
+	if err := s.preload(s.chain(ctx), "Transactions").
+		Order("num desc").Limit(limit).
+		Find(&blocks).Error; err != nil {
+		return nil, fmt.Errorf("failed to find recent %d blocks in DB: %v", limit, err)
+	}
+	return blocks, nil
+}
+
+func (s *sqlStore) ListBlockHashes(ctx context.Context, from, to uint64, withTxs bool) ([]BlockHashes, error) {
+	var blocks []struct {
+		Num  uint64
+		Hash string
+	}
+	if err := s.chain(ctx).Model(&eth.Block{}).Select("num, hash").Where("num BETWEEN ? AND ?", from, to).
+		Order("num").Scan(&blocks).Error; err != nil {
+		return nil, fmt.Errorf("failed to find hashes of blocks %d-%d in DB: %v", from, to, err)
+	}
+	ret := make([]BlockHashes, len(blocks))
+	byNum := make(map[uint64]*BlockHashes, len(blocks))
+	for i, b := range blocks {
+		ret[i] = BlockHashes{Num: b.Num, Hash: b.Hash}
+		byNum[b.Num] = &ret[i]
+	}
+	if !withTxs || len(ret) == 0 {
+		return ret, nil
+	}
+
+	var txs []struct {
+		BlockNum uint64
+		Hash     string
+	}
+	if err := s.chain(ctx).Model(&eth.Transaction{}).Select("block_num, hash").Where("block_num BETWEEN ? AND ?", from, to).
+		Scan(&txs).Error; err != nil {
+		return nil, fmt.Errorf("failed to find hashes of transactions of blocks %d-%d in DB: %v", from, to, err)
+	}
+	for _, t := range txs {
+		if b, ok := byNum[t.BlockNum]; ok {
+			b.Transactions = append(b.Transactions, t.Hash)
+		}
+	}
+	return ret, nil
+}
+
+func (s *sqlStore) GetTransaction(ctx context.Context, h string) (*eth.Transaction, error) {
+	var tx eth.Transaction
+	if err := first(s.preload(s.chain(ctx), "Logs"), &tx, "hash = ?", h); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to find transaction with hash %s in DB: %v", h, err)
+	}
+	return &tx, nil
+}
+
+func (s *sqlStore) ListTransactions(ctx context.Context, query TransactionQuery) ([]*eth.Transaction, error) {
+	q := s.chain(ctx)
+	if query.Address != "" {
+		// struct conditions quote the reserved `from` and `to` columns
+		q = q.Where(s.db.Where(&eth.Transaction{From: query.Address}).Or(&eth.Transaction{To: query.Address}))
+	}
+	if query.MinValue != nil {
+		q = s.compareValue(q, ">", query.MinValue)
+	}
+	if query.MaxValue != nil {
+		q = s.compareValue(q, "<", query.MaxValue)
+	}
+	columns := []clause.Column{{Name: "block_num"}, {Name: "hash"}}
+	if query.SortByValue {
+		values := []clause.Column{{Name: "value"}}
+		if s.db.Dialector.Name() == DriverSQLite {
+			values = []clause.Column{{Name: "LENGTH(value)", Raw: true}, {Name: "value"}}
+		}
+		columns = append(values, columns...)
+	}
+	for _, c := range columns {
+		q = q.Order(clause.OrderByColumn{Column: c, Desc: !query.Ascending})
+	}
+
+	var txs []*eth.Transaction
+	if err := q.Limit(query.Limit).Find(&txs).Error; err != nil {
+		return nil, fmt.Errorf("failed to find transactions in DB: %v", err)
+	}
+	return txs, nil
+}
+
+// compareValue filters q by values of transactions compared with v by op, > or <, inclusively
+func (s *sqlStore) compareValue(q *gorm.DB, op string, v *big.Int) *gorm.DB {
+	if s.db.Dialector.Name() == DriverSQLite {
+		// values are text without leading zeros, which compare as numbers by their lengths first
+		return q.Where(fmt.Sprintf("(LENGTH(value) %s ? OR (LENGTH(value) = ? AND value %s= ?))", op, op),
+			len(v.String()), len(v.String()), v.String())
+	}
+	// compare as decimals, since mysql compares decimals with strings as floating points
+	return q.Where("value "+op+"= CAST(? AS DECIMAL(78,0))", v.String())
+}
+
+// deleteRange deletes blocks of a chain in range and data derived from them within tx
+func deleteRange(tx *gorm.DB, chainID, from, to uint64) error {
+	for _, model := range []interface{}{&eth.Log{}, &eth.Transaction{}, &eth.InternalTransaction{}, &eth.Balance{}, &eth.Contract{}} {
+		if err := tx.Where("chain_id = ? AND block_num BETWEEN ? AND ?", chainID, from, to).Delete(model).Error; err != nil {
+			return fmt.Errorf("failed to delete %T: %v", model, err)
+		}
+	}
+	if err := tx.Where("chain_id = ? AND num BETWEEN ? AND ?", chainID, from, to).Delete(&eth.Block{}).Error; err != nil {
+		return fmt.Errorf("failed to delete blocks: %v", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) WriteBlock(ctx context.Context, block *eth.Block) error {
+	defer observeWrite(s.chainID, "write_block", time.Now())
+
+	// gorm fills foreign keys of associations, but neither chain IDs nor block numbers of logs
+	block.ChainID = s.chainID
+	for i := range block.Transactions {
+		t := &block.Transactions[i]
+		t.ChainID = s.chainID
+		for j := range t.Logs {
+			t.Logs[j].ChainID = s.chainID
+			t.Logs[j].BlockNum = block.Num
+		}
+	}
+	// hashes of the replaced block, which differ from the new one in a reorg
+	var old []string
+	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&eth.Block{}).Where("chain_id = ? AND num = ?", s.chainID, block.Num).Pluck("hash", &old).Error; err != nil {
+			return err
+		}
+		if err := deleteRange(tx, s.chainID, block.Num, block.Num); err != nil {
+			return err
+		}
+		return tx.Create(block).Error
+	}); err != nil {
+		return fmt.Errorf("failed to write block %d to DB: %v", block.Num, err)
+	}
+	for _, h := range old {
+		observeReorg(s.chainID, block.Num, h, block.Hash)
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteRange(ctx context.Context, from, to uint64) error {
+	defer observeWrite(s.chainID, "delete_range", time.Now())
+
+	if err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return deleteRange(tx, s.chainID, from, to)
+	}); err != nil {
+		return fmt.Errorf("failed to delete blocks %d-%d from DB: %v", from, to, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) LatestNum(ctx context.Context) (uint64, error) {
+	var n uint64
+	if err := s.chain(ctx).Model(&eth.Block{}).Select("COALESCE(MAX(num), 0)").Scan(&n).Error; err != nil {
+		return 0, fmt.Errorf("failed to get latest block from DB: %v", err)
+	}
+	return n, nil
+}
+
+func (s *sqlStore) ContiguousHead(ctx context.Context) (uint64, error) {
+	// the first block without a successor ends the contiguous range from the earliest block
+	var n uint64
+	if err := s.db.WithContext(ctx).Table("blocks AS b").Select("COALESCE(MIN(b.num), 0)").
+		Where("b.chain_id = ? AND NOT EXISTS (SELECT 1 FROM blocks AS n WHERE n.chain_id = b.chain_id AND n.num = b.num + 1)", s.chainID).
+		Scan(&n).Error; err != nil {
+		return 0, fmt.Errorf("failed to get contiguous head from DB: %v", err)
+	}
+	return n, nil
+}
+
+func (s *sqlStore) GetToken(ctx context.Context, address string) (*eth.Token, error) {
+	var token eth.Token
+	if err := first(s.chain(ctx), &token, "address = ?", address); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to find token %s in DB: %v", address, err)
+	}
+	return &token, nil
+}
+
+func (s *sqlStore) SaveToken(ctx context.Context, token *eth.Token) error {
+	defer observeWrite(s.chainID, "save_token", time.Now())
+
+	token.ChainID = s.chainID
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(token).Error; err != nil {
+		return fmt.Errorf("failed to insert token %s to DB: %v", token.Address, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) ListABIs(ctx context.Context) ([]*eth.ContractABI, error) {
+	var abis []*eth.ContractABI
+	// the table is not created yet before the schema is checked
+	if !s.db.Migrator().HasTable(&eth.ContractABI{}) {
+		return abis, nil
+	}
+	if err := s.chain(ctx).Find(&abis).Error; err != nil {
+		return nil, fmt.Errorf("failed to find ABIs in DB: %v", err)
+	}
+	return abis, nil
+}
+
+func (s *sqlStore) SaveABI(ctx context.Context, abi *eth.ContractABI) error {
+	defer observeWrite(s.chainID, "save_abi", time.Now())
+
+	abi.ChainID = s.chainID
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(abi).Error; err != nil {
+		return fmt.Errorf("failed to insert ABI of %s to DB: %v", abi.Address, err)
+	}
+	return nil
+}
+
+func (s *sqlStore) GetBalance(ctx context.Context, address string, blockNum uint64) (*eth.Balance, error) {
+	var balance eth.Balance
+	if err := first(s.chain(ctx).Order("block_num desc"), &balance,
+		"address = ? AND block_num <= ?", address, blockNum); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to find balance of %s in DB: %v", address, err)
+	}
+	return &balance, nil
+}
+
+func (s *sqlStore) SaveBalances(ctx context.Context, balances []eth.Balance) error {
+	defer observeWrite(s.chainID, "save_balances", time.Now())
+
+	if len(balances) == 0 {
+		return nil
+	}
+	for i := range balances {
+		balances[i].ChainID = s.chainID
+	}
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&balances).Error; err != nil {
+		return fmt.Errorf("failed to insert balances to DB: %v", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) ListInternalTransactionsByHash(ctx context.Context, h string) ([]*eth.InternalTransaction, error) {
+	var internals []*eth.InternalTransaction
+	if err := s.chain(ctx).Where("transaction_hash = ?", h).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "index"}}).
+		Find(&internals).Error; err != nil {
+		return nil, fmt.Errorf("failed to find internal transactions of %s in DB: %v", h, err)
+	}
+	return internals, nil
+}
+
+func (s *sqlStore) ListInternalTransactionsByAddress(ctx context.Context, address string, limit int) ([]*eth.InternalTransaction, error) {
+	var internals []*eth.InternalTransaction
+	// struct conditions quote the reserved `from` and `to` columns
+	if err := s.chain(ctx).
+		Where(s.db.Where(&eth.InternalTransaction{From: address}).Or(&eth.InternalTransaction{To: address})).
+		Order("block_num desc").Limit(limit).
+		Find(&internals).Error; err != nil {
+		return nil, fmt.Errorf("failed to find internal transactions of %s in DB: %v", address, err)
+	}
+	return internals, nil
+}
+
+func (s *sqlStore) ListInternalCreations(ctx context.Context, blockNum uint64) ([]*eth.InternalTransaction, error) {
+	var internals []*eth.InternalTransaction
+	if err := s.chain(ctx).
+		Where("block_num = ? AND type IN ? AND error = ?", blockNum, []string{"CREATE", "CREATE2"}, "").
+		Find(&internals).Error; err != nil {
+		return nil, fmt.Errorf("failed to find internal creations of block %d in DB: %v", blockNum, err)
+	}
+	return internals, nil
+}
+
+func (s *sqlStore) SaveInternalTransactions(ctx context.Context, internals []eth.InternalTransaction) error {
+	defer observeWrite(s.chainID, "save_internal_transactions", time.Now())
+
+	if len(internals) == 0 {
+		return nil
+	}
+	for i := range internals {
+		internals[i].ChainID = s.chainID
+	}
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).
+		CreateInBatches(&internals, 500).Error; err != nil {
+		return fmt.Errorf("failed to insert internal transactions to DB: %v", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) GetContract(ctx context.Context, address string) (*eth.Contract, error) {
+	var contract eth.Contract
+	if err := first(s.chain(ctx), &contract, "address = ?", address); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to find contract %s in DB: %v", address, err)
+	}
+	return &contract, nil
+}
+
+func (s *sqlStore) GetContractActivity(ctx context.Context, address string) (*ContractActivity, error) {
+	var activity ContractActivity
+	if err := s.chain(ctx).Model(&eth.Transaction{}).Where(&eth.Transaction{To: address}).
+		Count(&activity.Transactions).Error; err != nil {
+		return nil, fmt.Errorf("failed to count transactions of contract %s in DB: %v", address, err)
+	}
+	if err := s.chain(ctx).Model(&eth.InternalTransaction{}).Where(&eth.InternalTransaction{To: address}).
+		Count(&activity.InternalTransactions).Error; err != nil {
+		return nil, fmt.Errorf("failed to count internal transactions of contract %s in DB: %v", address, err)
+	}
+	if err := s.chain(ctx).Model(&eth.Log{}).Where("address = ?", address).
+		Count(&activity.Logs).Error; err != nil {
+		return nil, fmt.Errorf("failed to count logs of contract %s in DB: %v", address, err)
+	}
+	if err := s.chain(ctx).Model(&eth.Transaction{}).Where(&eth.Transaction{To: address}).
+		Select("COALESCE(MAX(block_num), 0)").Scan(&activity.LastBlockNum).Error; err != nil {
+		return nil, fmt.Errorf("failed to find last transaction of contract %s in DB: %v", address, err)
+	}
+	return &activity, nil
+}
+
+func (s *sqlStore) SaveContracts(ctx context.Context, contracts []*eth.Contract) error {
+	defer observeWrite(s.chainID, "save_contracts", time.Now())
+
+	if len(contracts) == 0 {
+		return nil
+	}
+	for _, c := range contracts {
+		c.ChainID = s.chainID
+	}
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&contracts).Error; err != nil {
+		return fmt.Errorf("failed to insert contracts to DB: %v", err)
+	}
+	return nil
+}
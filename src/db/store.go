@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/r04922101/portto/eth"
+)
+
+// ErrNotFound is returned when a requested record is not in the store
+var ErrNotFound = errors.New("record not found")
+
+// ContractActivity defines a summary of transactions and logs of a contract
+type ContractActivity struct {
+	Transactions         int64  `json:"transactions"`
+	InternalTransactions int64  `json:"internal_transactions"`
+	Logs                 int64  `json:"logs"`
+	LastBlockNum         uint64 `json:"last_block_num"`
+}
+
+// TransactionQuery defines filters and order of listed transactions
+type TransactionQuery struct {
+	// Address filters transactions from or to it if not empty
+	Address string
+	// MinValue and MaxValue filter transactions of values in the range inclusively if not nil
+	MinValue *big.Int
+	MaxValue *big.Int
+	// SortByValue sorts transactions by value instead of block number
+	SortByValue bool
+	Ascending   bool
+	Limit       int
+}
+
+// BlockHashes defines the hash of a block and the hashes of its transactions
+type BlockHashes struct {
+	Num          uint64
+	Hash         string
+	Transactions []string
+}
+
+// Store defines an interface reading and writing indexed data
+type Store interface {
+	// ChainID returns the ID of the chain, whose data the store reads and writes
+	ChainID() uint64
+	// CheckSchema checks the store is ready to serve the expected schema
+	CheckSchema(ctx context.Context) error
+
+	// GetBlockByNumber returns a block with its transactions
+	GetBlockByNumber(ctx context.Context, n uint64) (*eth.Block, error)
+	// GetBlockByHash returns a block with its transactions
+	GetBlockByHash(ctx context.Context, h string) (*eth.Block, error)
+	// ListBlocks returns the most recent blocks with their transactions, newest first
+	ListBlocks(ctx context.Context, limit int) ([]*eth.Block, error)
+	// ListBlockHashes returns hashes of blocks numbered from `from` to `to` inclusively, oldest first,
+	// with hashes of their transactions if withTxs is set
+	ListBlockHashes(ctx context.Context, from, to uint64, withTxs bool) ([]BlockHashes, error)
+	// GetTransaction returns a transaction with its logs
+	GetTransaction(ctx context.Context, h string) (*eth.Transaction, error)
+	// ListTransactions returns transactions without logs matching query
+	ListTransactions(ctx context.Context, query TransactionQuery) ([]*eth.Transaction, error)
+	// WriteBlock atomically writes a block with its transactions and logs,
+	// replacing any block of the same number along with data derived from it
+	WriteBlock(ctx context.Context, block *eth.Block) error
+	// WriteBlocks writes blocks with their transactions and logs in multi-row inserts of up to batchSize rows,
+	// DefaultBatchSize if it is not positive,
+	// overwriting existing rows; unlike WriteBlock it does not delete data derived from replaced blocks,
+	// so it suits backfilling blocks not indexed yet
+	WriteBlocks(ctx context.Context, blocks []*eth.Block, batchSize int) error
+	// DropSecondaryIndexes drops indexes of blocks, transactions and logs, which are not primary keys,
+	// to speed up bulk inserts
+	DropSecondaryIndexes(ctx context.Context) error
+	// CreateSecondaryIndexes creates the indexes dropped by DropSecondaryIndexes if they are missing
+	CreateSecondaryIndexes(ctx context.Context) error
+	// DeleteRange deletes blocks numbered from `from` to `to` inclusively, along with data derived from them
+	DeleteRange(ctx context.Context, from, to uint64) error
+	// LatestNum returns the largest block number, 0 if there is no block
+	LatestNum(ctx context.Context) (uint64, error)
+	// EarliestNum returns the smallest block number, 0 if there is no block
+	EarliestNum(ctx context.Context) (uint64, error)
+	// FirstNumSince returns the smallest number of blocks mined at or after t in unix seconds,
+	// ErrNotFound if there is none
+	FirstNumSince(ctx context.Context, t uint64) (uint64, error)
+	// Prune deletes blocks numbered below `before` with their transactions, logs and internal transactions
+	// in chunks of chunkSize blocks, DefaultChunkSize if it is not positive, keeping balances and contracts, which remain valid states
+	Prune(ctx context.Context, before uint64, chunkSize int) error
+	// Partition range partitions transactions and logs by block number in partitions of size blocks,
+	// adding partitions ahead of the latest block if they are partitioned already
+	Partition(ctx context.Context, size uint64) error
+	// ContiguousHead returns the largest block number, up to which there is no gap
+	// since the earliest block, 0 if there is no block
+	ContiguousHead(ctx context.Context) (uint64, error)
+
+	GetToken(ctx context.Context, address string) (*eth.Token, error)
+	// SaveToken writes a token unless it exists
+	SaveToken(ctx context.Context, token *eth.Token) error
+
+	ListABIs(ctx context.Context) ([]*eth.ContractABI, error)
+	SaveABI(ctx context.Context, abi *eth.ContractABI) error
+
+	// GetBalance returns the latest balance of an address recorded at or before blockNum
+	GetBalance(ctx context.Context, address string, blockNum uint64) (*eth.Balance, error)
+	SaveBalances(ctx context.Context, balances []eth.Balance) error
+
+	ListInternalTransactionsByHash(ctx context.Context, h string) ([]*eth.InternalTransaction, error)
+	// ListInternalTransactionsByAddress returns the most recent internal transactions from or to an address
+	ListInternalTransactionsByAddress(ctx context.Context, address string, limit int) ([]*eth.InternalTransaction, error)
+	// ListInternalCreations returns successful internal transactions creating contracts in a block
+	ListInternalCreations(ctx context.Context, blockNum uint64) ([]*eth.InternalTransaction, error)
+	SaveInternalTransactions(ctx context.Context, internals []eth.InternalTransaction) error
+
+	GetContract(ctx context.Context, address string) (*eth.Contract, error)
+	GetContractActivity(ctx context.Context, address string) (*ContractActivity, error)
+	SaveContracts(ctx context.Context, contracts []*eth.Contract) error
+}
@@ -0,0 +1,366 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/r04922101/portto/eth"
+)
+
+const testChainID = 97
+
+// testHash returns a distinct hash in hex of a block or transaction numbered n of fork
+func testHash(kind string, n uint64, fork string) string {
+	return crypto.Keccak256Hash([]byte(fmt.Sprintf("%s/%d/%s", kind, n, fork))).Hex()
+}
+
+// testBlock creates the block numbered n of fork with a transaction, which has a log
+func testBlock(n uint64, fork string) *eth.Block {
+	tx := testHash("tx", n, fork)
+	return &eth.Block{
+		Num:        n,
+		Hash:       testHash("block", n, fork),
+		ParentHash: testHash("block", n-1, fork),
+		Time:       1700000000 + 3*n,
+		Transactions: []eth.Transaction{{
+			Hash:  tx,
+			From:  "0x1111111111111111111111111111111111111111",
+			To:    "0x2222222222222222222222222222222222222222",
+			Value: eth.NewWei(new(big.Int).SetUint64(n)),
+			Logs:  []eth.Log{{Address: "0x3333333333333333333333333333333333333333", Topics: eth.Topics{tx}}},
+		}},
+	}
+}
+
+// testStores runs test against each implementation of Store
+func testStores(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore(testChainID))
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		gdb := newSQLiteDB(t)
+		migrate(t, gdb, LatestVersion())
+		test(t, NewSQLStore(gdb, testChainID))
+	})
+}
+
+// writeBlocks writes blocks numbered from `from` to `to` one by one
+func writeBlocks(t *testing.T, s Store, from, to uint64) {
+	t.Helper()
+	for n := from; n <= to; n++ {
+		if err := s.WriteBlock(context.Background(), testBlock(n, "")); err != nil {
+			t.Fatalf("failed to write block %d: %v", n, err)
+		}
+	}
+}
+
+// blockNums returns the numbers of all blocks in s, newest first
+func blockNums(t *testing.T, s Store) []uint64 {
+	t.Helper()
+	blocks, err := s.ListBlocks(context.Background(), 100)
+	if err != nil {
+		t.Fatalf("failed to list blocks: %v", err)
+	}
+	nums := make([]uint64, len(blocks))
+	for i, b := range blocks {
+		nums[i] = b.Num
+	}
+	return nums
+}
+
+func TestStoreNotFound(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		if _, err := s.GetBlockByNumber(ctx, 1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetBlockByNumber error = %v", err)
+		}
+		if _, err := s.GetBlockByHash(ctx, testHash("block", 1, "")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetBlockByHash error = %v", err)
+		}
+		if _, err := s.GetTransaction(ctx, testHash("tx", 1, "")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetTransaction error = %v", err)
+		}
+		if _, err := s.GetToken(ctx, "0x3333333333333333333333333333333333333333"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetToken error = %v", err)
+		}
+		if _, err := s.GetContract(ctx, "0x3333333333333333333333333333333333333333"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetContract error = %v", err)
+		}
+		if _, err := s.FirstNumSince(ctx, 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("FirstNumSince error = %v", err)
+		}
+		for name, f := range map[string]func(context.Context) (uint64, error){
+			"LatestNum": s.LatestNum, "EarliestNum": s.EarliestNum, "ContiguousHead": s.ContiguousHead,
+		} {
+			if n, err := f(ctx); err != nil || n != 0 {
+				t.Errorf("%s of empty store = %d, %v", name, n, err)
+			}
+		}
+	})
+}
+
+func TestStoreWriteBlock(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		writeBlocks(t, s, 1, 3)
+
+		b, err := s.GetBlockByHash(ctx, testHash("block", 2, ""))
+		if err != nil {
+			t.Fatalf("failed to get block: %v", err)
+		}
+		if b.Num != 2 || len(b.Transactions) != 1 || b.Transactions[0].Hash != testHash("tx", 2, "") {
+			t.Errorf("block = %+v", b)
+		}
+		tx, err := s.GetTransaction(ctx, testHash("tx", 2, ""))
+		if err != nil {
+			t.Fatalf("failed to get transaction: %v", err)
+		}
+		if tx.BlockNum != 2 || tx.Value.String() != "2" || len(tx.Logs) != 1 || tx.Logs[0].BlockNum != 2 {
+			t.Errorf("transaction = %+v", tx)
+		}
+
+		// a reorganized block replaces the old one along with its transactions and derived data
+		if err := s.SaveBalances(ctx, []eth.Balance{{Address: tx.From, BlockNum: 2, Balance: eth.NewWei(big.NewInt(1))}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.WriteBlock(ctx, testBlock(2, "fork")); err != nil {
+			t.Fatalf("failed to write reorganized block: %v", err)
+		}
+		if b, err := s.GetBlockByNumber(ctx, 2); err != nil || b.Hash != testHash("block", 2, "fork") {
+			t.Errorf("block 2 = %+v, %v", b, err)
+		}
+		if _, err := s.GetBlockByHash(ctx, testHash("block", 2, "")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("replaced block is found: %v", err)
+		}
+		if _, err := s.GetTransaction(ctx, testHash("tx", 2, "")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("transaction of replaced block is found: %v", err)
+		}
+		if _, err := s.GetTransaction(ctx, testHash("tx", 2, "fork")); err != nil {
+			t.Errorf("transaction of new block is not found: %v", err)
+		}
+		if _, err := s.GetBalance(ctx, tx.From, 2); !errors.Is(err, ErrNotFound) {
+			t.Errorf("balance of replaced block is found: %v", err)
+		}
+		if got := blockNums(t, s); fmt.Sprint(got) != "[3 2 1]" {
+			t.Errorf("blocks = %v", got)
+		}
+	})
+}
+
+func TestStoreWriteBlocks(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		var blocks []*eth.Block
+		for n := uint64(1); n <= 5; n++ {
+			blocks = append(blocks, testBlock(n, ""))
+		}
+		if err := s.WriteBlocks(ctx, blocks, 2); err != nil {
+			t.Fatalf("failed to write blocks: %v", err)
+		}
+		// existing rows are overwritten
+		if err := s.WriteBlocks(ctx, blocks[3:], 2); err != nil {
+			t.Fatalf("failed to write blocks again: %v", err)
+		}
+		if got := blockNums(t, s); fmt.Sprint(got) != "[5 4 3 2 1]" {
+			t.Errorf("blocks = %v", got)
+		}
+		tx, err := s.GetTransaction(ctx, testHash("tx", 4, ""))
+		if err != nil {
+			t.Fatalf("failed to get transaction: %v", err)
+		}
+		if tx.BlockNum != 4 || len(tx.Logs) != 1 || tx.Logs[0].BlockNum != 4 {
+			t.Errorf("transaction = %+v", tx)
+		}
+		if err := s.WriteBlocks(ctx, nil, 2); err != nil {
+			t.Errorf("failed to write no blocks: %v", err)
+		}
+		// batches of no size are of the default size
+		if err := s.WriteBlocks(ctx, []*eth.Block{testBlock(6, "")}, 0); err != nil {
+			t.Errorf("failed to write blocks in batches of no size: %v", err)
+		}
+		if n, err := s.LatestNum(ctx); err != nil || n != 6 {
+			t.Errorf("latest block = %d, %v, want 6", n, err)
+		}
+	})
+}
+
+func TestStoreDeleteRangeAndPrune(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		writeBlocks(t, s, 1, 10)
+		if err := s.SaveBalances(ctx, []eth.Balance{{Address: "0x1111111111111111111111111111111111111111", BlockNum: 2, Balance: eth.NewWei(big.NewInt(1))}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.DeleteRange(ctx, 9, 10); err != nil {
+			t.Fatalf("failed to delete blocks: %v", err)
+		}
+		if n, err := s.LatestNum(ctx); err != nil || n != 8 {
+			t.Errorf("latest block = %d, %v", n, err)
+		}
+		if _, err := s.GetTransaction(ctx, testHash("tx", 9, "")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("transaction of deleted block is found: %v", err)
+		}
+
+		if err := s.Prune(ctx, 5, 3); err != nil {
+			t.Fatalf("failed to prune blocks: %v", err)
+		}
+		if got := blockNums(t, s); fmt.Sprint(got) != "[8 7 6 5]" {
+			t.Errorf("blocks = %v", got)
+		}
+		if _, err := s.GetTransaction(ctx, testHash("tx", 4, "")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("transaction of pruned block is found: %v", err)
+		}
+		// balances remain valid states
+		if _, err := s.GetBalance(ctx, "0x1111111111111111111111111111111111111111", 8); err != nil {
+			t.Errorf("balance is pruned: %v", err)
+		}
+		if n, err := s.EarliestNum(ctx); err != nil || n != 5 {
+			t.Errorf("earliest block = %d, %v", n, err)
+		}
+		// chunks of no size are of the default size
+		if err := s.Prune(ctx, 6, 0); err != nil {
+			t.Fatalf("failed to prune blocks in chunks of no size: %v", err)
+		}
+		if got := blockNums(t, s); fmt.Sprint(got) != "[8 7 6]" {
+			t.Errorf("blocks = %v", got)
+		}
+	})
+}
+
+func TestStoreListBlockHashes(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		writeBlocks(t, s, 0, 2)
+		writeBlocks(t, s, 4, 5)
+
+		hashes, err := s.ListBlockHashes(ctx, 1, 4, false)
+		if err != nil {
+			t.Fatalf("failed to list block hashes: %v", err)
+		}
+		want := []BlockHashes{{Num: 1, Hash: testHash("block", 1, "")}, {Num: 2, Hash: testHash("block", 2, "")}, {Num: 4, Hash: testHash("block", 4, "")}}
+		if fmt.Sprint(hashes) != fmt.Sprint(want) {
+			t.Errorf("block hashes = %v, want %v", hashes, want)
+		}
+
+		hashes, err = s.ListBlockHashes(ctx, 0, 1, true)
+		if err != nil {
+			t.Fatalf("failed to list block hashes with transactions: %v", err)
+		}
+		want = []BlockHashes{
+			{Num: 0, Hash: testHash("block", 0, ""), Transactions: []string{testHash("tx", 0, "")}},
+			{Num: 1, Hash: testHash("block", 1, ""), Transactions: []string{testHash("tx", 1, "")}},
+		}
+		if fmt.Sprint(hashes) != fmt.Sprint(want) {
+			t.Errorf("block hashes with transactions = %v, want %v", hashes, want)
+		}
+		if hashes, err := s.ListBlockHashes(ctx, 6, 10, true); err != nil || len(hashes) != 0 {
+			t.Errorf("hashes of missing blocks = %v, %v", hashes, err)
+		}
+	})
+}
+
+func TestStoreContiguousHead(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		writeBlocks(t, s, 3, 5)
+		writeBlocks(t, s, 8, 9)
+		if n, err := s.ContiguousHead(ctx); err != nil || n != 5 {
+			t.Errorf("contiguous head = %d, %v, want 5", n, err)
+		}
+		writeBlocks(t, s, 6, 7)
+		if n, err := s.ContiguousHead(ctx); err != nil || n != 9 {
+			t.Errorf("contiguous head = %d, %v, want 9", n, err)
+		}
+		if n, err := s.FirstNumSince(ctx, 1700000000+3*6); err != nil || n != 6 {
+			t.Errorf("first block since block 6 = %d, %v", n, err)
+		}
+	})
+}
+
+func TestSQLStoreChains(t *testing.T) {
+	gdb := newSQLiteDB(t)
+	migrate(t, gdb, LatestVersion())
+	ctx := context.Background()
+	bsc, mainnet := NewSQLStore(gdb, testChainID), NewSQLStore(gdb, 1)
+	writeBlocks(t, bsc, 1, 2)
+	// the same block number of another chain does not replace the block
+	b := testBlock(2, "eth")
+	if err := mainnet.WriteBlock(ctx, b); err != nil {
+		t.Fatalf("failed to write block of another chain: %v", err)
+	}
+	if b, err := bsc.GetBlockByNumber(ctx, 2); err != nil || b.Hash != testHash("block", 2, "") {
+		t.Errorf("block 2 = %+v, %v", b, err)
+	}
+	if _, err := mainnet.GetBlockByNumber(ctx, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("block of another chain is found: %v", err)
+	}
+	if n, err := mainnet.EarliestNum(ctx); err != nil || n != 2 {
+		t.Errorf("earliest block = %d, %v", n, err)
+	}
+}
+
+func TestStoreValues(t *testing.T) {
+	maxUint256 := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	values := []*big.Int{
+		maxUint256,
+		new(big.Int).Sub(maxUint256, big.NewInt(1)),
+		new(big.Int).Exp(big.NewInt(10), big.NewInt(30), nil),
+		big.NewInt(9),
+		big.NewInt(10),
+		big.NewInt(0),
+	}
+	testStores(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		block := testBlock(1, "")
+		block.Transactions = nil
+		for i, v := range values {
+			block.Transactions = append(block.Transactions, eth.Transaction{
+				Hash: testHash("tx", uint64(i), "value"), From: "0x1111111111111111111111111111111111111111", Value: eth.NewWei(v),
+			})
+		}
+		if err := s.WriteBlock(ctx, block); err != nil {
+			t.Fatalf("failed to write block: %v", err)
+		}
+
+		// values round-trip exactly
+		for i, v := range values {
+			tx, err := s.GetTransaction(ctx, testHash("tx", uint64(i), "value"))
+			if err != nil {
+				t.Fatalf("failed to get transaction: %v", err)
+			}
+			if tx.Value.Int().Cmp(v) != 0 {
+				t.Errorf("value = %s, want %s", tx.Value.String(), v)
+			}
+		}
+
+		tests := []struct {
+			query TransactionQuery
+			want  string
+		}{
+			{TransactionQuery{SortByValue: true}, fmt.Sprint([]*big.Int{values[0], values[1], values[2], values[4], values[3], values[5]})},
+			{TransactionQuery{SortByValue: true, Ascending: true, MinValue: big.NewInt(9), MaxValue: values[1]},
+				fmt.Sprint([]*big.Int{values[3], values[4], values[2], values[1]})},
+			{TransactionQuery{SortByValue: true, MinValue: big.NewInt(10), MaxValue: big.NewInt(10)}, "[10]"},
+			{TransactionQuery{SortByValue: true, MinValue: maxUint256}, fmt.Sprint([]*big.Int{maxUint256})},
+		}
+		for _, tt := range tests {
+			tt.query.Limit = 10
+			txs, err := s.ListTransactions(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("failed to list transactions: %v", err)
+			}
+			got := make([]*big.Int, len(txs))
+			for i, tx := range txs {
+				got[i] = tx.Value.Int()
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("values of %+v = %v, want %s", tt.query, got, tt.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/r04922101/portto/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// spanKey is the key of the span of a statement in its instance settings
+const spanKey = "tracing:span"
+
+// registerTracing registers callbacks starting a span before each statement and ending it after,
+// as a child of the span in the context given by WithContext
+func registerTracing(gdb *gorm.DB) error {
+	cb := gdb.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("tracing:before_create", startSpan("create")),
+		cb.Create().After("gorm:create").Register("tracing:after_create", endSpan),
+		cb.Query().Before("gorm:query").Register("tracing:before_query", startSpan("query")),
+		cb.Query().After("gorm:query").Register("tracing:after_query", endSpan),
+		cb.Update().Before("gorm:update").Register("tracing:before_update", startSpan("update")),
+		cb.Update().After("gorm:update").Register("tracing:after_update", endSpan),
+		cb.Delete().Before("gorm:delete").Register("tracing:before_delete", startSpan("delete")),
+		cb.Delete().After("gorm:delete").Register("tracing:after_delete", endSpan),
+		cb.Row().Before("gorm:row").Register("tracing:before_row", startSpan("row")),
+		cb.Row().After("gorm:row").Register("tracing:after_row", endSpan),
+		cb.Raw().Before("gorm:raw").Register("tracing:before_raw", startSpan("raw")),
+		cb.Raw().After("gorm:raw").Register("tracing:after_raw", endSpan),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startSpan returns a callback starting a span of an operation
+func startSpan(op string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx, span := tracing.Start(tx.Statement.Context, "gorm."+op, semconv.DBSystemKey.String(tx.Dialector.Name()))
+		tx.Statement.Context = ctx
+		tx.InstanceSet(spanKey, span)
+	}
+}
+
+// endSpan ends the span started by startSpan with the statement and its error
+func endSpan(tx *gorm.DB) {
+	v, ok := tx.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	span.SetAttributes(semconv.DBStatementKey.String(tx.Statement.SQL.String()))
+	if tx.Statement.Table != "" {
+		span.SetAttributes(semconv.DBSQLTableKey.String(tx.Statement.Table))
+	}
+	err := tx.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// a missing record is an expected result rather than a failure
+		err = nil
+	}
+	tracing.End(span, err)
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+)
+
+var (
+	recorder     = tracetest.NewInMemoryExporter()
+	recorderOnce sync.Once
+)
+
+// recordSpans records ended spans of the shared tracer, which is bound to the first global provider, from now on
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	recorderOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(recorder)))
+	})
+	recorder.Reset()
+	return recorder
+}
+
+func TestTracing(t *testing.T) {
+	gdb := newSQLiteDB(t)
+	migrate(t, gdb, LatestVersion())
+	s := NewSQLStore(gdb, testChainID)
+	writeBlocks(t, s, 1, 1)
+	spans := recordSpans(t)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	if _, err := s.GetBlockByNumber(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetBlockByNumber(ctx, 2); err == nil {
+		t.Error("got a block not in DB")
+	}
+	if err := gdb.WithContext(ctx).Exec("SELECT * FROM nowhere").Error; err == nil {
+		t.Error("queried a missing table")
+	}
+	parent.End()
+
+	queries, failed := 0, 0
+	for _, span := range spans.GetSpans() {
+		if !strings.HasPrefix(span.Name, "gorm.") {
+			continue
+		}
+		// preloading queries are children of the query preloading them
+		if span.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+			t.Errorf("%s is not in the trace of the request", span.Name)
+		}
+		attrs := map[string]string{}
+		for _, a := range span.Attributes {
+			attrs[string(a.Key)] = a.Value.Emit()
+		}
+		if attrs[string(semconv.DBSystemKey)] != DriverSQLite || attrs[string(semconv.DBStatementKey)] == "" {
+			t.Errorf("%s attributes = %v", span.Name, attrs)
+		}
+		if span.Name == "gorm.query" {
+			queries++
+		}
+		// a missing record is not a failure
+		if span.Status.Code == codes.Error {
+			failed++
+			if !strings.Contains(attrs[string(semconv.DBStatementKey)], "nowhere") {
+				t.Errorf("%s failed: %s", span.Name, span.Status.Description)
+			}
+		}
+	}
+	if queries < 2 || failed != 1 {
+		t.Errorf("# of queries = %d and failed statements = %d, want >= 2 and 1", queries, failed)
+	}
+}
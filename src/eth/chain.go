@@ -0,0 +1,94 @@
+package eth
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Fork defines a hard fork activated at a block
+type Fork struct {
+	Name  string `json:"name"`
+	Block uint64 `json:"block"`
+}
+
+// ChainConfig defines metadata of a chain
+type ChainConfig struct {
+	ID   uint64 `json:"chain_id"`
+	Name string `json:"name"`
+	// Known is set if the chain is a known one, of which the other fields are filled
+	Known bool `json:"known"`
+	// Currency is the symbol of the native currency
+	Currency string `json:"currency,omitempty"`
+	// BlockTime is the target block interval in seconds
+	BlockTime uint64 `json:"block_time,omitempty"`
+	// Forks are hard forks activated by block numbers in order
+	Forks []Fork `json:"forks,omitempty"`
+}
+
+// knownChains maps IDs of known chains to their configs
+var knownChains = map[uint64]ChainConfig{}
+
+func init() {
+	for _, c := range []ChainConfig{
+		ethereumChain("Ethereum", "ETH", params.MainnetChainConfig),
+		ethereumChain("Goerli", "ETH", params.GoerliChainConfig),
+		ethereumChain("Sepolia", "ETH", params.SepoliaChainConfig),
+		{
+			ID: 56, Name: "BSC", Currency: "BNB", BlockTime: 3,
+			Forks: []Fork{
+				{"Ramanujan", 0}, {"Niels", 0}, {"MirrorSync", 5184000}, {"Bruno", 13082000},
+				{"Euler", 18907621}, {"Nano", 21962149}, {"Moran", 22107423}, {"Gibbs", 23846001},
+				{"Planck", 27281024}, {"Luban", 29020050}, {"Plato", 30720096},
+				{"Berlin", 31302048}, {"London", 31302048}, {"Hertz", 31302048}, {"HertzFix", 34140700},
+			},
+		},
+		{
+			ID: 97, Name: "BSC Testnet", Currency: "tBNB", BlockTime: 3,
+			Forks: []Fork{
+				{"Ramanujan", 0}, {"Niels", 0}, {"MirrorSync", 5582500}, {"Bruno", 13837000},
+				{"Euler", 19203503}, {"Gibbs", 22800220}, {"Nano", 23482428}, {"Moran", 23603940},
+				{"Planck", 28196022}, {"Luban", 29295050}, {"Plato", 29861024},
+				{"Berlin", 31103030}, {"London", 31103030}, {"Hertz", 31103030}, {"HertzFix", 35682300},
+			},
+		},
+	} {
+		c.Known = true
+		knownChains[c.ID] = c
+	}
+}
+
+// ethereumChain converts a go-ethereum chain config, whose forks after the merge are not numbered by blocks
+func ethereumChain(name, currency string, c *params.ChainConfig) ChainConfig {
+	ret := ChainConfig{ID: c.ChainID.Uint64(), Name: name, Currency: currency, BlockTime: 12}
+	for _, f := range []struct {
+		name  string
+		block *big.Int
+	}{
+		{"Homestead", c.HomesteadBlock},
+		{"DAO", c.DAOForkBlock},
+		{"TangerineWhistle", c.EIP150Block},
+		{"SpuriousDragon", c.EIP155Block},
+		{"Byzantium", c.ByzantiumBlock},
+		{"Constantinople", c.ConstantinopleBlock},
+		{"Petersburg", c.PetersburgBlock},
+		{"Istanbul", c.IstanbulBlock},
+		{"MuirGlacier", c.MuirGlacierBlock},
+		{"Berlin", c.BerlinBlock},
+		{"London", c.LondonBlock},
+		{"ArrowGlacier", c.ArrowGlacierBlock},
+	} {
+		if f.block != nil {
+			ret.Forks = append(ret.Forks, Fork{Name: f.name, Block: f.block.Uint64()})
+		}
+	}
+	return ret
+}
+
+// LookupChain returns the config of a known chain, or one with the ID only if the chain is unknown
+func LookupChain(id uint64) ChainConfig {
+	if c, ok := knownChains[id]; ok {
+		return c
+	}
+	return ChainConfig{ID: id, Name: "unknown"}
+}
@@ -0,0 +1,36 @@
+package eth
+
+import "testing"
+
+func TestLookupChain(t *testing.T) {
+	tests := []struct {
+		id       uint64
+		name     string
+		currency string
+		known    bool
+	}{
+		{1, "Ethereum", "ETH", true},
+		{56, "BSC", "BNB", true},
+		{97, "BSC Testnet", "tBNB", true},
+		{31337, "unknown", "", false},
+	}
+	for _, tt := range tests {
+		c := LookupChain(tt.id)
+		if c.ID != tt.id || c.Name != tt.name || c.Currency != tt.currency || c.Known != tt.known {
+			t.Errorf("chain %d = %+v", tt.id, c)
+		}
+		if tt.known && (c.BlockTime == 0 || len(c.Forks) == 0) {
+			t.Errorf("known chain %d has neither block time nor forks: %+v", tt.id, c)
+		}
+		// forks are in order of activation
+		for i := 1; i < len(c.Forks); i++ {
+			if c.Forks[i].Block < c.Forks[i-1].Block {
+				t.Errorf("fork %s of chain %d is activated before %s", c.Forks[i].Name, tt.id, c.Forks[i-1].Name)
+			}
+		}
+	}
+
+	if f := LookupChain(1).Forks[0]; f.Name != "Homestead" || f.Block != 1150000 {
+		t.Errorf("first fork of Ethereum = %+v", f)
+	}
+}
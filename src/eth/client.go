@@ -9,35 +9,54 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/r04922101/portto/metrics"
+	"github.com/r04922101/portto/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
 )
 
 // Client defines an interface wrapping eth client
 type Client interface {
+	// Chain returns the config of the chain served by the endpoint, whose ID is given by `eth_chainId`
+	Chain() ChainConfig
+	// GetBlockHash returns the hash in hex of the block numbered n without fetching its transactions
+	GetBlockHash(ctx context.Context, n uint64) (string, error)
 	GetBlockByNumber(ctx context.Context, n uint64) (*Block, error)
 	GetCurrentNumber(ctx context.Context) (uint64, error)
 	GetBlockByHash(ctx context.Context, h string) (*Block, error)
+	// GetTransactionByHash returns a transaction with its logs, or without them and with block number 0 if it is pending
 	GetTransactionByHash(ctx context.Context, h string) (*Transaction, error)
+	GetTokenName(ctx context.Context, address string) (string, error)
+	GetTokenSymbol(ctx context.Context, address string) (string, error)
+	GetTokenDecimals(ctx context.Context, address string) (uint8, error)
+	GetTokenTotalSupply(ctx context.Context, address string) (string, error)
+	GetBalance(ctx context.Context, address string, blockNum uint64) (Wei, error)
+	GetCode(ctx context.Context, address string, blockNum uint64) ([]byte, error)
+	// SupportsInterface calls ERC-165 `supportsInterface` of a contract
+	SupportsInterface(ctx context.Context, address string, interfaceID [4]byte) (bool, error)
+	// TraceBlock flattens calls made by contracts in block with `debug_traceBlockByNumber`,
+	// returning ErrTracingNotSupported if the node does not serve the debug API
+	TraceBlock(ctx context.Context, block *Block) ([]InternalTransaction, error)
+	// GetToken reads ERC-20 metadata of a contract, leaving fields the contract fails to provide empty
+	GetToken(ctx context.Context, address string) (*Token, error)
 }
 
 type serviceImpl struct {
 	delegate *ethclient.Client
-	chainID  *big.Int
+	rpc      *rpc.Client
+	chain    ChainConfig
+	// signer recovers senders of transactions of every type signed for the chain
+	signer types.Signer
 }
 
-func (s *serviceImpl) toTransaction(ctx context.Context, blockNum uint64, tx *types.Transaction) (*Transaction, error) {
+// toPendingTransaction converts tx, which is not mined yet and has neither a block nor a receipt with logs
+func (s *serviceImpl) toPendingTransaction(tx *types.Transaction) (*Transaction, error) {
 	// get sender address
-	msg, err := tx.AsMessage(types.NewEIP155Signer(s.chainID), nil)
+	msg, err := tx.AsMessage(s.signer, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get transaction message: %v", err)
 	}
-
-	// get logs
-	receipt, err := s.delegate.TransactionReceipt(ctx, tx.Hash())
-	if err != nil {
-		return nil, fmt.Errorf("failed to get transaction receipt: %v", err)
-	}
-
 	toAddress := ""
 	if to := msg.To(); to != nil {
 		toAddress = to.Hex()
@@ -47,27 +66,48 @@ func (s *serviceImpl) toTransaction(ctx context.Context, blockNum uint64, tx *ty
 		data = fmt.Sprintf("0x%s", hex.EncodeToString(d))
 	}
 	return &Transaction{
-		BlockNum: blockNum,
-		Hash:     tx.Hash().Hex(),
-		From:     msg.From().Hex(),
-		To:       toAddress,
-		Nounce:   tx.Nonce(),
-		Data:     data,
-		Value:    tx.Value().String(),
-		Logs:     toLogs(receipt.Logs),
+		Hash:   tx.Hash().Hex(),
+		From:   msg.From().Hex(),
+		To:     toAddress,
+		Nounce: tx.Nonce(),
+		Data:   data,
+		Value:  NewWei(tx.Value()),
 	}, nil
 }
 
-func (s *serviceImpl) toTransactions(ctx context.Context, blockNum uint64, transactions types.Transactions) ([]Transaction, error) {
+// toTransaction converts tx with its block number, logs and created contract of its receipt
+func (s *serviceImpl) toTransaction(ctx context.Context, tx *types.Transaction) (*Transaction, error) {
+	ret, err := s.toPendingTransaction(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// get logs, with a receipt fetched for every transaction of a block
+	rctx, span := tracing.Start(ctx, "eth.TransactionReceipt", attribute.String("hash", tx.Hash().Hex()))
+	receipt, err := s.delegate.TransactionReceipt(rctx, tx.Hash())
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction receipt: %w", rpcError(err))
+	}
+
+	ret.BlockNum = receipt.BlockNumber.Uint64()
+	ret.Logs = toLogs(receipt.Logs)
+	if tx.To() == nil {
+		ret.ContractAddress = receipt.ContractAddress.Hex()
+	}
+	return ret, nil
+}
+
+func (s *serviceImpl) toTransactions(ctx context.Context, transactions types.Transactions) ([]Transaction, error) {
 	ret := make([]Transaction, len(transactions))
 
 	eg, gctx := errgroup.WithContext(ctx)
 	for i, t := range transactions {
 		i, t := i, t
 		eg.Go(func() error {
-			tx, err := s.toTransaction(gctx, blockNum, t)
+			tx, err := s.toTransaction(gctx, t)
 			if err != nil {
-				return fmt.Errorf("failed to convert transaction: %v", err)
+				return fmt.Errorf("failed to convert transaction: %w", err)
 			}
 			ret[i] = *tx
 			return nil
@@ -82,9 +122,9 @@ func (s *serviceImpl) toTransactions(ctx context.Context, blockNum uint64, trans
 
 // fromEthBlock converts go-ethereum block to block
 func (s *serviceImpl) toBlock(ctx context.Context, b *types.Block) (*Block, error) {
-	transactions, err := s.toTransactions(ctx, b.NumberU64(), b.Transactions())
+	transactions, err := s.toTransactions(ctx, b.Transactions())
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert transactions: %v", err)
+		return nil, fmt.Errorf("failed to convert transactions: %w", err)
 	}
 	block := &Block{
 		Num:          b.NumberU64(),
@@ -99,21 +139,33 @@ func (s *serviceImpl) toBlock(ctx context.Context, b *types.Block) (*Block, erro
 func (s *serviceImpl) GetBlockByNumber(ctx context.Context, n uint64) (*Block, error) {
 	b, err := s.delegate.BlockByNumber(ctx, big.NewInt(int64(n)))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get block by number %d: %v", n, err)
+		return nil, fmt.Errorf("failed to get block by number %d: %w", n, rpcError(err))
 	}
 
 	block, err := s.toBlock(ctx, b)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert block: %v", err)
+		return nil, fmt.Errorf("failed to convert block: %w", err)
 	}
 
 	return block, nil
 }
 
+func (s *serviceImpl) Chain() ChainConfig {
+	return s.chain
+}
+
+func (s *serviceImpl) GetBlockHash(ctx context.Context, n uint64) (string, error) {
+	h, err := s.delegate.HeaderByNumber(ctx, new(big.Int).SetUint64(n))
+	if err != nil {
+		return "", fmt.Errorf("failed to get header of block %d: %w", n, rpcError(err))
+	}
+	return h.Hash().Hex(), nil
+}
+
 func (s *serviceImpl) GetCurrentNumber(ctx context.Context) (uint64, error) {
 	n, err := s.delegate.BlockNumber(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get current block number: %v", err)
+		return 0, fmt.Errorf("failed to get current block number: %w", rpcError(err))
 	}
 	return n, nil
 }
@@ -121,44 +173,87 @@ func (s *serviceImpl) GetCurrentNumber(ctx context.Context) (uint64, error) {
 func (s *serviceImpl) GetBlockByHash(ctx context.Context, h string) (*Block, error) {
 	b, err := s.delegate.BlockByHash(ctx, common.HexToHash(h))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get block by hash %s: %v", h, err)
+		return nil, fmt.Errorf("failed to get block by hash %s: %w", h, rpcError(err))
 	}
 
 	block, err := s.toBlock(ctx, b)
 	if err != nil {
-		return nil, fmt.Errorf("failed to conver block: %v", err)
+		return nil, fmt.Errorf("failed to conver block: %w", err)
 	}
 
 	return block, nil
 }
 
 func (s *serviceImpl) GetTransactionByHash(ctx context.Context, h string) (*Transaction, error) {
-	t, _, err := s.delegate.TransactionByHash(ctx, common.HexToHash(h))
+	t, pending, err := s.delegate.TransactionByHash(ctx, common.HexToHash(h))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get transaction by hash %s: %v", h, err)
+		return nil, fmt.Errorf("failed to get transaction by hash %s: %w", h, rpcError(err))
 	}
 
-	tx, err := s.toTransaction(ctx, 0, t)
+	var tx *Transaction
+	if pending {
+		tx, err = s.toPendingTransaction(t)
+	} else {
+		tx, err = s.toTransaction(ctx, t)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to construct transaction: %v", err)
+		return nil, fmt.Errorf("failed to construct transaction: %w", err)
 	}
 
 	return tx, nil
 }
 
+func (s *serviceImpl) GetBalance(ctx context.Context, address string, blockNum uint64) (Wei, error) {
+	b, err := s.delegate.BalanceAt(ctx, common.HexToAddress(address), new(big.Int).SetUint64(blockNum))
+	if err != nil {
+		return Wei{}, fmt.Errorf("failed to get balance of %s at block %d: %w", address, blockNum, rpcError(err))
+	}
+	return NewWei(b), nil
+}
+
+func (s *serviceImpl) GetCode(ctx context.Context, address string, blockNum uint64) ([]byte, error) {
+	code, err := s.delegate.CodeAt(ctx, common.HexToAddress(address), new(big.Int).SetUint64(blockNum))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get code of %s at block %d: %w", address, blockNum, rpcError(err))
+	}
+	return code, nil
+}
+
+// CheckChainID checks the RPC endpoint serves the chain with id
+func CheckChainID(ctx context.Context, endpoint string, id uint64) error {
+	rpcClient, err := rpc.DialContext(ctx, endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to connect to endpoint %s: %v", endpoint, err)
+	}
+	defer rpcClient.Close()
+	chainID, err := ethclient.NewClient(rpcClient).ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get chain ID: %v", err)
+	}
+	if chainID.Uint64() != id {
+		return fmt.Errorf("endpoint %s serves chain %d, not chain %d", endpoint, chainID, id)
+	}
+	return nil
+}
+
 // NewClient creates a EthClient connecting to endpoint
 func NewClient(endpoint string) (Client, error) {
-	client, err := ethclient.Dial(endpoint)
+	rpcClient, err := rpc.DialContext(context.Background(), endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to endpoint %s: %v", endpoint, err)
 	}
-	chainID, err := client.NetworkID(context.Background())
+	client := ethclient.NewClient(rpcClient)
+	// the network ID of `net_version` may differ from the EIP-155 chain ID transactions are signed for
+	chainID, err := client.ChainID(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("failed to get network ID: %v", err)
+		return nil, fmt.Errorf("failed to get chain ID: %v", err)
 	}
 
-	return &serviceImpl{
+	s := &serviceImpl{
 		delegate: client,
-		chainID:  chainID,
-	}, nil
+		rpc:      rpcClient,
+		chain:    LookupChain(chainID.Uint64()),
+		signer:   types.LatestSignerForChainID(chainID),
+	}
+	return &instrumentedClient{Client: s, chain: metrics.Chain(s.chain.ID)}, nil
 }
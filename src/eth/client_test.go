@@ -0,0 +1,109 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestCheckChainID(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", newFakeNode(97)); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	endpoint := httptest.NewServer(server)
+	defer endpoint.Close()
+
+	ctx := context.Background()
+	if err := CheckChainID(ctx, endpoint.URL, 97); err != nil {
+		t.Errorf("failed to check chain ID: %v", err)
+	}
+	if err := CheckChainID(ctx, endpoint.URL, 56); err == nil {
+		t.Error("endpoint serving chain 97 passes the check of chain 56")
+	}
+	if err := CheckChainID(ctx, "http://127.0.0.1:1", 97); err == nil {
+		t.Error("unreachable endpoint passes the check")
+	}
+}
+
+func TestNewClientChain(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", newFakeNode(56)); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	endpoint := httptest.NewServer(server)
+	defer endpoint.Close()
+
+	// the chain is the one of eth_chainId, which the fake node serves without net_version
+	c, err := NewClient(endpoint.URL)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if chain := c.Chain(); chain.ID != 56 || chain.Name != "BSC" || !chain.Known {
+		t.Errorf("chain = %+v", chain)
+	}
+}
+
+func TestGetTransactionByHash(t *testing.T) {
+	ctx := context.Background()
+	node := newFakeNode(97)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	signer := types.LatestSignerForChainID(big.NewInt(97))
+	to := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	contract := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	sign := func(nonce uint64, to *common.Address) *types.Transaction {
+		return types.MustSignNewTx(key, signer, &types.LegacyTx{Nonce: nonce, To: to, Value: big.NewInt(7), Gas: 21000, GasPrice: big.NewInt(1)})
+	}
+	receipt := func(n int64, logs ...*types.Log) *types.Receipt {
+		return &types.Receipt{Status: types.ReceiptStatusSuccessful, BlockNumber: big.NewInt(n), BlockHash: common.HexToHash("0x01"), Logs: append([]*types.Log{}, logs...)}
+	}
+
+	mined, pending, created := sign(1, &to), sign(2, &to), sign(3, nil)
+	node.addTx(mined, receipt(42, &types.Log{Address: contract, Topics: []common.Hash{common.HexToHash("0x02")}, BlockNumber: 42, TxHash: mined.Hash()}))
+	node.addTx(pending, nil)
+	createdReceipt := receipt(43)
+	createdReceipt.ContractAddress = contract
+	node.addTx(created, createdReceipt)
+	c := newTestClient(t, node)
+
+	tests := []struct {
+		tx       *types.Transaction
+		blockNum uint64
+		to       string
+		contract string
+		logs     int
+	}{
+		{mined, 42, to.Hex(), "", 1},
+		// a pending transaction has no block and no receipt
+		{pending, 0, to.Hex(), "", 0},
+		{created, 43, "", contract.Hex(), 0},
+	}
+	for _, tt := range tests {
+		got, err := c.GetTransactionByHash(ctx, tt.tx.Hash().Hex())
+		if err != nil {
+			t.Errorf("failed to get transaction %d: %v", tt.tx.Nonce(), err)
+			continue
+		}
+		if got.BlockNum != tt.blockNum || got.From != from.Hex() || got.To != tt.to || got.ContractAddress != tt.contract ||
+			len(got.Logs) != tt.logs || got.Nounce != tt.tx.Nonce() || got.Value.Int().Int64() != 7 {
+			t.Errorf("transaction %d = %+v", tt.tx.Nonce(), got)
+		}
+	}
+
+	if _, err := c.GetTransactionByHash(ctx, sign(4, &to).Hash().Hex()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing transaction err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,115 @@
+package eth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// token standards of contracts
+const (
+	StandardERC20   = "ERC20"
+	StandardERC721  = "ERC721"
+	StandardERC1155 = "ERC1155"
+)
+
+// ERC-165 interface IDs
+var (
+	interfaceERC165  = [4]byte{0x01, 0xff, 0xc9, 0xa7}
+	interfaceInvalid = [4]byte{0xff, 0xff, 0xff, 0xff}
+	interfaceERC721  = [4]byte{0x80, 0xac, 0x58, 0xcd}
+	interfaceERC1155 = [4]byte{0xd9, 0xb6, 0x7a, 0x26}
+)
+
+// selectors of methods required by token standards
+var (
+	erc20Selectors = []string{
+		"0x18160ddd", // totalSupply()
+		"0x70a08231", // balanceOf(address)
+		"0xa9059cbb", // transfer(address,uint256)
+		"0x23b872dd", // transferFrom(address,address,uint256)
+		"0x095ea7b3", // approve(address,uint256)
+		"0xdd62ed3e", // allowance(address,address)
+	}
+	erc721Selectors = []string{
+		"0x6352211e", // ownerOf(uint256)
+		"0x42842e0e", // safeTransferFrom(address,address,uint256)
+		"0xa22cb465", // setApprovalForAll(address,bool)
+	}
+	erc1155Selectors = []string{
+		"0x4e1273f4", // balanceOfBatch(address[],uint256[])
+		"0x2eb2c2d6", // safeBatchTransferFrom(address,address,uint256[],uint256[],bytes)
+	}
+)
+
+func (s *serviceImpl) SupportsInterface(ctx context.Context, address string, interfaceID [4]byte) (bool, error) {
+	// supportsInterface(bytes4)
+	input := append(hexutil.MustDecode("0x01ffc9a7"), common.RightPadBytes(interfaceID[:], 32)...)
+	to := common.HexToAddress(address)
+	out, err := s.delegate.CallContract(ctx, ethereum.CallMsg{To: &to, Data: input}, nil)
+	if err != nil {
+		if isCallFailure(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to call supportsInterface of %s: %v", address, err)
+	}
+	return len(out) == 32 && out[31] == 1, nil
+}
+
+// hasSelectors reports whether code pushes all the selectors, which is how
+// the dispatcher generated by solidity and vyper compilers matches methods
+func hasSelectors(code []byte, selectors []string) bool {
+	for _, sel := range selectors {
+		// PUSH4 <selector>
+		if !bytes.Contains(code, append([]byte{0x63}, hexutil.MustDecode(sel)...)) {
+			return false
+		}
+	}
+	return true
+}
+
+// DetectStandard detects the token standard a contract implements with ERC-165,
+// falling back to looking for selectors of required methods in its bytecode
+func DetectStandard(ctx context.Context, c Client, address string, code []byte) (string, error) {
+	// a compliant ERC-165 contract supports its ID and rejects the invalid one
+	supports165, err := c.SupportsInterface(ctx, address, interfaceERC165)
+	if err != nil {
+		return "", err
+	}
+	if supports165 {
+		invalid, err := c.SupportsInterface(ctx, address, interfaceInvalid)
+		if err != nil {
+			return "", err
+		}
+		supports165 = !invalid
+	}
+	if supports165 {
+		// ERC-1155 is checked first as in the bytecode, so that a contract supporting both is labeled the same every time
+		for _, i := range []struct {
+			id       [4]byte
+			standard string
+		}{{interfaceERC1155, StandardERC1155}, {interfaceERC721, StandardERC721}} {
+			ok, err := c.SupportsInterface(ctx, address, i.id)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				return i.standard, nil
+			}
+		}
+	}
+
+	switch {
+	case hasSelectors(code, erc1155Selectors):
+		return StandardERC1155, nil
+	case hasSelectors(code, erc721Selectors):
+		return StandardERC721, nil
+	case hasSelectors(code, erc20Selectors):
+		return StandardERC20, nil
+	}
+	return "", nil
+}
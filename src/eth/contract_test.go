@@ -0,0 +1,89 @@
+package eth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// bytecode returns code of a dispatcher matching selectors with PUSH4
+func bytecode(selectors ...string) []byte {
+	code := []byte{0x60, 0x80, 0x60, 0x40, 0x52}
+	for _, sel := range selectors {
+		code = append(code, 0x80, 0x63)
+		code = append(code, hexutil.MustDecode(sel)...)
+		code = append(code, 0x14)
+	}
+	return code
+}
+
+func TestHasSelectors(t *testing.T) {
+	code := bytecode(erc20Selectors...)
+	if !hasSelectors(code, erc20Selectors) {
+		t.Error("ERC-20 selectors are not found")
+	}
+	if hasSelectors(code, erc721Selectors) {
+		t.Error("ERC-721 selectors are found in ERC-20 code")
+	}
+	// selectors must be pushed rather than appear anywhere
+	if hasSelectors(hexutil.MustDecode("0xa9059cbb"), []string{"0xa9059cbb"}) {
+		t.Error("selector is found without PUSH4")
+	}
+}
+
+func TestDetectStandard(t *testing.T) {
+	const address = "0x1111111111111111111111111111111111111111"
+	erc721 := append(append([]string{}, erc20Selectors[:2]...), erc721Selectors...)
+	tests := []struct {
+		name       string
+		code       []byte
+		interfaces [][4]byte
+		want       string
+	}{
+		{"ERC-165 ERC-721", nil, [][4]byte{interfaceERC165, interfaceERC721}, StandardERC721},
+		{"ERC-165 ERC-1155", nil, [][4]byte{interfaceERC165, interfaceERC1155}, StandardERC1155},
+		// ERC-1155 is checked first, whose contracts may support ERC-721 as well
+		{"ERC-165 ERC-721 and ERC-1155", nil, [][4]byte{interfaceERC165, interfaceERC721, interfaceERC1155}, StandardERC1155},
+		// a contract supporting every interface is not compliant, so its bytecode is looked at
+		{"non-compliant ERC-165", bytecode(erc20Selectors...), [][4]byte{interfaceERC165, interfaceInvalid, interfaceERC721}, StandardERC20},
+		{"ERC-721 bytecode", bytecode(erc721...), nil, StandardERC721},
+		{"ERC-1155 bytecode", bytecode(append(erc721, erc1155Selectors...)...), nil, StandardERC1155},
+		{"ERC-20 bytecode", bytecode(erc20Selectors...), nil, StandardERC20},
+		{"no standard", bytecode(erc20Selectors[:3]...), nil, ""},
+	}
+	for _, tt := range tests {
+		c := NewMemoryClient(97)
+		c.SetCode(address, tt.code, tt.interfaces...)
+		got, err := DetectStandard(context.Background(), c, address, tt.code)
+		if err != nil {
+			t.Errorf("%s: failed to detect standard: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: standard = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	c := NewMemoryClient(97)
+	c.Fail(ErrUnavailable)
+	if _, err := DetectStandard(context.Background(), c, address, nil); err == nil {
+		t.Error("detected standard with an unavailable RPC endpoint")
+	}
+}
+
+func TestSupportsInterface(t *testing.T) {
+	const address = "0x1111111111111111111111111111111111111111"
+	node := newFakeNode(97)
+	node.setOutput(address, "0x01ffc9a7", common.LeftPadBytes([]byte{1}, 32))
+	c := newTestClient(t, node)
+
+	if ok, err := c.SupportsInterface(context.Background(), address, interfaceERC721); err != nil || !ok {
+		t.Errorf("SupportsInterface = %v, %v", ok, err)
+	}
+	// contracts without supportsInterface revert
+	if ok, err := c.SupportsInterface(context.Background(), "0x2222222222222222222222222222222222222222", interfaceERC721); err != nil || ok {
+		t.Errorf("SupportsInterface of reverting contract = %v, %v", ok, err)
+	}
+}
@@ -23,8 +23,68 @@ func (ts *TransactionIDs) Value() (driver.Value, error) {
 	return string(val), err
 }
 
+// Topics is a custom type for gorm storing log topics as a JSON array
+type Topics []string
+
+// Scan implements the Scanner interface
+func (ts *Topics) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, ts)
+	case string:
+		return json.Unmarshal([]byte(v), ts)
+	case nil:
+		*ts = nil
+		return nil
+	}
+	return fmt.Errorf("unsupported type %T for topics", src)
+}
+
+// Value implements the Valuer interface
+func (ts Topics) Value() (driver.Value, error) {
+	val, err := json.Marshal(ts)
+	return string(val), err
+}
+
+// GormDataType implements the GormDataTypeInterface interface
+func (Topics) GormDataType() string {
+	return "text"
+}
+
+// Args is a custom type for gorm storing decoded arguments as a JSON object
+type Args map[string]interface{}
+
+// Scan implements the Scanner interface
+func (a *Args) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, a)
+	case string:
+		return json.Unmarshal([]byte(v), a)
+	case nil:
+		*a = nil
+		return nil
+	}
+	return fmt.Errorf("unsupported type %T for args", src)
+}
+
+// Value implements the Valuer interface
+func (a Args) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	val, err := json.Marshal(a)
+	return string(val), err
+}
+
+// GormDataType implements the GormDataTypeInterface interface
+func (Args) GormDataType() string {
+	return "text"
+}
+
 // Block defines a data structure representing an eth block
 type Block struct {
+	ChainID        uint64         `json:"-" gorm:"primaryKey;autoIncrement:false"`
 	Num            uint64         `json:"block_num" gorm:"primaryKey"` // hash in hex
 	Hash           string         `json:"block_hash" gorm:"index"`     // hash in hex
 	Time           uint64         `json:"block_time"`
@@ -35,32 +95,105 @@ type Block struct {
 
 // Transaction defines a data structure representing an eth transaction
 type Transaction struct {
-	BlockNum uint64 `json:"-" gorm:"index"`
-	Hash     string `json:"tx_hash" gorm:"primaryKey"` // hash in hex
-	From     string `json:"from"`                      // from address in hex
-	To       string `json:"to"`                        // to address in hex
+	ChainID uint64 `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	Hash    string `json:"tx_hash" gorm:"primaryKey"` // hash in hex
+	// BlockNum is in the primary key, since mysql requires it of unique keys of tables partitioned by it
+	BlockNum uint64 `json:"-" gorm:"primaryKey;autoIncrement:false;index"`
+	From     string `json:"from"`            // from address in hex
+	To       string `json:"to" gorm:"index"` // to address in hex
 	Nounce   uint64 `json:"nounce"`
 	Data     string `json:"data"`
-	Value    string `json:"value"`
+	Value    Wei    `json:"value"` // value in wei
 	Logs     []Log  `json:"logs" gorm:"foreignKey:TransactionHash;references:Hash"`
+	// ContractAddress is the address of the contract created by the transaction
+	ContractAddress string `json:"contract_address,omitempty"`
+	// Method and DecodedInput are decoded from Data with the ABI of the called contract
+	Method       string `json:"method,omitempty"`
+	DecodedInput Args   `json:"decoded_input,omitempty"`
 }
 
 // Log defines a data structure representing a transaction log
 type Log struct {
-	TransactionHash string `json:"-" gorm:"index"` // hash in hex
-	Index           uint   `json:"index"`
-	Data            string `json:"data"`
+	ChainID         uint64 `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	TransactionHash string `json:"-" gorm:"primaryKey"`  // hash in hex
+	Address         string `json:"address" gorm:"index"` // emitting contract address in hex
+	Topics          Topics `json:"topics"`               // topics in hex
+	Index           uint   `json:"index" gorm:"primaryKey;autoIncrement:false"`
+	// BlockNum is in the primary key like the one of Transaction
+	BlockNum uint64 `json:"-" gorm:"primaryKey;autoIncrement:false;index"`
+	Data     string `json:"data"`
+	// Event and Decoded are decoded from Topics and Data with the ABI of the emitting contract
+	Event   string `json:"event,omitempty"`
+	Decoded Args   `json:"decoded,omitempty"`
+}
+
+// InternalTransaction defines a data structure representing a call made by a contract,
+// flattened from the call tree of a transaction
+type InternalTransaction struct {
+	ChainID         uint64 `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	TransactionHash string `json:"tx_hash" gorm:"primaryKey"` // hash in hex
+	Index           uint   `json:"index" gorm:"primaryKey"`   // position in the flattened call tree
+	BlockNum        uint64 `json:"block_num" gorm:"index"`
+	Type            string `json:"type"`              // CALL, DELEGATECALL, STATICCALL, CREATE, CREATE2 or SELFDESTRUCT
+	From            string `json:"from" gorm:"index"` // from address in hex
+	To              string `json:"to" gorm:"index"`   // to address in hex
+	Value           Wei    `json:"value"`             // value in wei
+	Depth           uint   `json:"depth"`             // 1 for calls made by the transaction itself
+	Error           string `json:"error,omitempty"`   // error reverting the call
+}
+
+// Balance defines a data structure representing the native balance of an address at a block
+type Balance struct {
+	ChainID  uint64 `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	Address  string `json:"address" gorm:"primaryKey"`   // address in hex
+	BlockNum uint64 `json:"block_num" gorm:"primaryKey"` // block the balance is read at
+	Balance  Wei    `json:"balance"`                     // balance in wei
+}
+
+// Contract defines a data structure representing a deployed contract
+type Contract struct {
+	ChainID         uint64 `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	Address         string `json:"address" gorm:"primaryKey"` // contract address in hex
+	Deployer        string `json:"deployer" gorm:"index"`     // address in hex of the deploying account or contract
+	TransactionHash string `json:"tx_hash" gorm:"index"`      // hash in hex of the creation transaction
+	BlockNum        uint64 `json:"block_num" gorm:"index"`
+	BytecodeHash    string `json:"bytecode_hash" gorm:"index"` // keccak256 hash in hex of the runtime bytecode
+	Standard        string `json:"standard,omitempty"`         // detected token standard, ERC20, ERC721 or ERC1155
+}
+
+// ContractABI defines a data structure representing the ABI registered for a contract
+type ContractABI struct {
+	ChainID uint64 `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	Address string `json:"address" gorm:"primaryKey"` // contract address in hex
+	ABI     string `json:"abi" gorm:"type:text"`      // ABI in JSON
+}
+
+// Token defines a data structure representing ERC-20 token metadata
+type Token struct {
+	ChainID     uint64 `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	Address     string `json:"address" gorm:"primaryKey"` // contract address in hex
+	Name        string `json:"name"`
+	Symbol      string `json:"symbol"`
+	Decimals    uint8  `json:"decimals"`
+	TotalSupply string `json:"total_supply"`
 }
 
 func toLogs(logs []*types.Log) []Log {
 	ret := make([]Log, len(logs))
-	data := ""
 	for i, l := range logs {
+		data := ""
 		if d := l.Data; len(d) > 0 {
 			data = fmt.Sprintf("0x%s", hex.EncodeToString(d))
 		}
+		topics := make(Topics, len(l.Topics))
+		for j, t := range l.Topics {
+			topics[j] = t.Hex()
+		}
 		ret[i] = Log{
 			TransactionHash: l.TxHash.Hex(),
+			BlockNum:        l.BlockNumber,
+			Address:         l.Address.Hex(),
+			Topics:          topics,
 			Index:           l.Index,
 			Data:            data,
 		}
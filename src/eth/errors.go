@@ -0,0 +1,39 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	// ErrNotFound is returned when a requested block or transaction is not on chain
+	ErrNotFound = errors.New("not found on chain")
+	// ErrUnavailable is returned when the RPC endpoint cannot be reached, times out or is overloaded
+	ErrUnavailable = errors.New("RPC endpoint is unavailable")
+)
+
+// rpcError classifies an error of an RPC call as ErrNotFound or ErrUnavailable, keeping its message,
+// while errors returned by the node otherwise are left as they are
+func rpcError(err error) error {
+	var (
+		httpErr rpc.HTTPError
+		netErr  net.Error
+	)
+	switch {
+	case errors.Is(err, ethereum.NotFound):
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	case errors.As(err, &httpErr):
+		if httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return err
+}
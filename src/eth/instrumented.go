@@ -0,0 +1,129 @@
+package eth
+
+import (
+	"context"
+	"time"
+
+	"github.com/r04922101/portto/metrics"
+	"github.com/r04922101/portto/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// instrumentedClient traces calls to a client, and records their counts, errors and latencies by method
+type instrumentedClient struct {
+	Client
+	chain string
+}
+
+// observe starts a call to method in a span of ctx, returning the context of the span,
+// and a function ending it, which failed if err is not nil
+func (c *instrumentedClient) observe(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "eth."+method, append(attrs, attribute.String("chain.id", c.chain))...)
+	return ctx, func(err error) {
+		tracing.End(span, err)
+		metrics.RPCRequests.WithLabelValues(c.chain, method).Inc()
+		metrics.RPCDuration.WithLabelValues(c.chain, method).Observe(metrics.Since(start))
+		if err != nil {
+			metrics.RPCErrors.WithLabelValues(c.chain, method).Inc()
+		}
+	}
+}
+
+func (c *instrumentedClient) GetBlockHash(ctx context.Context, n uint64) (string, error) {
+	ctx, done := c.observe(ctx, "GetBlockHash", attribute.Int64("block.number", int64(n)))
+	ret, err := c.Client.GetBlockHash(ctx, n)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) GetBlockByNumber(ctx context.Context, n uint64) (*Block, error) {
+	ctx, done := c.observe(ctx, "GetBlockByNumber", attribute.Int64("block.number", int64(n)))
+	ret, err := c.Client.GetBlockByNumber(ctx, n)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) GetCurrentNumber(ctx context.Context) (uint64, error) {
+	ctx, done := c.observe(ctx, "GetCurrentNumber")
+	ret, err := c.Client.GetCurrentNumber(ctx)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) GetBlockByHash(ctx context.Context, h string) (*Block, error) {
+	ctx, done := c.observe(ctx, "GetBlockByHash", attribute.String("hash", h))
+	ret, err := c.Client.GetBlockByHash(ctx, h)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) GetTransactionByHash(ctx context.Context, h string) (*Transaction, error) {
+	ctx, done := c.observe(ctx, "GetTransactionByHash", attribute.String("hash", h))
+	ret, err := c.Client.GetTransactionByHash(ctx, h)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) GetTokenName(ctx context.Context, address string) (string, error) {
+	ctx, done := c.observe(ctx, "GetTokenName", attribute.String("address", address))
+	ret, err := c.Client.GetTokenName(ctx, address)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) GetTokenSymbol(ctx context.Context, address string) (string, error) {
+	ctx, done := c.observe(ctx, "GetTokenSymbol", attribute.String("address", address))
+	ret, err := c.Client.GetTokenSymbol(ctx, address)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) GetTokenDecimals(ctx context.Context, address string) (uint8, error) {
+	ctx, done := c.observe(ctx, "GetTokenDecimals", attribute.String("address", address))
+	ret, err := c.Client.GetTokenDecimals(ctx, address)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) GetTokenTotalSupply(ctx context.Context, address string) (string, error) {
+	ctx, done := c.observe(ctx, "GetTokenTotalSupply", attribute.String("address", address))
+	ret, err := c.Client.GetTokenTotalSupply(ctx, address)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) GetBalance(ctx context.Context, address string, blockNum uint64) (Wei, error) {
+	ctx, done := c.observe(ctx, "GetBalance", attribute.String("address", address), attribute.Int64("block.number", int64(blockNum)))
+	ret, err := c.Client.GetBalance(ctx, address, blockNum)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) GetCode(ctx context.Context, address string, blockNum uint64) ([]byte, error) {
+	ctx, done := c.observe(ctx, "GetCode", attribute.String("address", address), attribute.Int64("block.number", int64(blockNum)))
+	ret, err := c.Client.GetCode(ctx, address, blockNum)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) SupportsInterface(ctx context.Context, address string, interfaceID [4]byte) (bool, error) {
+	ctx, done := c.observe(ctx, "SupportsInterface", attribute.String("address", address))
+	ret, err := c.Client.SupportsInterface(ctx, address, interfaceID)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) TraceBlock(ctx context.Context, block *Block) ([]InternalTransaction, error) {
+	ctx, done := c.observe(ctx, "TraceBlock", attribute.Int64("block.number", int64(block.Num)))
+	ret, err := c.Client.TraceBlock(ctx, block)
+	done(err)
+	return ret, err
+}
+
+func (c *instrumentedClient) GetToken(ctx context.Context, address string) (*Token, error) {
+	ctx, done := c.observe(ctx, "GetToken", attribute.String("address", address))
+	ret, err := c.Client.GetToken(ctx, address)
+	done(err)
+	return ret, err
+}
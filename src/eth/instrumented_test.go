@@ -0,0 +1,104 @@
+package eth
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/r04922101/portto/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	recorder     = tracetest.NewInMemoryExporter()
+	recorderOnce sync.Once
+)
+
+// recordSpans records ended spans of the shared tracer, which is bound to the first global provider, from now on
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	recorderOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(recorder)))
+	})
+	recorder.Reset()
+	return recorder
+}
+
+func TestInstrumentedClient(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryClient(97)
+	m.AddBlock(&Block{Num: 1, Hash: "0x01"})
+	c := &instrumentedClient{Client: m, chain: "instrumented"}
+
+	if _, err := c.GetBlockByNumber(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBlockByNumber(ctx, 2); err == nil {
+		t.Error("got a block not on chain")
+	}
+	m.Fail(ErrUnavailable)
+	if _, err := c.GetCurrentNumber(ctx); err == nil {
+		t.Error("got the current number from an unavailable endpoint")
+	}
+
+	tests := []struct {
+		method         string
+		requests, errs float64
+	}{
+		{"GetBlockByNumber", 2, 1},
+		{"GetCurrentNumber", 1, 1},
+		{"GetBlockByHash", 0, 0},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(metrics.RPCRequests.WithLabelValues("instrumented", tt.method)); got != tt.requests {
+			t.Errorf("%s requests = %v, want %v", tt.method, got, tt.requests)
+		}
+		if got := testutil.ToFloat64(metrics.RPCErrors.WithLabelValues("instrumented", tt.method)); got != tt.errs {
+			t.Errorf("%s errors = %v, want %v", tt.method, got, tt.errs)
+		}
+	}
+	if got := testutil.CollectAndCount(metrics.RPCDuration, "portto_rpc_request_duration_seconds"); got < 2 {
+		t.Errorf("# of latency series = %d, want >= 2", got)
+	}
+}
+
+func TestInstrumentedClientSpans(t *testing.T) {
+	m := NewMemoryClient(97)
+	m.AddBlock(&Block{Num: 1, Hash: "0x01"})
+	c := &instrumentedClient{Client: m, chain: "97"}
+	spans := recordSpans(t)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	if _, err := c.GetBlockByHash(ctx, "0x01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetBlockByHash(ctx, "0x02"); err == nil {
+		t.Error("got a block not on chain")
+	}
+	parent.End()
+
+	got := spans.GetSpans()
+	if len(got) != 3 {
+		t.Fatalf("# of spans = %d, want 3", len(got))
+	}
+	for j, want := range []codes.Code{codes.Unset, codes.Error} {
+		span := got[j]
+		if span.Name != "eth.GetBlockByHash" || span.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %d = %s, want eth.GetBlockByHash as a child of the request", j, span.Name)
+		}
+		if span.Status.Code != want {
+			t.Errorf("span %d status = %v, want %v", j, span.Status.Code, want)
+		}
+		attrs := map[string]string{}
+		for _, a := range span.Attributes {
+			attrs[string(a.Key)] = a.Value.Emit()
+		}
+		if attrs["chain.id"] != "97" || attrs["hash"] == "" {
+			t.Errorf("span %d attributes = %v", j, attrs)
+		}
+	}
+}
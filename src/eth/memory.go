@@ -0,0 +1,289 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// MemoryClient serves blocks, tokens and accounts added to it in memory rather than by an RPC endpoint, e.g. for tests
+type MemoryClient struct {
+	chain ChainConfig
+
+	mu       sync.RWMutex
+	blocks   map[uint64]*Block // canonical blocks by number
+	hashes   map[string]*Block // blocks by hash, including reorganized ones
+	txs      map[string]*Transaction
+	tokens   map[string]*Token
+	balances map[string]Wei
+	code     map[string][]byte
+	// interfaces are ERC-165 interface IDs supported by contracts
+	interfaces map[string][][4]byte
+	// traces are internal transactions by block number, nil if tracing is not supported
+	traces map[uint64][]InternalTransaction
+	err    error
+}
+
+// NewMemoryClient creates a client of the chain with chainID, which has no block
+func NewMemoryClient(chainID uint64) *MemoryClient {
+	return &MemoryClient{
+		chain:      LookupChain(chainID),
+		blocks:     map[uint64]*Block{},
+		hashes:     map[string]*Block{},
+		txs:        map[string]*Transaction{},
+		tokens:     map[string]*Token{},
+		balances:   map[string]Wei{},
+		code:       map[string][]byte{},
+		interfaces: map[string][][4]byte{},
+	}
+}
+
+// key normalizes an address or a hash in hex
+func key(s string) string {
+	return strings.ToLower(s)
+}
+
+// copyBlock copies a block with its transactions, which share logs
+func copyBlock(b *Block) *Block {
+	ret := *b
+	ret.Transactions = append([]Transaction(nil), b.Transactions...)
+	return &ret
+}
+
+// AddBlock adds block to the chain, replacing the one of the same number as a reorg does
+func (m *MemoryClient) AddBlock(block *Block) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := copyBlock(block)
+	for i := range b.Transactions {
+		b.Transactions[i].BlockNum = b.Num
+		m.txs[key(b.Transactions[i].Hash)] = &b.Transactions[i]
+	}
+	m.blocks[b.Num] = b
+	m.hashes[key(b.Hash)] = b
+}
+
+// AddPendingTransaction adds a transaction in no block, which has block number 0 as pending ones fetched from a node
+func (m *MemoryClient) AddPendingTransaction(tx *Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := *tx
+	t.BlockNum = 0
+	m.txs[key(t.Hash)] = &t
+}
+
+// AddToken adds metadata of an ERC-20 token, whose contract provides none otherwise
+func (m *MemoryClient) AddToken(token *Token) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := *token
+	m.tokens[key(t.Address)] = &t
+}
+
+// SetBalance sets the native balance of address at every block
+func (m *MemoryClient) SetBalance(address string, balance Wei) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.balances[key(address)] = NewWei(balance.Int())
+}
+
+// SetCode sets the runtime bytecode of the contract at address, which supports ERC-165 interfaces
+func (m *MemoryClient) SetCode(address string, code []byte, interfaces ...[4]byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.code[key(address)] = code
+	m.interfaces[key(address)] = interfaces
+}
+
+// SetTraces sets internal transactions of the block numbered n, which enables tracing
+func (m *MemoryClient) SetTraces(n uint64, internals []InternalTransaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.traces == nil {
+		m.traces = map[uint64][]InternalTransaction{}
+	}
+	m.traces[n] = internals
+}
+
+// Fail fails every following call with err, e.g. ErrUnavailable, until it is called with nil
+func (m *MemoryClient) Fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+func (m *MemoryClient) Chain() ChainConfig {
+	return m.chain
+}
+
+func (m *MemoryClient) GetBlockHash(ctx context.Context, n uint64) (string, error) {
+	b, err := m.GetBlockByNumber(ctx, n)
+	if err != nil {
+		return "", err
+	}
+	return b.Hash, nil
+}
+
+func (m *MemoryClient) GetBlockByNumber(ctx context.Context, n uint64) (*Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	b, ok := m.blocks[n]
+	if !ok {
+		return nil, fmt.Errorf("block %d %w", n, ErrNotFound)
+	}
+	return copyBlock(b), nil
+}
+
+func (m *MemoryClient) GetCurrentNumber(ctx context.Context) (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.err != nil {
+		return 0, m.err
+	}
+	var ret uint64
+	for n := range m.blocks {
+		if n > ret {
+			ret = n
+		}
+	}
+	return ret, nil
+}
+
+func (m *MemoryClient) GetBlockByHash(ctx context.Context, h string) (*Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	b, ok := m.hashes[key(h)]
+	if !ok {
+		return nil, fmt.Errorf("block %s %w", h, ErrNotFound)
+	}
+	return copyBlock(b), nil
+}
+
+func (m *MemoryClient) GetTransactionByHash(ctx context.Context, h string) (*Transaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	t, ok := m.txs[key(h)]
+	if !ok {
+		return nil, fmt.Errorf("transaction %s %w", h, ErrNotFound)
+	}
+	ret := *t
+	return &ret, nil
+}
+
+// token returns metadata of the token at address, failing like a contract providing none if it is not added
+func (m *MemoryClient) token(address string) (*Token, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	t, ok := m.tokens[key(address)]
+	if !ok {
+		return nil, fmt.Errorf("%w of %s: no token", errUnexpectedOutput, address)
+	}
+	return t, nil
+}
+
+func (m *MemoryClient) GetTokenName(ctx context.Context, address string) (string, error) {
+	t, err := m.token(address)
+	if err != nil {
+		return "", err
+	}
+	return t.Name, nil
+}
+
+func (m *MemoryClient) GetTokenSymbol(ctx context.Context, address string) (string, error) {
+	t, err := m.token(address)
+	if err != nil {
+		return "", err
+	}
+	return t.Symbol, nil
+}
+
+func (m *MemoryClient) GetTokenDecimals(ctx context.Context, address string) (uint8, error) {
+	t, err := m.token(address)
+	if err != nil {
+		return 0, err
+	}
+	return t.Decimals, nil
+}
+
+func (m *MemoryClient) GetTokenTotalSupply(ctx context.Context, address string) (string, error) {
+	t, err := m.token(address)
+	if err != nil {
+		return "", err
+	}
+	return t.TotalSupply, nil
+}
+
+func (m *MemoryClient) GetToken(ctx context.Context, address string) (*Token, error) {
+	t, err := m.token(address)
+	if isCallFailure(err) {
+		return &Token{Address: common.HexToAddress(address).Hex()}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ret := *t
+	ret.Address = common.HexToAddress(address).Hex()
+	return &ret, nil
+}
+
+func (m *MemoryClient) GetBalance(ctx context.Context, address string, blockNum uint64) (Wei, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.err != nil {
+		return Wei{}, m.err
+	}
+	b := m.balances[key(address)]
+	return NewWei(b.Int()), nil
+}
+
+func (m *MemoryClient) GetCode(ctx context.Context, address string, blockNum uint64) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.code[key(address)], nil
+}
+
+func (m *MemoryClient) SupportsInterface(ctx context.Context, address string, interfaceID [4]byte) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.err != nil {
+		return false, m.err
+	}
+	if interfaceID == interfaceERC165 && len(m.interfaces[key(address)]) > 0 {
+		return true, nil
+	}
+	for _, id := range m.interfaces[key(address)] {
+		if id == interfaceID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryClient) TraceBlock(ctx context.Context, block *Block) ([]InternalTransaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.traces == nil {
+		return nil, ErrTracingNotSupported
+	}
+	return append([]InternalTransaction(nil), m.traces[block.Num]...), nil
+}
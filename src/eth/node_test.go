@@ -0,0 +1,155 @@
+package eth
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// revertError is an error of a call reverted by a contract, which the node returns with its code
+type revertError struct{}
+
+func (revertError) Error() string {
+	return "execution reverted"
+}
+
+func (revertError) ErrorCode() int {
+	return 3
+}
+
+// CallArgs are the arguments of `eth_call` used by the client
+type CallArgs struct {
+	To   *common.Address `json:"to"`
+	Data hexutil.Bytes   `json:"data"`
+}
+
+// fakeNode serves the `eth` namespace of a node in process, with outputs of calls by contract and selector
+type fakeNode struct {
+	chainID uint64
+
+	mu sync.Mutex
+	// outputs of calls by `<address>:<selector>` in lower case, which revert if missing
+	outputs map[string][]byte
+	// traces are the results of `debug_traceBlockByNumber` by block number, whose namespace is served if set
+	traces map[uint64][]txTrace
+	// txs are transactions by hash, which are pending without receipts if their receipts are nil
+	txs      map[common.Hash]*types.Transaction
+	receipts map[common.Hash]*types.Receipt
+}
+
+func newFakeNode(chainID uint64) *fakeNode {
+	return &fakeNode{
+		chainID:  chainID,
+		outputs:  map[string][]byte{},
+		txs:      map[common.Hash]*types.Transaction{},
+		receipts: map[common.Hash]*types.Receipt{},
+	}
+}
+
+// addTx adds a signed transaction, which is mined with receipt unless it is nil
+func (n *fakeNode) addTx(tx *types.Transaction, receipt *types.Receipt) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.txs[tx.Hash()] = tx
+	if receipt != nil {
+		receipt.TxHash = tx.Hash()
+		n.receipts[tx.Hash()] = receipt
+	}
+}
+
+// setOutput sets the output of calls of selector of the contract at address
+func (n *fakeNode) setOutput(address, selector string, out []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.outputs[strings.ToLower(address+":"+selector)] = out
+}
+
+func (n *fakeNode) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(new(big.Int).SetUint64(n.chainID))
+}
+
+func (n *fakeNode) Call(args CallArgs, block string) (hexutil.Bytes, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if args.To == nil || len(args.Data) < 4 {
+		return nil, revertError{}
+	}
+	out, ok := n.outputs[strings.ToLower(args.To.Hex()+":"+hexutil.Encode(args.Data[:4]))]
+	if !ok {
+		return nil, revertError{}
+	}
+	return out, nil
+}
+
+func (n *fakeNode) GetTransactionByHash(h common.Hash) (map[string]interface{}, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	tx, ok := n.txs[h]
+	if !ok {
+		return nil, nil
+	}
+	b, err := tx.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var ret map[string]interface{}
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil, err
+	}
+	// pending transactions have no block
+	if r, ok := n.receipts[h]; ok {
+		ret["blockNumber"] = hexutil.EncodeBig(r.BlockNumber)
+		ret["blockHash"] = r.BlockHash
+	}
+	return ret, nil
+}
+
+func (n *fakeNode) GetTransactionReceipt(h common.Hash) (*types.Receipt, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.receipts[h], nil
+}
+
+func (n *fakeNode) TraceBlockByNumber(num hexutil.Uint64, config map[string]string) ([]txTrace, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if config["tracer"] != "callTracer" {
+		return nil, fmt.Errorf("unexpected tracer %s", config["tracer"])
+	}
+	return n.traces[uint64(num)], nil
+}
+
+// newTestClient creates a client of node served in process, without instrumentation
+func newTestClient(t *testing.T, node *fakeNode) *serviceImpl {
+	t.Helper()
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", node); err != nil {
+		t.Fatalf("failed to register fake node: %v", err)
+	}
+	if node.traces != nil {
+		if err := server.RegisterName("debug", node); err != nil {
+			t.Fatalf("failed to register fake node: %v", err)
+		}
+	}
+	c := rpc.DialInProc(server)
+	t.Cleanup(func() {
+		c.Close()
+		server.Stop()
+	})
+	chainID := new(big.Int).SetUint64(node.chainID)
+	return &serviceImpl{
+		delegate: ethclient.NewClient(c),
+		rpc:      c,
+		chain:    LookupChain(node.chainID),
+		signer:   types.LatestSignerForChainID(chainID),
+	}
+}
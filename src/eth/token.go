@@ -0,0 +1,146 @@
+package eth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// TransferTopic is the topic of ERC-20 and ERC-721 `Transfer(address,address,uint256)` events
+const TransferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+const erc20MetadataABI = `[
+	{"constant":true,"inputs":[],"name":"name","outputs":[{"name":"","type":"string"}],"type":"function"},
+	{"constant":true,"inputs":[],"name":"symbol","outputs":[{"name":"","type":"string"}],"type":"function"},
+	{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"type":"function"},
+	{"constant":true,"inputs":[],"name":"totalSupply","outputs":[{"name":"","type":"uint256"}],"type":"function"}
+]`
+
+var (
+	erc20ABI = mustParseABI(erc20MetadataABI)
+
+	errUnexpectedOutput = errors.New("unexpected call output")
+)
+
+func mustParseABI(s string) abi.ABI {
+	a, err := abi.JSON(strings.NewReader(s))
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse abi: %v", err))
+	}
+	return a
+}
+
+// IsERC20Transfer reports whether a log is an ERC-20 `Transfer` event,
+// which has exactly 3 topics, unlike the ERC-721 one indexing token ID as well
+func IsERC20Transfer(l *Log) bool {
+	return len(l.Topics) == 3 && strings.EqualFold(l.Topics[0], TransferTopic)
+}
+
+// callERC20 calls a no-argument ERC-20 view method on address
+func (s *serviceImpl) callERC20(ctx context.Context, address, method string) ([]byte, error) {
+	input, err := erc20ABI.Pack(method)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack %s call: %v", method, err)
+	}
+	to := common.HexToAddress(address)
+	out, err := s.delegate.CallContract(ctx, ethereum.CallMsg{To: &to, Data: input}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s of %s: %w", method, address, rpcError(err))
+	}
+	return out, nil
+}
+
+// unpackString decodes a string returned by `name` or `symbol`,
+// falling back to bytes32 used by some non-standard tokens such as MKR
+func unpackString(method string, out []byte) string {
+	if len(out) == 32 {
+		return string(bytes.TrimRight(out, "\x00"))
+	}
+	if v, err := erc20ABI.Unpack(method, out); err == nil && len(v) == 1 {
+		if str, ok := v[0].(string); ok {
+			return str
+		}
+	}
+	if len(out) >= 32 {
+		return string(bytes.TrimRight(out[:32], "\x00"))
+	}
+	return ""
+}
+
+func (s *serviceImpl) GetTokenName(ctx context.Context, address string) (string, error) {
+	out, err := s.callERC20(ctx, address, "name")
+	if err != nil {
+		return "", err
+	}
+	return unpackString("name", out), nil
+}
+
+func (s *serviceImpl) GetTokenSymbol(ctx context.Context, address string) (string, error) {
+	out, err := s.callERC20(ctx, address, "symbol")
+	if err != nil {
+		return "", err
+	}
+	return unpackString("symbol", out), nil
+}
+
+func (s *serviceImpl) GetTokenDecimals(ctx context.Context, address string) (uint8, error) {
+	out, err := s.callERC20(ctx, address, "decimals")
+	if err != nil {
+		return 0, err
+	}
+	if len(out) < 32 {
+		return 0, fmt.Errorf("%w of %s decimals: %x", errUnexpectedOutput, address, out)
+	}
+	// tolerate tokens declaring decimals as uint256
+	d := new(big.Int).SetBytes(out[:32])
+	if !d.IsUint64() || d.Uint64() > 255 {
+		return 0, fmt.Errorf("%w of %s decimals: %s out of range", errUnexpectedOutput, address, d)
+	}
+	return uint8(d.Uint64()), nil
+}
+
+func (s *serviceImpl) GetTokenTotalSupply(ctx context.Context, address string) (string, error) {
+	out, err := s.callERC20(ctx, address, "totalSupply")
+	if err != nil {
+		return "", err
+	}
+	if len(out) < 32 {
+		return "", fmt.Errorf("%w of %s totalSupply: %x", errUnexpectedOutput, address, out)
+	}
+	return new(big.Int).SetBytes(out[:32]).String(), nil
+}
+
+// isCallFailure reports whether err is caused by the contract, e.g. a revert
+// reported by the node or malformed output, rather than a transport failure
+func isCallFailure(err error) bool {
+	var rpcErr rpc.Error
+	return errors.As(err, &rpcErr) || errors.Is(err, errUnexpectedOutput)
+}
+
+func (s *serviceImpl) GetToken(ctx context.Context, address string) (*Token, error) {
+	token := &Token{Address: common.HexToAddress(address).Hex()}
+
+	// metadata methods are optional in ERC-20, so a failed call only leaves the field empty
+	var err error
+	if token.Name, err = s.GetTokenName(ctx, token.Address); err != nil && !isCallFailure(err) {
+		return nil, err
+	}
+	if token.Symbol, err = s.GetTokenSymbol(ctx, token.Address); err != nil && !isCallFailure(err) {
+		return nil, err
+	}
+	if token.Decimals, err = s.GetTokenDecimals(ctx, token.Address); err != nil && !isCallFailure(err) {
+		return nil, err
+	}
+	if token.TotalSupply, err = s.GetTokenTotalSupply(ctx, token.Address); err != nil && !isCallFailure(err) {
+		return nil, err
+	}
+	return token, nil
+}
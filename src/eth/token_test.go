@@ -0,0 +1,120 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	tokenAddress = "0xae13d989daC2f0dEbFf460aC112a837C89BAa7cd"
+
+	nameSelector        = "0x06fdde03"
+	symbolSelector      = "0x95d89b41"
+	decimalsSelector    = "0x313ce567"
+	totalSupplySelector = "0x18160ddd"
+)
+
+// pack ABI encodes the output of an ERC-20 metadata method
+func pack(t *testing.T, method string, v interface{}) []byte {
+	t.Helper()
+	out, err := erc20ABI.Methods[method].Outputs.Pack(v)
+	if err != nil {
+		t.Fatalf("failed to pack %s: %v", method, err)
+	}
+	return out
+}
+
+func TestIsERC20Transfer(t *testing.T) {
+	topic := "0x0000000000000000000000001111111111111111111111111111111111111111"
+	tests := []struct {
+		topics Topics
+		want   bool
+	}{
+		{Topics{TransferTopic, topic, topic}, true},
+		{Topics{strings.ToUpper(TransferTopic), topic, topic}, true},
+		// ERC-721 indexes the token ID as well
+		{Topics{TransferTopic, topic, topic, topic}, false},
+		{Topics{"0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925", topic, topic}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsERC20Transfer(&Log{Topics: tt.topics}); got != tt.want {
+			t.Errorf("IsERC20Transfer(%v) = %v, want %v", tt.topics, got, tt.want)
+		}
+	}
+}
+
+func TestUnpackString(t *testing.T) {
+	mkr := common.RightPadBytes([]byte("MKR"), 32)
+	tests := []struct {
+		name string
+		out  []byte
+		want string
+	}{
+		{"string", pack(t, "symbol", "WBNB"), "WBNB"},
+		{"bytes32", mkr, "MKR"},
+		{"empty", nil, ""},
+		{"malformed", append(mkr, 0x01), "MKR"},
+	}
+	for _, tt := range tests {
+		if got := unpackString("symbol", tt.out); got != tt.want {
+			t.Errorf("%s: unpackString = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetToken(t *testing.T) {
+	node := newFakeNode(97)
+	node.setOutput(tokenAddress, nameSelector, pack(t, "name", "Wrapped BNB"))
+	node.setOutput(tokenAddress, symbolSelector, pack(t, "symbol", "WBNB"))
+	node.setOutput(tokenAddress, decimalsSelector, pack(t, "decimals", uint8(18)))
+	supply, _ := new(big.Int).SetString("115792089237316195423570985008687907853269984665640564039457584007913129639935", 10)
+	node.setOutput(tokenAddress, totalSupplySelector, pack(t, "totalSupply", supply))
+	c := newTestClient(t, node)
+
+	token, err := c.GetToken(context.Background(), strings.ToLower(tokenAddress))
+	if err != nil {
+		t.Fatalf("failed to get token: %v", err)
+	}
+	want := Token{Address: tokenAddress, Name: "Wrapped BNB", Symbol: "WBNB", Decimals: 18, TotalSupply: supply.String()}
+	if *token != want {
+		t.Errorf("token = %+v, want %+v", *token, want)
+	}
+}
+
+func TestGetTokenWithoutMetadata(t *testing.T) {
+	node := newFakeNode(97)
+	// decimals declared as uint256 are accepted within uint8
+	node.setOutput(tokenAddress, decimalsSelector, common.LeftPadBytes([]byte{6}, 32))
+	node.setOutput(tokenAddress, totalSupplySelector, hexutil.MustDecode("0x01"))
+	c := newTestClient(t, node)
+
+	// metadata methods are optional, so reverted calls and malformed outputs leave fields empty
+	token, err := c.GetToken(context.Background(), tokenAddress)
+	if err != nil {
+		t.Fatalf("failed to get token: %v", err)
+	}
+	if want := (Token{Address: tokenAddress, Decimals: 6}); *token != want {
+		t.Errorf("token = %+v, want %+v", *token, want)
+	}
+
+	node.setOutput(tokenAddress, decimalsSelector, common.LeftPadBytes([]byte{1, 0}, 32))
+	if _, err := c.GetTokenDecimals(context.Background(), tokenAddress); err == nil || !isCallFailure(err) {
+		t.Errorf("decimals out of range: err = %v", err)
+	}
+}
+
+func TestGetTokenUnavailable(t *testing.T) {
+	c := newTestClient(t, newFakeNode(97))
+	c.delegate.Close()
+
+	// transport failures are not taken as missing metadata
+	if _, err := c.GetToken(context.Background(), tokenAddress); err == nil {
+		t.Error("got token from closed client")
+	}
+}
@@ -0,0 +1,104 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// ErrTracingNotSupported is returned when the node does not serve the debug API
+var ErrTracingNotSupported = errors.New("debug tracing is not supported by the node")
+
+// callFrame defines the output of `callTracer`
+type callFrame struct {
+	Type  string         `json:"type"`
+	From  common.Address `json:"from"`
+	To    common.Address `json:"to"`
+	Value *hexutil.Big   `json:"value"`
+	Error string         `json:"error"`
+	Calls []callFrame    `json:"calls"`
+}
+
+// txTrace defines a transaction trace of `debug_traceBlockByNumber`,
+// in which `txHash` is only set by recent nodes
+type txTrace struct {
+	TxHash *common.Hash `json:"txHash"`
+	Result *callFrame   `json:"result"`
+	Error  string       `json:"error"`
+}
+
+// isMethodNotFound reports whether err means the called method is not served
+func isMethodNotFound(err error) bool {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	if rpcErr.ErrorCode() == -32601 {
+		return true
+	}
+	msg := strings.ToLower(rpcErr.Error())
+	return strings.Contains(msg, "does not exist") || strings.Contains(msg, "not available") ||
+		strings.Contains(msg, "not supported")
+}
+
+// flatten appends nested calls of frame in depth first order
+func flatten(ret []InternalTransaction, txHash string, blockNum uint64, frame *callFrame, depth uint) []InternalTransaction {
+	for i := range frame.Calls {
+		c := &frame.Calls[i]
+		var value Wei
+		if c.Value != nil {
+			value = NewWei(c.Value.ToInt())
+		}
+		to := ""
+		if c.To != (common.Address{}) {
+			to = c.To.Hex()
+		}
+		ret = append(ret, InternalTransaction{
+			TransactionHash: txHash,
+			Index:           uint(len(ret)),
+			BlockNum:        blockNum,
+			Type:            strings.ToUpper(c.Type),
+			From:            c.From.Hex(),
+			To:              to,
+			Value:           value,
+			Depth:           depth,
+			Error:           c.Error,
+		})
+		ret = flatten(ret, txHash, blockNum, c, depth+1)
+	}
+	return ret
+}
+
+func (s *serviceImpl) TraceBlock(ctx context.Context, block *Block) ([]InternalTransaction, error) {
+	var traces []txTrace
+	err := s.rpc.CallContext(ctx, &traces, "debug_traceBlockByNumber",
+		hexutil.EncodeUint64(block.Num), map[string]string{"tracer": "callTracer"})
+	if isMethodNotFound(err) {
+		return nil, ErrTracingNotSupported
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to trace block %d: %v", block.Num, err)
+	}
+	if len(traces) != len(block.Transactions) {
+		return nil, fmt.Errorf("got %d traces for %d transactions of block %d", len(traces), len(block.Transactions), block.Num)
+	}
+
+	var ret []InternalTransaction
+	for i, t := range traces {
+		txHash := block.Transactions[i].Hash
+		if t.TxHash != nil && t.TxHash.Hex() != txHash {
+			return nil, fmt.Errorf("trace of transaction %s mismatches %s", t.TxHash.Hex(), txHash)
+		}
+		if t.Result == nil {
+			return nil, fmt.Errorf("failed to trace transaction %s: %s", txHash, t.Error)
+		}
+
+		internals := flatten(nil, txHash, block.Num, t.Result, 1)
+		ret = append(ret, internals...)
+	}
+	return ret, nil
+}
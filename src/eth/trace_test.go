@@ -0,0 +1,87 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	contractA = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	contractB = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	created   = common.HexToAddress("0x3333333333333333333333333333333333333333")
+)
+
+// callTree is a transaction calling A, which calls B creating a contract, then fails a call to B
+func callTree() *callFrame {
+	return &callFrame{Type: "CALL", To: contractA, Calls: []callFrame{
+		{Type: "CALL", From: contractA, To: contractB, Value: (*hexutil.Big)(big.NewInt(7)), Calls: []callFrame{
+			{Type: "create2", From: contractB, To: created},
+		}},
+		{Type: "STATICCALL", From: contractA, To: contractB, Error: "execution reverted"},
+		{Type: "SELFDESTRUCT", From: contractA},
+	}}
+}
+
+func TestFlatten(t *testing.T) {
+	got := flatten(nil, "0xabc", 5, callTree(), 1)
+	want := []InternalTransaction{
+		{TransactionHash: "0xabc", Index: 0, BlockNum: 5, Type: "CALL", From: contractA.Hex(), To: contractB.Hex(), Value: NewWei(big.NewInt(7)), Depth: 1},
+		{TransactionHash: "0xabc", Index: 1, BlockNum: 5, Type: "CREATE2", From: contractB.Hex(), To: created.Hex(), Depth: 2},
+		{TransactionHash: "0xabc", Index: 2, BlockNum: 5, Type: "STATICCALL", From: contractA.Hex(), To: contractB.Hex(), Depth: 1, Error: "execution reverted"},
+		{TransactionHash: "0xabc", Index: 3, BlockNum: 5, Type: "SELFDESTRUCT", From: contractA.Hex(), Depth: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("flattened %d calls, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("call %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestTraceBlock(t *testing.T) {
+	tx1, tx2 := common.HexToHash("0x01"), common.HexToHash("0x02")
+	block := &Block{Num: 5, Transactions: []Transaction{{Hash: tx1.Hex()}, {Hash: tx2.Hex()}}}
+
+	node := newFakeNode(97)
+	node.traces = map[uint64][]txTrace{
+		// older nodes leave out transaction hashes
+		5: {{TxHash: &tx1, Result: callTree()}, {Result: &callFrame{Type: "CALL"}}},
+		// mismatched hashes, a failed trace and a mismatched # of traces
+		6: {{TxHash: &tx2, Result: callTree()}, {TxHash: &tx1, Result: callTree()}},
+		7: {{TxHash: &tx1, Error: "out of gas"}, {TxHash: &tx2, Result: callTree()}},
+		8: {{TxHash: &tx1, Result: callTree()}},
+	}
+	c := newTestClient(t, node)
+
+	internals, err := c.TraceBlock(context.Background(), block)
+	if err != nil {
+		t.Fatalf("failed to trace block: %v", err)
+	}
+	if len(internals) != 4 || internals[0].TransactionHash != tx1.Hex() {
+		t.Errorf("internal transactions = %+v", internals)
+	}
+
+	for _, n := range []uint64{6, 7, 8} {
+		b := *block
+		b.Num = n
+		if _, err := c.TraceBlock(context.Background(), &b); err == nil {
+			t.Errorf("traced block %d with mismatched traces", n)
+		}
+	}
+}
+
+func TestTraceBlockNotSupported(t *testing.T) {
+	c := newTestClient(t, newFakeNode(97))
+	_, err := c.TraceBlock(context.Background(), &Block{Num: 5})
+	if !errors.Is(err, ErrTracingNotSupported) {
+		t.Errorf("err = %v, want %v", err, ErrTracingNotSupported)
+	}
+}
@@ -0,0 +1,95 @@
+package eth
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Wei is a custom type for gorm storing an amount in wei as DECIMAL(78,0),
+// which holds any uint256 exactly and can be compared, sorted and summed in SQL,
+// or as TEXT on sqlite, which approximates decimals as floating points,
+// while it is encoded in JSON as a decimal string
+type Wei big.Int
+
+// NewWei creates a Wei of v, 0 if v is nil
+func NewWei(v *big.Int) Wei {
+	var w Wei
+	if v != nil {
+		w.Int().Set(v)
+	}
+	return w
+}
+
+// Int returns w as a big.Int sharing its value
+func (w *Wei) Int() *big.Int {
+	return (*big.Int)(w)
+}
+
+func (w Wei) String() string {
+	return w.Int().String()
+}
+
+// Scan implements the Scanner interface
+func (w *Wei) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return w.setString(string(v))
+	case string:
+		return w.setString(v)
+	case int64:
+		w.Int().SetInt64(v)
+		return nil
+	case float64:
+		// sqlite stores integers overflowing int64 as floating points
+		new(big.Float).SetFloat64(v).Int(w.Int())
+		return nil
+	case nil:
+		w.Int().SetInt64(0)
+		return nil
+	}
+	return fmt.Errorf("unsupported type %T for wei", src)
+}
+
+func (w *Wei) setString(s string) error {
+	if _, ok := w.Int().SetString(s, 10); !ok {
+		return fmt.Errorf("bad wei %q", s)
+	}
+	return nil
+}
+
+// Value implements the Valuer interface
+func (w Wei) Value() (driver.Value, error) {
+	return w.String(), nil
+}
+
+// GormDataType implements the GormDataTypeInterface interface
+func (Wei) GormDataType() string {
+	return "decimal(78,0)"
+}
+
+// GormDBDataType implements the GormDBDataTypeInterface interface
+func (w Wei) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == "sqlite" {
+		return "text"
+	}
+	return w.GormDataType()
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (w Wei) MarshalJSON() ([]byte, error) {
+	return json.Marshal(w.String())
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, accepting a decimal string or number
+func (w *Wei) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		s = string(b)
+	}
+	return w.setString(s)
+}
@@ -0,0 +1,54 @@
+package eth
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+)
+
+func TestWeiScan(t *testing.T) {
+	tests := []struct {
+		src  interface{}
+		want string
+	}{
+		{"115792089237316195423570985008687907853269984665640564039457584007913129639935", "115792089237316195423570985008687907853269984665640564039457584007913129639935"},
+		{[]byte("42"), "42"},
+		{int64(7), "7"},
+		{float64(1e20), "100000000000000000000"},
+		{nil, "0"},
+	}
+	for _, tt := range tests {
+		var w Wei
+		if err := w.Scan(tt.src); err != nil {
+			t.Errorf("failed to scan %v: %v", tt.src, err)
+			continue
+		}
+		if w.String() != tt.want {
+			t.Errorf("scanned %v = %s, want %s", tt.src, w.String(), tt.want)
+		}
+	}
+
+	for _, src := range []interface{}{"", "1.5", true} {
+		var w Wei
+		if err := w.Scan(src); err == nil {
+			t.Errorf("scanned bad wei %v", src)
+		}
+	}
+}
+
+func TestWeiJSON(t *testing.T) {
+	w := NewWei(new(big.Int).Exp(big.NewInt(10), big.NewInt(30), nil))
+	b, err := json.Marshal(w)
+	if err != nil || string(b) != `"1000000000000000000000000000000"` {
+		t.Errorf("encoded wei = %s, %v", b, err)
+	}
+	for _, s := range []string{`"1000000000000000000000000000000"`, `1000000000000000000000000000000`} {
+		var got Wei
+		if err := json.Unmarshal([]byte(s), &got); err != nil || got.Int().Cmp(w.Int()) != 0 {
+			t.Errorf("decoded %s = %s, %v", s, got.String(), err)
+		}
+	}
+	if v, err := w.Value(); err != nil || v != w.String() {
+		t.Errorf("value = %v, %v", v, err)
+	}
+}
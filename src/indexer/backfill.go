@@ -0,0 +1,123 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/r04922101/portto/eth"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultBatchBlocks     = 100
+	defaultInsertBatchSize = 1000
+)
+
+// BackfillOptions defines tuning of a historical backfill
+type BackfillOptions struct {
+	// BatchBlocks is the # of blocks fetched and written to DB in a transaction
+	BatchBlocks int
+	// InsertBatchSize is the max # of rows in a multi-row insert
+	InsertBatchSize int
+	// DeferIndexes drops secondary indexes before the backfill, and creates them again afterwards
+	DeferIndexes bool
+}
+
+// rowCount returns the # of rows a block is written as
+func rowCount(block *eth.Block) int {
+	n := 1 + len(block.Transactions)
+	for _, t := range block.Transactions {
+		n += len(t.Logs)
+	}
+	return n
+}
+
+// fetchBlocks gets blocks numbered from `from` to `to` inclusively with workerNum workers
+func (i *impl) fetchBlocks(ctx context.Context, from, to uint64) ([]*eth.Block, error) {
+	blocks := make([]*eth.Block, to-from+1)
+	sem := make(chan struct{}, i.workerNum)
+	eg, gctx := errgroup.WithContext(ctx)
+	for n := from; n <= to; n++ {
+		n := n
+		sem <- struct{}{}
+		eg.Go(func() error {
+			defer func() { <-sem }()
+			start := time.Now()
+			block, err := i.ethClient.GetBlockByNumber(gctx, n)
+			i.observeStage("fetch", start, err)
+			if err != nil {
+				return fmt.Errorf("failed to get block %d: %v", n, err)
+			}
+			blocks[n-from] = block
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+func (i *impl) Backfill(ctx context.Context, from, to uint64, opts BackfillOptions) (err error) {
+	if from > to {
+		return fmt.Errorf("bad backfill range %d-%d", from, to)
+	}
+	if opts.BatchBlocks <= 0 {
+		opts.BatchBlocks = defaultBatchBlocks
+	}
+	if opts.InsertBatchSize <= 0 {
+		opts.InsertBatchSize = defaultInsertBatchSize
+	}
+
+	if opts.DeferIndexes {
+		if err := i.store.DropSecondaryIndexes(ctx); err != nil {
+			return err
+		}
+		// create indexes even if the backfill fails, since queries need them
+		defer func() {
+			if e := i.store.CreateSecondaryIndexes(context.Background()); e != nil && err == nil {
+				err = e
+			}
+		}()
+	}
+
+	var (
+		start = time.Now()
+		rows  int
+	)
+	for n := from; n <= to; n += uint64(opts.BatchBlocks) {
+		end := n + uint64(opts.BatchBlocks) - 1
+		if end > to {
+			end = to
+		}
+		blocks, err := i.fetchBlocks(ctx, n, end)
+		if err != nil {
+			return err
+		}
+
+		batchRows := 0
+		for _, b := range blocks {
+			i.decode(b)
+			batchRows += rowCount(b)
+		}
+		writeStart := time.Now()
+		if err := i.store.WriteBlocks(ctx, blocks, opts.InsertBatchSize); err != nil {
+			return err
+		}
+		elapsed := time.Since(writeStart)
+		i.observeIndexed(blocks...)
+		rows += batchRows
+		i.log.Info("backfill wrote blocks", "from", n, "to", end, "rows", batchRows, "elapsed", elapsed,
+			"rows_per_sec", fmt.Sprintf("%.0f", float64(batchRows)/elapsed.Seconds()))
+
+		for _, b := range blocks {
+			i.runStages(ctx, b)
+		}
+	}
+
+	elapsed := time.Since(start)
+	i.log.Info("backfill finished blocks", "from", from, "to", to, "rows", rows, "elapsed", elapsed,
+		"rows_per_sec", fmt.Sprintf("%.0f", float64(rows)/elapsed.Seconds()))
+	return nil
+}
@@ -0,0 +1,88 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+)
+
+// indexStore records calls dropping and creating secondary indexes of a store
+type indexStore struct {
+	db.Store
+	dropped, created int
+}
+
+func (s *indexStore) DropSecondaryIndexes(ctx context.Context) error {
+	s.dropped++
+	return s.Store.DropSecondaryIndexes(ctx)
+}
+
+func (s *indexStore) CreateSecondaryIndexes(ctx context.Context) error {
+	s.created++
+	return s.Store.CreateSecondaryIndexes(ctx)
+}
+
+func TestRowCount(t *testing.T) {
+	block := newBlock(1, "",
+		eth.Transaction{Hash: hash("tx", 1, ""), Logs: make([]eth.Log, 2)},
+		eth.Transaction{Hash: hash("tx", 2, "")})
+	if n := rowCount(block); n != 5 {
+		t.Errorf("row count = %d, want 5", n)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	client := newChain(1, 24)
+	client.AddBlock(newBlock(25, "", eth.Transaction{Hash: hash("tx", 25, ""), Logs: []eth.Log{{Address: "0x3333333333333333333333333333333333333333"}}}))
+	i, store := newTestIndexer(t, client, Config{WorkerNum: 3})
+	ctx := context.Background()
+
+	// batches end at the last block of the range, which is not a multiple of the batch size
+	if err := i.Backfill(ctx, 3, 25, BackfillOptions{BatchBlocks: 10, InsertBatchSize: 2}); err != nil {
+		t.Fatalf("failed to backfill: %v", err)
+	}
+	for n := uint64(1); n <= 25; n++ {
+		_, err := store.GetBlockByNumber(ctx, n)
+		if indexed := err == nil; indexed != (n >= 3) {
+			t.Errorf("block %d indexed = %v, err = %v", n, indexed, err)
+		}
+	}
+	tx, err := store.GetTransaction(ctx, hash("tx", 25, ""))
+	if err != nil || tx.BlockNum != 25 || len(tx.Logs) != 1 {
+		t.Errorf("transaction = %+v, %v", tx, err)
+	}
+
+	if err := i.Backfill(ctx, 5, 4, BackfillOptions{}); err == nil {
+		t.Error("backfilled a bad range")
+	}
+}
+
+func TestBackfillFailure(t *testing.T) {
+	// block 15 is missing
+	client := newChain(1, 14)
+	client.AddBlock(newBlock(16, ""))
+	i, inner := newTestIndexer(t, client, Config{})
+	store := &indexStore{Store: inner}
+	i.store = store
+	ctx := context.Background()
+
+	err := i.Backfill(ctx, 1, 16, BackfillOptions{BatchBlocks: 10, DeferIndexes: true})
+	if err == nil {
+		t.Fatal("backfilled a missing block")
+	}
+	// indexes are created again although the backfill fails
+	if store.dropped != 1 || store.created != 1 {
+		t.Errorf("indexes dropped %d and created %d times", store.dropped, store.created)
+	}
+	// batches before the failure are kept
+	if n, err := store.ContiguousHead(ctx); err != nil || n != 10 {
+		t.Errorf("contiguous head = %d, %v, want 10", n, err)
+	}
+
+	client.Fail(eth.ErrUnavailable)
+	if err := i.Backfill(ctx, 11, 14, BackfillOptions{}); err == nil {
+		t.Error("backfilled from an unavailable RPC endpoint")
+	}
+}
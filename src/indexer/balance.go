@@ -0,0 +1,60 @@
+package indexer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/r04922101/portto/eth"
+	"golang.org/x/sync/errgroup"
+)
+
+// touchedAddresses returns senders and recipients of transactions in block
+func touchedAddresses(block *eth.Block) []string {
+	seen := map[string]bool{}
+	var ret []string
+	for _, t := range block.Transactions {
+		for _, a := range []string{t.From, t.To} {
+			if a != "" && !seen[a] {
+				seen[a] = true
+				ret = append(ret, a)
+			}
+		}
+	}
+	return ret
+}
+
+// indexBalances stores native balances of addresses touched in block,
+// read at the block number so that they include its value transfers and fees
+func (i *impl) indexBalances(ctx context.Context, block *eth.Block) error {
+	addresses := touchedAddresses(block)
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	var (
+		mu       sync.Mutex
+		balances = make([]eth.Balance, 0, len(addresses))
+		sem      = make(chan struct{}, i.workerNum)
+	)
+	eg, gctx := errgroup.WithContext(ctx)
+	for _, a := range addresses {
+		a := a
+		sem <- struct{}{}
+		eg.Go(func() error {
+			defer func() { <-sem }()
+			b, err := i.ethClient.GetBalance(gctx, a, block.Num)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			balances = append(balances, eth.Balance{Address: a, BlockNum: block.Num, Balance: b})
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	return i.store.SaveBalances(ctx, balances)
+}
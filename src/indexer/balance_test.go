@@ -0,0 +1,58 @@
+package indexer
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/r04922101/portto/eth"
+)
+
+func TestNewRejectsWorkerNum(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		if _, err := New(nil, eth.NewMemoryClient(chainID), Config{WorkerNum: n}); err == nil {
+			t.Errorf("created indexer of %d workers", n)
+		}
+	}
+}
+
+func TestIndexBalances(t *testing.T) {
+	const (
+		alice = "0x1111111111111111111111111111111111111111"
+		bob   = "0x2222222222222222222222222222222222222222"
+		carol = "0x3333333333333333333333333333333333333333"
+	)
+	client := newChain(1, 1)
+	client.SetBalance(alice, eth.NewWei(big.NewInt(100)))
+	client.SetBalance(bob, eth.NewWei(big.NewInt(200)))
+	client.AddBlock(newBlock(2, "",
+		eth.Transaction{Hash: hash("tx", 1, ""), From: alice, To: bob},
+		// contract creations have no recipient
+		eth.Transaction{Hash: hash("tx", 2, ""), From: bob},
+		eth.Transaction{Hash: hash("tx", 3, ""), From: alice, To: carol},
+	))
+	// a worker makes calls one by one
+	i, store := newTestIndexer(t, client, Config{WorkerNum: 1, TrackBalances: true})
+
+	if err := i.IndexBlockByNum(context.Background(), 2); err != nil {
+		t.Fatalf("failed to index block: %v", err)
+	}
+	for address, want := range map[string]int64{alice: 100, bob: 200, carol: 0} {
+		b, err := store.GetBalance(context.Background(), address, 2)
+		if err != nil {
+			t.Errorf("failed to get balance of %s: %v", address, err)
+			continue
+		}
+		if b.BlockNum != 2 || b.Balance.Int().Int64() != want {
+			t.Errorf("balance of %s = %s at block %d, want %d", address, b.Balance, b.BlockNum, want)
+		}
+	}
+
+	if got := touchedAddresses(newBlock(3, "")); len(got) != 0 {
+		t.Errorf("touched addresses of empty block = %v", got)
+	}
+	client.Fail(eth.ErrUnavailable)
+	if err := i.indexBalances(context.Background(), newBlock(3, "", eth.Transaction{From: alice})); err == nil {
+		t.Error("indexed balances from an unavailable RPC endpoint")
+	}
+}
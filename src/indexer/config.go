@@ -2,11 +2,37 @@ package indexer
 
 // Config defines the config for starting an indexer
 type Config struct {
+	SQLDriver   string // mysql, postgres or sqlite
 	SQLHost     string
 	SQLDB       string
 	SQLUser     string
 	SQLPassword string
 	SQLPort     string
+	// ChainID is the ID of the chain served by RPCEndpoint, whose data the indexer writes
+	ChainID     uint64
 	RPCEndpoint string
-	WorkerNum   int
+	// WorkerNum is the # of blocks fetched, or RPC calls of a stage made, at once, which must be positive
+	WorkerNum int
+	// TrackBalances enables the stage storing native balances of addresses touched in each block
+	TrackBalances bool
+	// TraceInternal enables the stage storing internal transactions traced by `debug_traceBlockByNumber`
+	TraceInternal bool
+	// Retention prunes old blocks if set
+	Retention Retention
+}
+
+// Retention defines the policy pruning blocks, which keeps blocks within both limits, ignoring zero ones
+type Retention struct {
+	// Blocks keeps the most recent Blocks blocks
+	Blocks uint64
+	// Days keeps blocks mined in the last Days days
+	Days int
+	// PartitionSize range partitions transactions and logs by every PartitionSize blocks if set, mysql only
+	PartitionSize uint64
+	// ChunkSize is the # of blocks deleted in a DB transaction
+	ChunkSize int
+}
+
+func (r Retention) enabled() bool {
+	return r.Blocks > 0 || r.Days > 0 || r.PartitionSize > 0
 }
@@ -0,0 +1,63 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/r04922101/portto/eth"
+)
+
+// indexContracts stores contracts created in block, by transactions and,
+// if internal transactions are traced, by other contracts
+func (i *impl) indexContracts(ctx context.Context, block *eth.Block) error {
+	var contracts []*eth.Contract
+	for _, t := range block.Transactions {
+		if t.ContractAddress != "" {
+			contracts = append(contracts, &eth.Contract{
+				Address:         t.ContractAddress,
+				Deployer:        t.From,
+				TransactionHash: t.Hash,
+				BlockNum:        block.Num,
+			})
+		}
+	}
+
+	var internals []*eth.InternalTransaction
+	if atomic.LoadInt32(&i.tracingDisabled) == 0 {
+		var err error
+		if internals, err = i.store.ListInternalCreations(ctx, block.Num); err != nil {
+			return err
+		}
+	}
+	for _, t := range internals {
+		contracts = append(contracts, &eth.Contract{
+			Address:         t.To,
+			Deployer:        t.From,
+			TransactionHash: t.TransactionHash,
+			BlockNum:        block.Num,
+		})
+	}
+	if len(contracts) == 0 {
+		return nil
+	}
+
+	deployed := make([]*eth.Contract, 0, len(contracts))
+	for _, c := range contracts {
+		code, err := i.ethClient.GetCode(ctx, c.Address, block.Num)
+		if err != nil {
+			return err
+		}
+		// skip failed deployments
+		if len(code) == 0 {
+			continue
+		}
+		c.BytecodeHash = crypto.Keccak256Hash(code).Hex()
+		if c.Standard, err = eth.DetectStandard(ctx, i.ethClient, c.Address, code); err != nil {
+			return fmt.Errorf("failed to detect standard of contract %s: %v", c.Address, err)
+		}
+		deployed = append(deployed, c)
+	}
+	return i.store.SaveContracts(ctx, deployed)
+}
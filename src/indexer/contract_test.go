@@ -0,0 +1,55 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+)
+
+func TestIndexContracts(t *testing.T) {
+	const (
+		deployer = "0x1111111111111111111111111111111111111111"
+		token    = "0x2222222222222222222222222222222222222222"
+		failed   = "0x3333333333333333333333333333333333333333"
+		child    = "0x4444444444444444444444444444444444444444"
+	)
+	// an ERC-20 dispatcher pushing the selectors of required methods
+	code := hexutil.MustDecode("0x6318160ddd6370a0823163a9059cbb6323b872dd63095ea7b363dd62ed3e")
+	tx1, tx2 := hash("tx", 1, ""), hash("tx", 2, "")
+
+	client := newChain(1, 1)
+	client.SetCode(token, code)
+	client.SetCode(child, []byte{0x00})
+	client.AddBlock(newBlock(2, "",
+		eth.Transaction{Hash: tx1, From: deployer, ContractAddress: token},
+		// deployments failing leave no code
+		eth.Transaction{Hash: tx2, From: deployer, ContractAddress: failed},
+	))
+	client.SetTraces(2, []eth.InternalTransaction{{TransactionHash: tx1, BlockNum: 2, Type: "CREATE2", From: token, To: child, Depth: 1}})
+	i, store := newTestIndexer(t, client, Config{TraceInternal: true})
+
+	if err := i.IndexBlockByNum(context.Background(), 2); err != nil {
+		t.Fatalf("failed to index block: %v", err)
+	}
+	c, err := store.GetContract(context.Background(), token)
+	if err != nil {
+		t.Fatalf("failed to get contract: %v", err)
+	}
+	want := eth.Contract{ChainID: chainID, Address: token, Deployer: deployer, TransactionHash: tx1, BlockNum: 2,
+		BytecodeHash: crypto.Keccak256Hash(code).Hex(), Standard: eth.StandardERC20}
+	if *c != want {
+		t.Errorf("contract = %+v, want %+v", *c, want)
+	}
+	if _, err := store.GetContract(context.Background(), failed); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("failed deployment is stored: %v", err)
+	}
+	// contracts created by contracts are traced
+	if c, err := store.GetContract(context.Background(), child); err != nil || c.Deployer != token || c.Standard != "" {
+		t.Errorf("created contract = %+v, %v", c, err)
+	}
+}
@@ -0,0 +1,77 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/r04922101/portto/logging"
+)
+
+// checkTimeout limits the time of checking readiness or status of each chain
+const checkTimeout = 5 * time.Second
+
+// chainReadiness defines the readiness of a chain in /readyz
+type chainReadiness struct {
+	ChainID uint64  `json:"chain_id"`
+	Ready   bool    `json:"ready"`
+	Checks  []Check `json:"checks"`
+}
+
+// chainStatus defines the status of a chain in /status, with the error failing to get it
+type chainStatus struct {
+	ChainID uint64  `json:"chain_id"`
+	Status  *Status `json:"status,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// HealthHandler serves health of indexers of chains sharing a process:
+//
+//	/healthz  200 as long as the process serves requests
+//	/readyz   checks of every chain, 503 if any chain is not ready
+//	/status   indexing progress of every chain, 503 if any chain fails to report it
+func HealthHandler(indexers ...Indexer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status, ready := http.StatusOK, true
+		chains := make([]chainReadiness, len(indexers))
+		for j, i := range indexers {
+			ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+			checks, ok := i.Ready(ctx)
+			cancel()
+			chains[j] = chainReadiness{ChainID: i.ChainID(), Ready: ok, Checks: checks}
+			if !ok {
+				status, ready = http.StatusServiceUnavailable, false
+			}
+		}
+		writeJSON(w, status, map[string]interface{}{"ready": ready, "chains": chains})
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		chains := make([]chainStatus, len(indexers))
+		for j, i := range indexers {
+			ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+			s, err := i.Status(ctx)
+			cancel()
+			chains[j] = chainStatus{ChainID: i.ChainID(), Status: s}
+			if err != nil {
+				chains[j].Error = err.Error()
+				status = http.StatusServiceUnavailable
+			}
+		}
+		writeJSON(w, status, map[string]interface{}{"chains": chains})
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logging.Root().Warn("failed to write response", "err", err)
+	}
+}
@@ -0,0 +1,148 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+)
+
+// downStore fails to reach its DB
+type downStore struct {
+	db.Store
+}
+
+func (downStore) LatestNum(ctx context.Context) (uint64, error) {
+	return 0, errors.New("connection refused")
+}
+
+// writeChain writes canonical blocks numbered ns to store
+func writeChain(t *testing.T, store db.Store, ns ...uint64) {
+	t.Helper()
+	for _, n := range ns {
+		if err := store.WriteBlock(context.Background(), newBlock(n, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ctx := context.Background()
+	client := newChain(1, 10)
+	i, store := newTestIndexer(t, client, Config{})
+	// block 4 is missing
+	writeChain(t, store, 1, 2, 3, 5, 6)
+	i.lastErr.record(errors.New("boom"))
+
+	s, err := i.Status(ctx)
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if s.ChainID != chainID || s.ChainHead != 10 || s.LatestIndexed != 6 || s.ContiguousHead != 3 || s.LagBlocks != 4 {
+		t.Errorf("status = %+v, want chain %d, head 10, latest 6, contiguous 3 and lag 4", s, chainID)
+	}
+	if s.LagSeconds <= 0 {
+		t.Errorf("lag seconds = %d, want > 0", s.LagSeconds)
+	}
+	if s.LastError != "boom" || s.LastErrorAt == nil {
+		t.Errorf("last error = %q at %v, want boom", s.LastError, s.LastErrorAt)
+	}
+
+	// the progress in DB is reported without the chain head
+	client.Fail(eth.ErrUnavailable)
+	s, err = i.Status(ctx)
+	if err == nil {
+		t.Error("got status from an unavailable RPC endpoint")
+	}
+	if s == nil || s.LatestIndexed != 6 || s.ChainHead != 0 {
+		t.Errorf("status = %+v, want latest 6 without chain head", s)
+	}
+
+	i.store = downStore{store}
+	if s, err := i.Status(ctx); err == nil {
+		t.Errorf("status = %+v, want an error from an unreachable DB", s)
+	}
+}
+
+func TestReady(t *testing.T) {
+	ctx := context.Background()
+	client := newChain(1, 3)
+	i, store := newTestIndexer(t, client, Config{})
+
+	tests := []struct {
+		name   string
+		store  db.Store
+		err    error
+		failed map[string]bool
+	}{
+		{"ready", store, nil, map[string]bool{}},
+		{"db down", downStore{store}, nil, map[string]bool{"db": true, "schema": true}},
+		{"rpc down", store, eth.ErrUnavailable, map[string]bool{"rpc": true}},
+	}
+	for _, tt := range tests {
+		i.store = tt.store
+		client.Fail(tt.err)
+		checks, ready := i.Ready(ctx)
+		if ready != (len(tt.failed) == 0) {
+			t.Errorf("%s: ready = %v, checks = %+v", tt.name, ready, checks)
+		}
+		for _, c := range checks {
+			if c.OK == tt.failed[c.Name] || c.OK != (c.Error == "") {
+				t.Errorf("%s: check %+v, want failed = %v", tt.name, c, tt.failed[c.Name])
+			}
+		}
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	client := newChain(1, 3)
+	i, store := newTestIndexer(t, client, Config{})
+	writeChain(t, store, 1, 2)
+	h := HealthHandler(i)
+
+	get := func(path string, body interface{}) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if err := json.Unmarshal(w.Body.Bytes(), body); err != nil {
+			t.Fatalf("failed to decode %s: %v", path, err)
+		}
+		return w.Code
+	}
+
+	var health map[string]string
+	if code := get("/healthz", &health); code != http.StatusOK || health["status"] != "ok" {
+		t.Errorf("/healthz = %d %v, want 200 ok", code, health)
+	}
+
+	var ready struct {
+		Ready  bool             `json:"ready"`
+		Chains []chainReadiness `json:"chains"`
+	}
+	if code := get("/readyz", &ready); code != http.StatusOK || !ready.Ready || len(ready.Chains) != 1 || ready.Chains[0].ChainID != chainID {
+		t.Errorf("/readyz = %d %+v, want 200 ready", code, ready)
+	}
+	var status struct {
+		Chains []chainStatus `json:"chains"`
+	}
+	if code := get("/status", &status); code != http.StatusOK || len(status.Chains) != 1 || status.Chains[0].Status.LagBlocks != 1 {
+		t.Errorf("/status = %d %+v, want 200 lagging by 1 block", code, status)
+	}
+
+	// every route but /healthz reports an unavailable RPC endpoint
+	client.Fail(eth.ErrUnavailable)
+	if code := get("/healthz", &health); code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200", code)
+	}
+	if code := get("/readyz", &ready); code != http.StatusServiceUnavailable || ready.Ready {
+		t.Errorf("/readyz = %d %+v, want 503 not ready", code, ready)
+	}
+	if code := get("/status", &status); code != http.StatusServiceUnavailable || status.Chains[0].Error == "" || status.Chains[0].Status.LatestIndexed != 2 {
+		t.Errorf("/status = %d %+v, want 503 with an error and the progress in DB", code, status)
+	}
+}
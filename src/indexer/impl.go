@@ -2,15 +2,21 @@ package indexer
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"sync"
+	"time"
 
 	"github.com/r04922101/portto/db"
 	"github.com/r04922101/portto/eth"
+	"github.com/r04922101/portto/logging"
+	"github.com/r04922101/portto/metrics"
+	"github.com/r04922101/portto/registry"
+	"github.com/r04922101/portto/tracing"
 	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
-	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 // Indexer defines an interface, which can index a block into DB
@@ -18,28 +24,66 @@ type Indexer interface {
 	IndexRecentBlocks(ctx context.Context, blockNum uint64) (uint64, error)
 	IndexBlockByNum(ctx context.Context, blockNum uint64) error
 	IndexBlock(block *eth.Block) error
-	CheckTables() error
+	// Backfill indexes historical blocks numbered from `from` to `to` inclusively with bulk inserts
+	Backfill(ctx context.Context, from, to uint64, opts BackfillOptions) error
+	CheckSchema() error
+	// CheckChain checks the RPC endpoint serves the chain of the store, and blocks in DB belong to it
+	CheckChain() error
+	// Cron starts cronjobs indexing recent blocks and, if a retention policy is set, pruning old ones
 	Cron(cronExp string)
+	// Prune deletes blocks out of the retention policy
+	Prune(ctx context.Context) error
+	// Registry returns the ABI registry decoding indexed logs and transaction inputs
+	Registry() registry.Registry
+	// ChainID returns the ID of the indexed chain
+	ChainID() uint64
+	// Status returns the indexing progress of the chain compared with the RPC endpoint,
+	// along with an error of the RPC endpoint if the progress is reported without the chain head
+	Status(ctx context.Context) (*Status, error)
+	// Ready checks the DB is reachable and migrated, and the RPC endpoint is reachable
+	Ready(ctx context.Context) ([]Check, bool)
 }
 
 type impl struct {
-	db        *gorm.DB
+	store     db.Store
 	ethClient eth.Client
 	workerNum int
+	registry  registry.Registry
+	stages    []stage
+	retention Retention
+	// tracingDisabled is set if tracing is not enabled, or once the RPC endpoint turns out not to support it
+	tracingDisabled int32
+	// tokens caches addresses of tokens already stored in DB
+	tokens sync.Map
+	// lastErr is the last error of indexing recent blocks or pruning, reported by Status
+	lastErr lastError
+	// chain labels metrics, which track the current block number and the largest indexed one
+	chain        string
+	head, latest uint64
+	// log has the chain ID as a field
+	log logging.Logger
 }
 
 func (i *impl) IndexRecentBlocks(ctx context.Context, blockNum uint64) (uint64, error) {
+	ctx, span := tracing.Start(ctx, "indexer.IndexRecentBlocks", attribute.String("chain.id", i.chain))
+	n, err := i.indexRecentBlocks(ctx, blockNum)
+	tracing.End(span, err)
+	return n, i.lastErr.record(err)
+}
+
+func (i *impl) indexRecentBlocks(ctx context.Context, blockNum uint64) (uint64, error) {
 	targetNum, err := i.ethClient.GetCurrentNumber(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get current block number: %v", err)
 	}
+	i.observeHead(targetNum)
 
 	// index blocks until the most recent one
 	if blockNum == 0 {
 		blockNum = targetNum
 	}
 	for blockNum <= targetNum {
-		eg, gctx := errgroup.WithContext(context.Background())
+		eg, gctx := errgroup.WithContext(ctx)
 		// dispatch workload to workers
 		for w := 0; w < i.workerNum; w++ {
 			n := blockNum
@@ -65,6 +109,7 @@ func (i *impl) IndexRecentBlocks(ctx context.Context, blockNum uint64) (uint64,
 			if err != nil {
 				return 0, fmt.Errorf("failed to get current block number: %v", err)
 			}
+			i.observeHead(targetNum)
 		}
 	}
 	return targetNum, nil
@@ -72,75 +117,195 @@ func (i *impl) IndexRecentBlocks(ctx context.Context, blockNum uint64) (uint64,
 
 // Cron starts a cronjob to index recent blocks every time period
 func (i *impl) Cron(cronExp string) {
-	c := cron.New()
+	logger := cronLogger{i.log.New("job", "cronjob")}
+	c := cron.New(cron.WithLogger(logger))
 	c.AddFunc(cronExp, func() {
-		n, err := db.GetLatestNumFromDB(i.db)
-		if err != nil {
-			log.Printf("[cronjob] failed to get latest num from db: %v", err)
+		n, err := i.store.LatestNum(context.Background())
+		if err := i.lastErr.record(err); err != nil {
+			logger.Error(err, "failed to get latest block number from DB")
 		}
 		start := n + 1
 		if n == 0 {
 			curNum, err := i.ethClient.GetCurrentNumber(context.Background())
-			if err != nil {
+			if err := i.lastErr.record(err); err != nil {
+				logger.Error(err, "failed to get current block number")
 				return
 			}
 			start = curNum
 		}
 		if until, err := i.IndexRecentBlocks(context.Background(), start); err != nil {
-			log.Printf("[cronjob] failed to index recent blocks to db: %v", err)
+			logger.Error(err, "failed to index recent blocks", "from", start)
 		} else {
-			log.Printf("[cronjob] indexed blocks %d-%d to db", start, until)
+			logger.log.Info("indexed recent blocks", "from", start, "to", until)
 		}
 	})
+	if i.retention.enabled() {
+		pruner := cronLogger{i.log.New("job", "pruner")}
+		// a slow pruning skips the next runs rather than overlapping them
+		prune := cron.NewChain(cron.SkipIfStillRunning(pruner)).Then(cron.FuncJob(func() {
+			if err := i.lastErr.record(i.Prune(context.Background())); err != nil {
+				pruner.Error(err, "failed to prune blocks")
+			}
+		}))
+		c.AddJob(cronExp, prune)
+	}
 	c.Start()
 }
 
+// cronLogger writes logs of cron, whose info ones are noisy, at debug level
+type cronLogger struct {
+	log logging.Logger
+}
+
+func (l cronLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log.Debug(msg, keysAndValues...)
+}
+
+func (l cronLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.log.Error(msg, append(keysAndValues, "err", err)...)
+}
+
 // IndexBlockByNum inserts a block with blockNum to DB
-func (i *impl) IndexBlockByNum(ctx context.Context, blockNum uint64) error {
+func (i *impl) IndexBlockByNum(ctx context.Context, blockNum uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "indexer.IndexBlockByNum",
+		attribute.String("chain.id", i.chain), attribute.Int64("block.number", int64(blockNum)))
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
 	block, err := i.ethClient.GetBlockByNumber(ctx, blockNum)
+	i.observeStage("fetch", start, err)
 	if err != nil {
 		return fmt.Errorf("failed to get block %d: %v", blockNum, err)
 	}
-	return i.IndexBlock(block)
+	return i.indexBlock(ctx, block)
 }
 
 // IndexBlock inserts a block to DB
 func (i *impl) IndexBlock(block *eth.Block) error {
-	if err := i.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(block).Error; err != nil {
-		return fmt.Errorf("failed to insert block to DB: %v", err)
+	return i.indexBlock(context.Background(), block)
+}
+
+// indexBlock inserts a block to DB, tracing the steps in the span of ctx
+func (i *impl) indexBlock(ctx context.Context, block *eth.Block) error {
+	// a fetched block is written even if ctx is canceled by a failure of another block
+	ctx = trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+	i.decode(block)
+
+	start := time.Now()
+	err := i.store.WriteBlock(ctx, block)
+	i.observeStage("write", start, err)
+	if err != nil {
+		return err
 	}
+	i.observeIndexed(block)
+
+	i.runStages(ctx, block)
 
 	return nil
 }
 
-func (i *impl) CheckTables() error {
-	if err := i.db.AutoMigrate(&eth.Block{}); err != nil {
-		return fmt.Errorf("failed to check `blocks` table exists: %v", err)
+// decode decodes transaction inputs and logs of block with registered ABIs
+func (i *impl) decode(block *eth.Block) {
+	defer i.observeStage("decode", time.Now(), nil)
+	for j := range block.Transactions {
+		t := &block.Transactions[j]
+		if err := i.registry.DecodeInput(t); err != nil {
+			i.log.Warn("failed to decode input", "block", block.Num, "tx", t.Hash, "err", err)
+		}
+		for k := range t.Logs {
+			if err := i.registry.DecodeLog(&t.Logs[k]); err != nil {
+				i.log.Warn("failed to decode log", "block", block.Num, "tx", t.Hash, "log", t.Logs[k].Index, "err", err)
+			}
+		}
 	}
-	if err := i.db.AutoMigrate(&eth.Transaction{}); err != nil {
-		return fmt.Errorf("failed to check `transactions` table exists: %v", err)
+}
+
+// CheckSchema checks DB is migrated to the schema version the indexer expects
+func (i *impl) CheckSchema() error {
+	return i.store.CheckSchema(context.Background())
+}
+
+func (i *impl) CheckChain() error {
+	ctx := context.Background()
+	chain := i.ethClient.Chain()
+	if chain.ID != i.store.ChainID() {
+		return fmt.Errorf("RPC endpoint serves chain %d (%s), not the configured chain %d", chain.ID, chain.Name, i.store.ChainID())
+	}
+
+	// the earliest block is final, so its hash differs only if it was indexed from another chain of the same ID
+	earliest, err := i.store.EarliestNum(ctx)
+	if err != nil {
+		return err
 	}
-	if err := i.db.AutoMigrate(&eth.Log{}); err != nil {
-		return fmt.Errorf("failed to check `logs` table exists: %v", err)
+	block, err := i.store.GetBlockByNumber(ctx, earliest)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	h, err := i.ethClient.GetBlockHash(ctx, earliest)
+	if err != nil {
+		return err
+	}
+	if h != block.Hash {
+		return fmt.Errorf("DB was indexed for a different chain, whose block %d is %s instead of %s of chain %d (%s)",
+			earliest, block.Hash, h, chain.ID, chain.Name)
 	}
 	return nil
 }
 
-// NewIndexer creates an indexer
+func (i *impl) ChainID() uint64 {
+	return i.store.ChainID()
+}
+
+func (i *impl) Registry() registry.Registry {
+	return i.registry
+}
+
+// NewIndexer creates an indexer connecting to the SQL DB and RPC endpoint in config
 func NewIndexer(config Config) (Indexer, error) {
-	gdb, err := db.InitDB(config.SQLHost, config.SQLDB, config.SQLPort, config.SQLUser, config.SQLPassword)
+	gdb, err := db.InitDB(config.SQLDriver, config.SQLHost, config.SQLDB, config.SQLPort, config.SQLUser, config.SQLPassword)
 	if err != nil {
-		log.Fatalf("failed to connect to sql DB: %v", err)
+		return nil, fmt.Errorf("failed to connect to sql DB: %v", err)
 	}
 
 	ethClient, err := eth.NewClient(config.RPCEndpoint)
 	if err != nil {
-		log.Fatalf("failed to new eth client with endpoint %s: %v", config.RPCEndpoint, err)
+		return nil, fmt.Errorf("failed to new eth client with endpoint %s: %v", config.RPCEndpoint, err)
+	}
+
+	return New(db.NewSQLStore(gdb, config.ChainID), ethClient, config)
+}
+
+// New creates an indexer writing to store of the chain served by ethClient, ignoring connection settings in config
+func New(store db.Store, ethClient eth.Client, config Config) (Indexer, error) {
+	if config.WorkerNum <= 0 {
+		return nil, fmt.Errorf("worker # must be positive, got %d", config.WorkerNum)
+	}
+	reg, err := registry.New(store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to new ABI registry: %v", err)
 	}
 
-	return &impl{
-		db:        gdb,
+	i := &impl{
+		store:     store,
 		ethClient: ethClient,
 		workerNum: config.WorkerNum,
-	}, nil
+		registry:  reg,
+		retention: config.Retention,
+		chain:     metrics.Chain(store.ChainID()),
+		log:       logging.New("chain", store.ChainID()),
+	}
+	i.stages = []stage{{name: "tokens", run: i.indexTokens}}
+	if config.TrackBalances {
+		i.stages = append(i.stages, stage{name: "balances", run: i.indexBalances})
+	}
+	if config.TraceInternal {
+		i.stages = append(i.stages, stage{name: "tracer", run: i.indexInternalTransactions})
+	} else {
+		i.tracingDisabled = 1
+	}
+	// run after the tracer to include contracts created by contracts
+	i.stages = append(i.stages, stage{name: "contracts", run: i.indexContracts})
+	return i, nil
 }
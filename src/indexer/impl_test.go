@@ -0,0 +1,124 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+)
+
+const chainID = 97
+
+// hash returns a distinct hash in hex of a block or transaction, e.g. hash("block", 1, "")
+func hash(kind string, n uint64, fork string) string {
+	return crypto.Keccak256Hash([]byte(fmt.Sprintf("%s/%d/%s", kind, n, fork))).Hex()
+}
+
+// newBlock creates the block numbered n of fork, an empty string for the canonical one, with txs
+func newBlock(n uint64, fork string, txs ...eth.Transaction) *eth.Block {
+	return &eth.Block{
+		Num:          n,
+		Hash:         hash("block", n, fork),
+		ParentHash:   hash("block", n-1, fork),
+		Time:         1700000000 + 3*n,
+		Transactions: txs,
+	}
+}
+
+// newChain adds canonical blocks numbered from `from` to `to` without transactions to a client
+func newChain(from, to uint64) *eth.MemoryClient {
+	client := eth.NewMemoryClient(chainID)
+	for n := from; n <= to; n++ {
+		client.AddBlock(newBlock(n, ""))
+	}
+	return client
+}
+
+// newTestIndexer creates an indexer of client writing to a memory store with config
+func newTestIndexer(t *testing.T, client eth.Client, config Config) (*impl, db.Store) {
+	t.Helper()
+	if config.WorkerNum == 0 {
+		config.WorkerNum = 4
+	}
+	store := db.NewMemoryStore(chainID)
+	i, err := New(store, client, config)
+	if err != nil {
+		t.Fatalf("failed to create indexer: %v", err)
+	}
+	return i.(*impl), store
+}
+
+func TestIndexRecentBlocks(t *testing.T) {
+	client := newChain(1, 10)
+	i, store := newTestIndexer(t, client, Config{})
+
+	head, err := i.IndexRecentBlocks(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("failed to index recent blocks: %v", err)
+	}
+	if head != 10 {
+		t.Errorf("head = %d, want 10", head)
+	}
+	for n := uint64(1); n <= 10; n++ {
+		_, err := store.GetBlockByNumber(context.Background(), n)
+		if indexed := err == nil; indexed != (n >= 3) {
+			t.Errorf("block %d indexed = %v, err = %v", n, indexed, err)
+		}
+	}
+
+	client.Fail(eth.ErrUnavailable)
+	if _, err := i.IndexRecentBlocks(context.Background(), 11); err == nil {
+		t.Error("indexed blocks from an unavailable RPC endpoint")
+	}
+}
+
+func TestCheckChain(t *testing.T) {
+	ctx := context.Background()
+	client := newChain(1, 5)
+	i, store := newTestIndexer(t, client, Config{})
+	// an empty DB belongs to any chain of the ID
+	if err := i.CheckChain(); err != nil {
+		t.Errorf("empty DB fails the chain check: %v", err)
+	}
+	if err := i.IndexBlockByNum(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.CheckChain(); err != nil {
+		t.Errorf("indexed DB fails the chain check: %v", err)
+	}
+
+	// the RPC endpoint serves another chain of the same ID
+	other := eth.NewMemoryClient(chainID)
+	other.AddBlock(newBlock(2, "other"))
+	j, err := New(store, other, Config{WorkerNum: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.CheckChain(); err == nil {
+		t.Error("DB indexed from another chain passes the chain check")
+	}
+
+	// the RPC endpoint serves a chain of another ID
+	k, err := New(store, eth.NewMemoryClient(56), Config{WorkerNum: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.CheckChain(); err == nil {
+		t.Error("RPC endpoint of another chain passes the chain check")
+	}
+
+	client.Fail(eth.ErrUnavailable)
+	if err := i.CheckChain(); err == nil {
+		t.Error("unavailable RPC endpoint passes the chain check")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	// a bad config fails New rather than the process
+	if _, err := New(db.NewMemoryStore(chainID), newChain(1, 1), Config{WorkerNum: 0}); err == nil {
+		t.Error("created an indexer without workers")
+	}
+}
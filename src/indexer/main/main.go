@@ -3,59 +3,223 @@ package main
 import (
 	"context"
 	"flag"
-	"log"
-	"runtime"
+	"net/http"
+	"os"
 
+	"github.com/r04922101/portto/cache"
+	"github.com/r04922101/portto/config"
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
 	"github.com/r04922101/portto/indexer"
+	"github.com/r04922101/portto/logging"
+	"github.com/r04922101/portto/metrics"
+	"github.com/r04922101/portto/tracing"
+	"gorm.io/gorm"
 )
 
 const (
-	defaultEndpoint           = "https://data-seed-prebsc-2-s3.binance.org:8545/"
 	defaultBlockNumber uint64 = 0
 )
 
 var (
-	sqlHost     = flag.String("sqlHost", "localhost", "sql host")
-	sqlDB       = flag.String("sqlDB", "portto", "sql database name")
-	sqlUser     = flag.String("sqlUser", "root", "sql user")
-	sqlPassword = flag.String("sqlPassword", "portto", "sql user password")
-	sqlPort     = flag.String("sqlPort", "3306", "sql port")
-	rpcEndpoint = flag.String("rpcEndpoint", defaultEndpoint, "rpc endpoint")
+	chainID     = flag.Uint64("chain", 0, "ID of the configured chain to index, all chains if 0")
 	blockNumber = flag.Uint64("blockNumber", defaultBlockNumber, "starting block number")
-	workerNum   = flag.Int("worker", runtime.NumCPU(), "# of worker")
+
+	backfillTo   = flag.Uint64("backfillTo", 0, "bulk insert blocks from blockNumber to this block number and exit")
+	batchBlocks  = flag.Int("batchBlocks", 100, "# of blocks written in a DB transaction when backfilling")
+	insertBatch  = flag.Int("insertBatch", 1000, "max # of rows in a multi-row insert when backfilling")
+	deferIndexes = flag.Bool("deferIndexes", false, "drop secondary indexes while backfilling and create them afterwards")
+
+	daemon = flag.Bool("daemon", false, "keep indexing recent blocks every minute, serving health, status and metrics on the listen address")
 )
 
-func init() {
-	flag.Parse()
+func main() {
+	cfg := config.Default()
+	if err := config.Load(cfg, flag.CommandLine, os.Args[1:]); err != nil {
+		logging.Root().Crit("failed to load config", "err", err)
+	}
+	if flag.Arg(0) == "config" {
+		if err := config.RunCommand(cfg, flag.Args()[1:]); err != nil {
+			logging.Root().Crit("failed to run config command", "err", err)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		logging.Root().Crit("invalid config", "err", err)
+	}
+	if err := logging.Setup(cfg.Log.Level, cfg.Log.Format); err != nil {
+		logging.Root().Crit("failed to set up logging", "err", err)
+	}
+
+	shutdown, err := tracing.Init(context.Background(), "portto-indexer", tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		logging.Root().Crit("failed to set up tracing", "err", err)
+	}
+	// flush pending spans unless the process exits by a critical log
+	defer shutdown(context.Background())
+
+	if flag.Arg(0) == "migrate" {
+		gdb, err := db.InitDB(cfg.SQL.Driver, cfg.SQL.Host, cfg.SQL.DB, cfg.SQL.Port, cfg.SQL.User, cfg.SQL.Password.Reveal())
+		if err != nil {
+			logging.Root().Crit("failed to connect to sql DB", "err", err)
+		}
+		if err := db.RunMigrateCommand(gdb, flag.Args()[1:], db.LegacyChainOptions(cfg.ChainID, cfg.RPCEndpoint)); err != nil {
+			logging.Root().Crit("failed to migrate", "err", err)
+		}
+		return
+	}
+
+	var chains []config.Chain
+	for _, c := range cfg.AllChains() {
+		if *chainID == 0 || c.ID == *chainID {
+			chains = append(chains, c)
+		}
+	}
+	if len(chains) == 0 {
+		logging.Root().Crit("chain is not configured", "chain", *chainID)
+	}
+	if len(chains) > 1 && (*blockNumber > 0 || *backfillTo > 0) {
+		logging.Root().Crit("blockNumber and backfillTo require a chain given by --chain")
+	}
+	if *daemon && *backfillTo > 0 {
+		logging.Root().Crit("backfillTo cannot be run as a daemon")
+	}
+
+	gdb, err := db.InitDB(cfg.SQL.Driver, cfg.SQL.Host, cfg.SQL.DB, cfg.SQL.Port, cfg.SQL.User, cfg.SQL.Password.Reveal())
+	if err != nil {
+		logging.Root().Crit("failed to connect to sql DB", "err", err)
+	}
+	// responses cached by api servers in the shared cache are invalidated on reorgs
+	shared, err := cache.Open(context.Background(), cache.Config{
+		Redis:         cfg.Cache.Redis,
+		RedisPassword: cfg.Cache.RedisPassword.Reveal(),
+		RedisDB:       cfg.Cache.RedisDB,
+	})
+	if err != nil {
+		logging.Root().Crit("failed to open cache", "err", err)
+	}
+	if *daemon {
+		runDaemon(cfg, chains, gdb, shared)
+		return
+	}
+	// chains are indexed one after another, sharing the DB
+	for _, c := range chains {
+		indexChain(c, newIndexer(cfg, c, gdb, shared))
+	}
 }
 
-func main() {
-	config := indexer.Config{
-		SQLHost:     *sqlHost,
-		SQLDB:       *sqlDB,
-		SQLUser:     *sqlUser,
-		SQLPassword: *sqlPassword,
-		SQLPort:     *sqlPort,
-		RPCEndpoint: *rpcEndpoint,
-		WorkerNum:   *workerNum,
+// runDaemon indexes recent blocks of chains side by side every minute, serving their health and metrics until the process exits
+func runDaemon(cfg *config.Config, chains []config.Chain, gdb *gorm.DB, shared cache.Cache) {
+	indexers := make([]indexer.Indexer, len(chains))
+	for j, c := range chains {
+		indexers[j] = newIndexer(cfg, c, gdb, shared)
+	}
+	for j, i := range indexers {
+		// cronjobs continue from the latest indexed block, while blocks from blockNumber are indexed in background
+		if *blockNumber > 0 {
+			go func(chainID uint64, i indexer.Indexer) {
+				ret, err := i.IndexRecentBlocks(context.Background(), *blockNumber)
+				if err != nil {
+					logging.Root().Error("failed to index recent blocks", "chain", chainID, "from", *blockNumber, "err", err)
+					return
+				}
+				logging.Root().Info("finished indexing blocks", "chain", chainID, "from", *blockNumber, "to", ret)
+			}(chains[j].ID, i)
+		}
+		i.Cron("@every 1m")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", indexer.HealthHandler(indexers...))
+	mux.Handle("/metrics", metrics.Handler())
+	logging.Root().Info("serving health, status and metrics", "listen", cfg.Indexer.Listen)
+	if err := http.ListenAndServe(cfg.Indexer.Listen, mux); err != nil {
+		logging.Root().Crit("failed to serve health, status and metrics", "err", err)
+	}
+}
+
+// newIndexer creates the indexer of a chain, checking the DB and RPC endpoint serve it,
+// which invalidates responses of reorganized blocks in shared if it is not nil
+func newIndexer(cfg *config.Config, chain config.Chain, gdb *gorm.DB, shared cache.Cache) indexer.Indexer {
+	indexerConfig := indexer.Config{
+		ChainID:   chain.ID,
+		WorkerNum: cfg.Indexer.Workers,
+
+		TrackBalances: cfg.Indexer.Balances,
+		TraceInternal: cfg.Indexer.Trace,
+
+		Retention: indexer.Retention{
+			Blocks:        cfg.Indexer.Retention.Blocks,
+			Days:          cfg.Indexer.Retention.Days,
+			PartitionSize: cfg.Indexer.Retention.PartitionSize,
+			ChunkSize:     cfg.Indexer.Retention.PruneChunk,
+		},
 	}
 
-	indexer, err := indexer.NewIndexer(config)
+	ethClient, err := eth.NewClient(chain.RPCEndpoint)
+	if err != nil {
+		logging.Root().Crit("failed to new eth client", "chain", chain.ID, "endpoint", chain.RPCEndpoint, "err", err)
+	}
+	store := db.NewSQLStore(gdb, chain.ID)
+	if shared != nil {
+		store = cache.NewInvalidatingStore(store, shared)
+	}
+	indexer, err := indexer.New(store, ethClient, indexerConfig)
 	if err != nil {
-		log.Fatalf("failed to new indexer: %v", err)
+		logging.Root().Crit("failed to new indexer", "chain", chain.ID, "err", err)
 	}
 
-	if err := indexer.CheckTables(); err != nil {
-		log.Fatalf("failed to check required tables exist: %v", err)
+	if err := indexer.CheckSchema(); err != nil {
+		logging.Root().Crit("failed to check schema", "chain", chain.ID, "err", err)
+	}
+	if err := indexer.CheckChain(); err != nil {
+		logging.Root().Crit("failed to check chain", "chain", chain.ID, "err", err)
+	}
+
+	if cfg.Indexer.ABIDir != "" {
+		if err := indexer.Registry().LoadDir(cfg.Indexer.ABIDir); err != nil {
+			logging.Root().Crit("failed to register ABIs", "chain", chain.ID, "err", err)
+		}
+	}
+	if cfg.Indexer.Signatures != "" {
+		if err := indexer.Registry().LoadSignatures(cfg.Indexer.Signatures); err != nil {
+			logging.Root().Crit("failed to load signatures", "chain", chain.ID, "err", err)
+		}
+	}
+	return indexer
+}
+
+// indexChain indexes blocks of a chain as the flags tell
+func indexChain(chain config.Chain, i indexer.Indexer) {
+	backfillOpts := indexer.BackfillOptions{
+		BatchBlocks:     *batchBlocks,
+		InsertBatchSize: *insertBatch,
+		DeferIndexes:    *deferIndexes,
+	}
+
+	if *backfillTo > 0 {
+		if err := i.Backfill(context.Background(), *blockNumber, *backfillTo, backfillOpts); err != nil {
+			logging.Root().Crit("failed to backfill blocks", "chain", chain.ID, "err", err)
+		}
+		return
 	}
 
 	if *blockNumber > 0 {
-		log.Printf("start to index blocks from block number %d", *blockNumber)
+		logging.Root().Info("start to index blocks", "chain", chain.ID, "from", *blockNumber)
 	}
-	ret, err := indexer.IndexRecentBlocks(context.Background(), *blockNumber)
+	ret, err := i.IndexRecentBlocks(context.Background(), *blockNumber)
 	if err != nil {
-		log.Fatalf("failed to index recent blocks: %v", err)
+		logging.Root().Crit("failed to index recent blocks", "chain", chain.ID, "err", err)
 	}
 
-	log.Printf("finish indexing blocks until block #%d", ret)
+	logging.Root().Info("finished indexing blocks", "chain", chain.ID, "to", ret)
+
+	if err := i.Prune(context.Background()); err != nil {
+		logging.Root().Crit("failed to prune blocks", "chain", chain.ID, "err", err)
+	}
 }
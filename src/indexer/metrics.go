@@ -0,0 +1,52 @@
+package indexer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/r04922101/portto/eth"
+	"github.com/r04922101/portto/metrics"
+)
+
+// observeStage records the duration of a step indexing a block started at start, which failed if err is not nil
+func (i *impl) observeStage(stage string, start time.Time, err error) {
+	metrics.StageDuration.WithLabelValues(i.chain, stage).Observe(metrics.Since(start))
+	if err != nil {
+		metrics.StageErrors.WithLabelValues(i.chain, stage).Inc()
+	}
+}
+
+// observeHead records the current block number of the RPC endpoint
+func (i *impl) observeHead(n uint64) {
+	atomic.StoreUint64(&i.head, n)
+	metrics.ChainHead.WithLabelValues(i.chain).Set(float64(n))
+	i.observeLag()
+}
+
+// observeIndexed records blocks written to DB, of which the largest number is kept unless it is smaller
+func (i *impl) observeIndexed(blocks ...*eth.Block) {
+	metrics.IndexedBlocks.WithLabelValues(i.chain).Add(float64(len(blocks)))
+	for _, b := range blocks {
+		for {
+			latest := atomic.LoadUint64(&i.latest)
+			if b.Num <= latest || atomic.CompareAndSwapUint64(&i.latest, latest, b.Num) {
+				break
+			}
+		}
+	}
+	metrics.LatestIndexed.WithLabelValues(i.chain).Set(float64(atomic.LoadUint64(&i.latest)))
+	i.observeLag()
+}
+
+func (i *impl) observeLag() {
+	head, latest := atomic.LoadUint64(&i.head), atomic.LoadUint64(&i.latest)
+	// the lag is unknown until both are observed
+	if head == 0 || latest == 0 {
+		return
+	}
+	lag := uint64(0)
+	if head > latest {
+		lag = head - latest
+	}
+	metrics.LagBlocks.WithLabelValues(i.chain).Set(float64(lag))
+}
@@ -0,0 +1,54 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/r04922101/portto/metrics"
+)
+
+func TestIndexingMetrics(t *testing.T) {
+	client := newChain(1, 10)
+	i, _ := newTestIndexer(t, client, Config{})
+	chain := metrics.Chain(chainID)
+	indexed := metrics.IndexedBlocks.WithLabelValues(chain)
+	fetchErrors := metrics.StageErrors.WithLabelValues(chain, "fetch")
+	before, errsBefore := testutil.ToFloat64(indexed), testutil.ToFloat64(fetchErrors)
+
+	if _, err := i.IndexRecentBlocks(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(indexed) - before; got != 8 {
+		t.Errorf("indexed blocks = %v, want 8", got)
+	}
+	gauges := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"chain head", testutil.ToFloat64(metrics.ChainHead.WithLabelValues(chain)), 10},
+		{"latest indexed", testutil.ToFloat64(metrics.LatestIndexed.WithLabelValues(chain)), 10},
+		{"lag", testutil.ToFloat64(metrics.LagBlocks.WithLabelValues(chain)), 0},
+	}
+	for _, g := range gauges {
+		if g.got != g.want {
+			t.Errorf("%s = %v, want %v", g.name, g.got, g.want)
+		}
+	}
+
+	// the lag grows as the chain moves ahead of indexed blocks
+	i.observeHead(15)
+	if got := testutil.ToFloat64(metrics.LagBlocks.WithLabelValues(chain)); got != 5 {
+		t.Errorf("lag = %v, want 5", got)
+	}
+	if err := i.IndexBlockByNum(context.Background(), 16); err == nil {
+		t.Error("indexed a block not on chain")
+	}
+	if got := testutil.ToFloat64(fetchErrors) - errsBefore; got != 1 {
+		t.Errorf("fetch errors = %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(metrics.StageDuration); got < 3 {
+		t.Errorf("# of stage duration series = %d, want fetch, decode and write at least", got)
+	}
+}
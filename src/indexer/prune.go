@@ -0,0 +1,57 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/r04922101/portto/db"
+)
+
+const defaultPruneChunk = 1000
+
+// cutoff returns the smallest block number to keep by the retention policy, 0 to keep all
+func (i *impl) cutoff(ctx context.Context) (uint64, error) {
+	latest, err := i.store.LatestNum(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var before uint64
+	if r := i.retention.Blocks; r > 0 && latest >= r {
+		before = latest - r + 1
+	}
+	if i.retention.Days > 0 {
+		since := time.Now().AddDate(0, 0, -i.retention.Days).Unix()
+		n, err := i.store.FirstNumSince(ctx, uint64(since))
+		if errors.Is(err, db.ErrNotFound) {
+			// keep the latest block, which indexing resumes from
+			n = latest
+		} else if err != nil {
+			return 0, err
+		}
+		if n > before {
+			before = n
+		}
+	}
+	return before, nil
+}
+
+// Prune partitions tables if configured, and deletes blocks out of the retention policy
+func (i *impl) Prune(ctx context.Context) error {
+	if i.retention.PartitionSize > 0 {
+		if err := i.store.Partition(ctx, i.retention.PartitionSize); err != nil {
+			return err
+		}
+	}
+
+	before, err := i.cutoff(ctx)
+	if err != nil || before == 0 {
+		return err
+	}
+	chunk := i.retention.ChunkSize
+	if chunk <= 0 {
+		chunk = defaultPruneChunk
+	}
+	return i.store.Prune(ctx, before, chunk)
+}
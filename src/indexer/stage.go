@@ -0,0 +1,27 @@
+package indexer
+
+import (
+	"context"
+	"time"
+
+	"github.com/r04922101/portto/eth"
+)
+
+// stage defines a step run on every block after it is inserted to DB
+type stage struct {
+	name string
+	run  func(ctx context.Context, block *eth.Block) error
+}
+
+// runStages runs stages on block in order,
+// a failed stage is logged without failing the block or skipping later stages
+func (i *impl) runStages(ctx context.Context, block *eth.Block) {
+	for _, s := range i.stages {
+		start := time.Now()
+		err := s.run(ctx, block)
+		i.observeStage(s.name, start, err)
+		if err != nil {
+			i.log.Error("stage failed to process block", "stage", s.name, "block", block.Num, "err", err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/r04922101/portto/db"
+)
+
+// Status defines the indexing progress of a chain
+type Status struct {
+	ChainID uint64 `json:"chain_id"`
+	// ChainHead is the current block number of the RPC endpoint
+	ChainHead uint64 `json:"chain_head"`
+	// LatestIndexed is the largest indexed block number, and ContiguousHead the one up to which there is no gap
+	LatestIndexed  uint64 `json:"latest_indexed_block"`
+	ContiguousHead uint64 `json:"contiguous_indexed_head"`
+	// LagBlocks is the # of blocks the latest indexed block is behind the chain head,
+	// and LagSeconds the time passed since the latest indexed block was mined
+	LagBlocks  uint64 `json:"lag_blocks"`
+	LagSeconds int64  `json:"lag_seconds"`
+	// LastError is the last error of indexing recent blocks or pruning, which occurred at LastErrorAt
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Check defines the result of checking a dependency of an indexer
+type Check struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// lastError keeps the last error of an indexer
+type lastError struct {
+	sync.Mutex
+	err error
+	at  time.Time
+}
+
+// record keeps err unless it is nil, returning it as it is
+func (e *lastError) record(err error) error {
+	if err != nil {
+		e.Lock()
+		e.err, e.at = err, time.Now()
+		e.Unlock()
+	}
+	return err
+}
+
+func (i *impl) Status(ctx context.Context) (*Status, error) {
+	ret := &Status{ChainID: i.store.ChainID()}
+	i.lastErr.Lock()
+	if i.lastErr.err != nil {
+		at := i.lastErr.at
+		ret.LastError, ret.LastErrorAt = i.lastErr.err.Error(), &at
+	}
+	i.lastErr.Unlock()
+
+	var err error
+	if ret.LatestIndexed, err = i.store.LatestNum(ctx); err != nil {
+		return nil, err
+	}
+	if ret.ContiguousHead, err = i.store.ContiguousHead(ctx); err != nil {
+		return nil, err
+	}
+	block, err := i.store.GetBlockByNumber(ctx, ret.LatestIndexed)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, err
+	} else if err == nil {
+		ret.LagSeconds = time.Now().Unix() - int64(block.Time)
+	}
+
+	// the progress in DB is still reported without the chain head
+	head, err := i.ethClient.GetCurrentNumber(ctx)
+	if err != nil {
+		return ret, fmt.Errorf("failed to get current block number: %w", err)
+	}
+	ret.ChainHead = head
+	if head > ret.LatestIndexed {
+		ret.LagBlocks = head - ret.LatestIndexed
+	}
+	return ret, nil
+}
+
+func (i *impl) Ready(ctx context.Context) ([]Check, bool) {
+	checks := []Check{{Name: "db"}, {Name: "schema"}, {Name: "rpc"}}
+	errs := []error{}
+	_, err := i.store.LatestNum(ctx)
+	errs = append(errs, err)
+	// the schema cannot be checked without the DB
+	if err == nil {
+		err = i.store.CheckSchema(ctx)
+	}
+	errs = append(errs, err)
+	_, err = i.ethClient.GetCurrentNumber(ctx)
+	errs = append(errs, err)
+
+	ready := true
+	for j, err := range errs {
+		checks[j].OK = err == nil
+		if err != nil {
+			checks[j].Error = err.Error()
+			ready = false
+		}
+	}
+	return checks, ready
+}
@@ -0,0 +1,41 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+)
+
+// indexTokens stores metadata of ERC-20 tokens first seen in block
+func (i *impl) indexTokens(ctx context.Context, block *eth.Block) error {
+	seen := map[string]bool{}
+	for _, t := range block.Transactions {
+		for _, l := range t.Logs {
+			if !eth.IsERC20Transfer(&l) || seen[l.Address] {
+				continue
+			}
+			seen[l.Address] = true
+			if _, ok := i.tokens.Load(l.Address); ok {
+				continue
+			}
+
+			_, err := i.store.GetToken(ctx, l.Address)
+			if errors.Is(err, db.ErrNotFound) {
+				token, err := i.ethClient.GetToken(ctx, l.Address)
+				if err != nil {
+					return fmt.Errorf("failed to get token %s: %v", l.Address, err)
+				}
+				if err := i.store.SaveToken(ctx, token); err != nil {
+					return err
+				}
+			} else if err != nil {
+				return err
+			}
+			i.tokens.Store(l.Address, true)
+		}
+	}
+	return nil
+}
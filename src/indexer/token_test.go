@@ -0,0 +1,53 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/r04922101/portto/eth"
+)
+
+// transfer creates a transaction emitting an ERC-20 `Transfer` event of token
+func transfer(h, token string) eth.Transaction {
+	topic := "0x0000000000000000000000001111111111111111111111111111111111111111"
+	return eth.Transaction{
+		Hash: h,
+		To:   token,
+		Logs: []eth.Log{{Address: token, Topics: eth.Topics{eth.TransferTopic, topic, topic}, Data: "0x"}},
+	}
+}
+
+func TestIndexTokens(t *testing.T) {
+	const (
+		wbnb    = "0xae13d989daC2f0dEbFf460aC112a837C89BAa7cd"
+		unnamed = "0x3333333333333333333333333333333333333333"
+	)
+	client := newChain(1, 1)
+	client.AddToken(&eth.Token{Address: wbnb, Name: "Wrapped BNB", Symbol: "WBNB", Decimals: 18, TotalSupply: "1000"})
+	client.AddBlock(newBlock(2, "", transfer(hash("tx", 1, ""), wbnb), transfer(hash("tx", 2, ""), unnamed)))
+	i, store := newTestIndexer(t, client, Config{})
+
+	if err := i.IndexBlockByNum(context.Background(), 2); err != nil {
+		t.Fatalf("failed to index block: %v", err)
+	}
+	token, err := store.GetToken(context.Background(), wbnb)
+	if err != nil {
+		t.Fatalf("failed to get token: %v", err)
+	}
+	if token.Name != "Wrapped BNB" || token.Symbol != "WBNB" || token.Decimals != 18 || token.TotalSupply != "1000" {
+		t.Errorf("token = %+v", token)
+	}
+	// tokens without metadata are stored with empty fields
+	if token, err := store.GetToken(context.Background(), unnamed); err != nil || token.Name != "" {
+		t.Errorf("token without metadata = %+v, %v", token, err)
+	}
+
+	// stored tokens are not fetched again
+	client.Fail(eth.ErrUnavailable)
+	if err := i.indexTokens(context.Background(), newBlock(3, "", transfer(hash("tx", 3, ""), wbnb))); err != nil {
+		t.Errorf("fetched stored token: %v", err)
+	}
+	if err := i.indexTokens(context.Background(), newBlock(3, "", transfer(hash("tx", 3, ""), "0x4444444444444444444444444444444444444444"))); err == nil {
+		t.Error("indexed token from an unavailable RPC endpoint")
+	}
+}
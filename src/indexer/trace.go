@@ -0,0 +1,28 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/r04922101/portto/eth"
+)
+
+// indexInternalTransactions stores calls made by contracts in block,
+// and disables itself once the node turns out not to serve the debug API
+func (i *impl) indexInternalTransactions(ctx context.Context, block *eth.Block) error {
+	if atomic.LoadInt32(&i.tracingDisabled) == 1 || len(block.Transactions) == 0 {
+		return nil
+	}
+
+	internals, err := i.ethClient.TraceBlock(ctx, block)
+	if errors.Is(err, eth.ErrTracingNotSupported) {
+		if atomic.CompareAndSwapInt32(&i.tracingDisabled, 0, 1) {
+			i.log.Warn("tracer is disabled since the RPC endpoint does not support debug_traceBlockByNumber")
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return i.store.SaveInternalTransactions(ctx, internals)
+}
@@ -0,0 +1,44 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/r04922101/portto/eth"
+)
+
+func TestIndexInternalTransactions(t *testing.T) {
+	tx := hash("tx", 1, "")
+	client := newChain(1, 1)
+	client.AddBlock(newBlock(2, "", eth.Transaction{Hash: tx}))
+	client.SetTraces(2, []eth.InternalTransaction{{TransactionHash: tx, BlockNum: 2, Type: "CALL", Depth: 1}})
+	i, store := newTestIndexer(t, client, Config{TraceInternal: true})
+
+	if err := i.IndexBlockByNum(context.Background(), 2); err != nil {
+		t.Fatalf("failed to index block: %v", err)
+	}
+	internals, err := store.ListInternalTransactionsByHash(context.Background(), tx)
+	if err != nil || len(internals) != 1 {
+		t.Errorf("internal transactions = %v, %v", internals, err)
+	}
+}
+
+func TestIndexInternalTransactionsNotSupported(t *testing.T) {
+	// the client does not support tracing without traces
+	client := newChain(1, 1)
+	client.AddBlock(newBlock(2, "", eth.Transaction{Hash: hash("tx", 1, "")}))
+	i, _ := newTestIndexer(t, client, Config{TraceInternal: true})
+
+	if err := i.indexInternalTransactions(context.Background(), newBlock(2, "", eth.Transaction{Hash: hash("tx", 1, "")})); err != nil {
+		t.Fatalf("failed to index internal transactions: %v", err)
+	}
+	if i.tracingDisabled != 1 {
+		t.Error("tracer is not disabled")
+	}
+
+	// once disabled, the stage does not call the RPC endpoint
+	client.Fail(eth.ErrUnavailable)
+	if err := i.indexInternalTransactions(context.Background(), newBlock(2, "", eth.Transaction{Hash: hash("tx", 1, "")})); err != nil {
+		t.Errorf("disabled tracer failed: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Package logging sets up the leveled, structured logger of go-ethereum shared by other packages,
+// which logs a message with key-value pairs of fields, e.g. `Root().Info("indexed block", "chain", 97, "block", 100)`
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// supported formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Logger logs messages at levels with fields, and creates child loggers with more fields by New
+type Logger = log.Logger
+
+func init() {
+	// logs before Setup, e.g. of loading config, are written in text at info level
+	if err := Setup("info", FormatText); err != nil {
+		panic(err)
+	}
+}
+
+// Setup makes the root logger, of which every logger is a child, write records at level or above to stderr in format,
+// where level is debug, info, warn or error, and format is text or json
+func Setup(level, format string) error {
+	lvl, err := log.LvlFromString(level)
+	if err != nil {
+		return fmt.Errorf("bad log level %q", level)
+	}
+	var f log.Format
+	switch format {
+	case FormatText:
+		f = log.TerminalFormat(false)
+	case FormatJSON:
+		f = log.JSONFormat()
+	default:
+		return fmt.Errorf("unsupported log format %q", format)
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(lvl, log.StreamHandler(os.Stderr, f)))
+	return nil
+}
+
+// Root returns the root logger
+func Root() Logger {
+	return log.Root()
+}
+
+// New returns a child logger of the root one with fields
+func New(fields ...interface{}) Logger {
+	return log.New(fields...)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying logger, e.g. one with the ID of a request
+func NewContext(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, the root logger if there is none
+func FromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(contextKey{}).(Logger); ok {
+		return l
+	}
+	return log.Root()
+}
@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// capture returns what the root logger set up with level and format writes to stderr by log
+func capture(t *testing.T, level, format string, log func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() {
+		os.Stderr = stderr
+		if err := Setup("info", FormatText); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if err := Setup(level, format); err != nil {
+		t.Fatalf("failed to set up %s logs at %s: %v", format, level, err)
+	}
+	log()
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		level, format string
+	}{
+		{"verbose", FormatText},
+		{"info", "xml"},
+	}
+	for _, tt := range tests {
+		if err := Setup(tt.level, tt.format); err == nil {
+			t.Errorf("Setup(%q, %q) succeeded", tt.level, tt.format)
+		}
+	}
+
+	out := capture(t, "warn", FormatJSON, func() {
+		Root().Info("dropped")
+		New("chain", 97).Warn("block is reorganized", "block", 100)
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("logs = %q, want a record at warn", out)
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("failed to decode %q: %v", lines[0], err)
+	}
+	if record["msg"] != "block is reorganized" || record["lvl"] != "warn" || record["chain"] != float64(97) || record["block"] != float64(100) {
+		t.Errorf("record = %v", record)
+	}
+
+	out = capture(t, "debug", FormatText, func() {
+		Root().Debug("indexed block", "block", 100)
+	})
+	if !strings.Contains(out, "indexed block") || !strings.Contains(out, "block=100") {
+		t.Errorf("logs = %q, want a text record at debug", out)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if FromContext(context.Background()) != Root() {
+		t.Error("logger of a context without one is not the root")
+	}
+	logger := New("request_id", "abc")
+	if FromContext(NewContext(context.Background(), logger)) != logger {
+		t.Error("logger of a context is not the one it carries")
+	}
+}
@@ -0,0 +1,119 @@
+// Package metrics defines Prometheus metrics of the api server, indexer, DB and RPC client,
+// which are registered to the default registry served by Handler
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "portto"
+
+var (
+	// HTTPRequests counts requests to the api server by route, method and status
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "http", Name: "requests_total",
+		Help: "# of HTTP requests by route, method and status",
+	}, []string{"route", "method", "status"})
+	// HTTPDuration observes latencies of requests to the api server by route and method
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "http", Name: "request_duration_seconds",
+		Help:    "Latency of HTTP requests by route and method",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// RateLimited counts requests to the api server rejected by the limit of an IP or API key, or the daily quota of a key
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "http", Name: "rate_limited_total",
+		Help: "# of HTTP requests rejected by limit, ip, key or quota",
+	}, []string{"limit"})
+	// CacheLookups counts lookups of cached block and transaction responses by kind, block or tx, and result, hit or miss
+	CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "http", Name: "cache_lookups_total",
+		Help: "# of lookups of cached responses by kind and result",
+	}, []string{"kind", "result"})
+
+	// IndexedBlocks counts blocks written by indexers, whose rate is the indexing throughput in blocks/sec
+	IndexedBlocks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "indexer", Name: "blocks_indexed_total",
+		Help: "# of indexed blocks by chain",
+	}, []string{"chain"})
+	// StageDuration observes durations of each step indexing a block, i.e. fetch, decode, write and the stages after it
+	StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "indexer", Name: "stage_duration_seconds",
+		Help:    "Duration of each step indexing a block by chain and stage",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"chain", "stage"})
+	// StageErrors counts failed steps indexing blocks
+	StageErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "indexer", Name: "stage_errors_total",
+		Help: "# of failed steps indexing blocks by chain and stage",
+	}, []string{"chain", "stage"})
+	// Reorgs counts indexed blocks replaced by blocks of the same number but different hashes
+	Reorgs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "indexer", Name: "reorgs_total",
+		Help: "# of indexed blocks replaced by blocks of different hashes by chain",
+	}, []string{"chain"})
+	// ChainHead is the latest block number of the RPC endpoint seen by indexers
+	ChainHead = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "indexer", Name: "chain_head",
+		Help: "Latest block number of the RPC endpoint by chain",
+	}, []string{"chain"})
+	// LatestIndexed is the largest block number written by indexers
+	LatestIndexed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "indexer", Name: "latest_indexed_block",
+		Help: "Largest indexed block number by chain",
+	}, []string{"chain"})
+	// LagBlocks is the # of blocks the largest indexed block is behind the chain head
+	LagBlocks = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "indexer", Name: "lag_blocks",
+		Help: "# of blocks the largest indexed block is behind the chain head by chain",
+	}, []string{"chain"})
+
+	// DBWriteDuration observes latencies of DB writes by operation
+	DBWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "db", Name: "write_duration_seconds",
+		Help:    "Latency of DB writes by chain and operation",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"chain", "operation"})
+	// DBConnectRetries counts retries connecting to DBs
+	DBConnectRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "db", Name: "connect_retries_total",
+		Help: "# of retries connecting to DBs",
+	})
+
+	// RPCRequests, RPCErrors and RPCDuration count, count failures of, and observe latencies of eth.Client calls
+	RPCRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "rpc", Name: "requests_total",
+		Help: "# of RPC client calls by chain and method",
+	}, []string{"chain", "method"})
+	RPCErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "rpc", Name: "errors_total",
+		Help: "# of failed RPC client calls by chain and method",
+	}, []string{"chain", "method"})
+	RPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "rpc", Name: "request_duration_seconds",
+		Help:    "Latency of RPC client calls by chain and method",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"chain", "method"})
+)
+
+// Chain returns the label of a chain ID
+func Chain(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
+
+// Since returns the seconds passed since start, to be observed by histograms
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// Handler serves metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestChain(t *testing.T) {
+	if got := Chain(97); got != "97" {
+		t.Errorf("Chain(97) = %q, want 97", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	IndexedBlocks.WithLabelValues("test").Add(3)
+	if got := testutil.ToFloat64(IndexedBlocks.WithLabelValues("test")); got != 3 {
+		t.Errorf("indexed blocks = %v, want 3", got)
+	}
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", w.Code)
+	}
+	if want := `portto_indexer_blocks_indexed_total{chain="test"} 3`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("metrics miss %s", want)
+	}
+}
@@ -0,0 +1,120 @@
+package registry
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/r04922101/portto/eth"
+)
+
+// argName names unnamed arguments by their position
+func argName(arg abi.Argument, i int) string {
+	if arg.Name != "" {
+		return arg.Name
+	}
+	return fmt.Sprintf("arg%d", i)
+}
+
+// decodeArguments decodes ABI encoded data into named arguments
+func decodeArguments(args abi.Arguments, data []byte) (eth.Args, error) {
+	values, err := args.UnpackValues(data)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(eth.Args, len(values))
+	for i, v := range values {
+		ret[argName(args[i], i)] = normalize(v)
+	}
+	return ret, nil
+}
+
+// decodeEvent decodes indexed arguments from topics and the others from data
+func decodeEvent(event *abi.Event, topics []string, data []byte) (eth.Args, error) {
+	ret := make(eth.Args, len(event.Inputs))
+
+	nonIndexed := event.Inputs.NonIndexed()
+	values, err := nonIndexed.UnpackValues(data)
+	if err != nil {
+		return nil, err
+	}
+
+	j, k := 0, 0
+	for i, arg := range event.Inputs {
+		name := argName(arg, i)
+		if !arg.Indexed {
+			ret[name] = normalize(values[j])
+			j++
+			continue
+		}
+
+		if k >= len(topics) {
+			return nil, fmt.Errorf("missing topic of indexed argument %s", name)
+		}
+		topic := common.HexToHash(topics[k])
+		k++
+		switch arg.Type.T {
+		case abi.StringTy, abi.BytesTy, abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+			// only the keccak256 hash of dynamic values is logged
+			ret[name] = topic.Hex()
+		default:
+			v, err := abi.Arguments{{Type: arg.Type}}.UnpackValues(topic.Bytes())
+			if err != nil {
+				return nil, fmt.Errorf("failed to unpack indexed argument %s: %v", name, err)
+			}
+			ret[name] = normalize(v[0])
+		}
+	}
+	return ret, nil
+}
+
+var (
+	bigIntType  = reflect.TypeOf(&big.Int{})
+	addressType = reflect.TypeOf(common.Address{})
+)
+
+// normalize converts an unpacked ABI value to a JSON friendly one,
+// rendering integers as decimal strings so that no precision is lost
+func normalize(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Type() == bigIntType:
+		return v.(*big.Int).String()
+	case rv.Type() == addressType:
+		return v.(common.Address).Hex()
+	}
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprintf("%d", v)
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			// bytes and fixed bytes
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return hexutil.Encode(b)
+		}
+		ret := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			ret[i] = normalize(rv.Index(i).Interface())
+		}
+		return ret
+	case reflect.Struct:
+		// tuples are unpacked into anonymous structs tagged with argument names
+		ret := make(map[string]interface{}, rv.NumField())
+		for i := 0; i < rv.NumField(); i++ {
+			f := rv.Type().Field(i)
+			name := f.Tag.Get("json")
+			if name == "" {
+				name = f.Name
+			}
+			ret[name] = normalize(rv.Field(i).Interface())
+		}
+		return ret
+	}
+	return v
+}
@@ -0,0 +1,291 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+	"github.com/r04922101/portto/logging"
+)
+
+// Registry defines an interface holding contract ABIs, which decodes logs and transaction inputs
+type Registry interface {
+	// Register stores the ABI in JSON of the contract at address
+	Register(address string, abiJSON []byte) error
+	// LoadDir registers every `<address>.json` ABI file in dir
+	LoadDir(dir string) error
+	// Get returns the ABI in JSON registered for the contract at address
+	Get(address string) (string, bool)
+	// DecodeLog fills event name and decoded arguments of l if its contract is registered
+	DecodeLog(l *eth.Log) error
+	// DecodeInput fills method name and decoded arguments of tx with the ABI of its contract,
+	// or with known signatures sharing the selector
+	DecodeInput(tx *eth.Transaction) error
+	// LoadSignatures adds text signatures listed in a file to known signatures
+	LoadSignatures(path string) error
+	// LookupSelector returns known text signatures of a 4-byte selector in hex
+	LookupSelector(selector string) []string
+}
+
+// reloadInterval is how often ABIs are reloaded from the store, which picks up ones registered by other processes,
+// e.g. uploaded via the API while the indexer runs
+var reloadInterval = time.Minute
+
+type contract struct {
+	raw string
+	abi abi.ABI
+}
+
+type impl struct {
+	store db.Store
+
+	mu         sync.RWMutex
+	contracts  map[string]*contract
+	signatures signatures
+	// loadedAt is when ABIs were loaded from the store last
+	loadedAt time.Time
+	// reloading is 1 while ABIs are reloaded, which other lookups do not wait for
+	reloading int32
+}
+
+// parseABI parses an ABI in JSON, and returns it with the compacted JSON
+func parseABI(abiJSON []byte) (*abi.ABI, []byte, error) {
+	// accept compiler artifacts wrapping the ABI, e.g. hardhat and truffle
+	var artifact struct {
+		ABI json.RawMessage `json:"abi"`
+	}
+	if err := json.Unmarshal(abiJSON, &artifact); err == nil && len(artifact.ABI) > 0 {
+		abiJSON = artifact.ABI
+	}
+
+	a, err := abi.JSON(bytes.NewReader(abiJSON))
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, abiJSON); err != nil {
+		return nil, nil, err
+	}
+	return &a, buf.Bytes(), nil
+}
+
+func (r *impl) Register(address string, abiJSON []byte) error {
+	if !common.IsHexAddress(address) {
+		return fmt.Errorf("invalid contract address %s", address)
+	}
+	a, raw, err := parseABI(abiJSON)
+	if err != nil {
+		return fmt.Errorf("failed to parse ABI of %s: %v", address, err)
+	}
+
+	c := &eth.ContractABI{
+		Address: common.HexToAddress(address).Hex(),
+		ABI:     string(raw),
+	}
+	if err := r.store.SaveABI(context.Background(), c); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.contracts[c.Address] = &contract{raw: c.ABI, abi: *a}
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *impl) LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list ABI files in %s: %v", dir, err)
+	}
+	for _, f := range files {
+		address := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+		abiJSON, err := ioutil.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("failed to read ABI file %s: %v", f, err)
+		}
+		if err := r.Register(address, abiJSON); err != nil {
+			return err
+		}
+	}
+	logging.Root().Info("registered ABIs", "count", len(files), "dir", dir)
+	return nil
+}
+
+// load adds ABIs in the store, replacing registered ones which differ
+func (r *impl) load(ctx context.Context) error {
+	abis, err := r.store.ListABIs(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	loaded := make(map[string]*contract, len(abis))
+	for _, c := range abis {
+		if existing, ok := r.contracts[c.Address]; !ok || existing.raw != c.ABI {
+			loaded[c.Address] = nil
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, c := range abis {
+		if _, ok := loaded[c.Address]; !ok {
+			continue
+		}
+		a, _, err := parseABI([]byte(c.ABI))
+		if err != nil {
+			logging.Root().Warn("skipped invalid ABI", "chain", r.store.ChainID(), "address", c.Address, "err", err)
+			delete(loaded, c.Address)
+			continue
+		}
+		loaded[c.Address] = &contract{raw: c.ABI, abi: *a}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for address, c := range loaded {
+		r.contracts[address] = c
+	}
+	r.loadedAt = time.Now()
+	return nil
+}
+
+// reload loads ABIs from the store if they were loaded over reloadInterval ago, unless another lookup does
+func (r *impl) reload() {
+	r.mu.RLock()
+	stale := time.Since(r.loadedAt) > reloadInterval
+	r.mu.RUnlock()
+	if !stale || !atomic.CompareAndSwapInt32(&r.reloading, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&r.reloading, 0)
+
+	if err := r.load(context.Background()); err != nil {
+		// registered ABIs are kept until the next reload
+		logging.Root().Warn("failed to reload ABIs", "chain", r.store.ChainID(), "err", err)
+		r.mu.Lock()
+		r.loadedAt = time.Now()
+		r.mu.Unlock()
+	}
+}
+
+func (r *impl) get(address string) (*contract, bool) {
+	r.reload()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.contracts[address]
+	return c, ok
+}
+
+func (r *impl) Get(address string) (string, bool) {
+	c, ok := r.get(common.HexToAddress(address).Hex())
+	if !ok {
+		return "", false
+	}
+	return c.raw, true
+}
+
+func (r *impl) DecodeLog(l *eth.Log) error {
+	c, ok := r.get(l.Address)
+	if !ok || len(l.Topics) == 0 {
+		return nil
+	}
+	event, err := c.abi.EventByID(common.HexToHash(l.Topics[0]))
+	if err != nil {
+		// anonymous or unknown event
+		return nil
+	}
+
+	args, err := decodeEvent(event, l.Topics[1:], common.FromHex(l.Data))
+	if err != nil {
+		return fmt.Errorf("failed to decode event %s of %s: %v", event.Name, l.Address, err)
+	}
+	l.Event = event.Sig
+	l.Decoded = args
+	return nil
+}
+
+func (r *impl) DecodeInput(tx *eth.Transaction) error {
+	data := common.FromHex(tx.Data)
+	if len(data) < 4 {
+		return nil
+	}
+
+	// prefer the ABI registered for the called contract
+	if c, ok := r.get(tx.To); ok {
+		if method, err := c.abi.MethodById(data[:4]); err == nil {
+			args, err := decodeArguments(method.Inputs, data[4:])
+			if err != nil {
+				return fmt.Errorf("failed to decode method %s of %s: %v", method.Name, tx.To, err)
+			}
+			tx.Method = method.Sig
+			tx.DecodedInput = args
+			return nil
+		}
+	}
+
+	// fall back to known signatures, taking the first one able to decode the arguments
+	r.mu.RLock()
+	methods := r.signatures[hexutil.Encode(data[:4])]
+	r.mu.RUnlock()
+	for _, method := range methods {
+		if args, err := decodeArguments(method.Inputs, data[4:]); err == nil {
+			tx.Method = method.Sig
+			tx.DecodedInput = args
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *impl) LoadSignatures(path string) error {
+	sigs, err := readSignatures(path)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, sig := range sigs {
+		if err := r.signatures.add(sig); err != nil {
+			logging.Root().Warn("skipped signature", "err", err)
+		}
+	}
+	logging.Root().Info("loaded signatures", "count", len(sigs), "path", path)
+	return nil
+}
+
+func (r *impl) LookupSelector(selector string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	methods := r.signatures[strings.ToLower(selector)]
+	ret := make([]string, len(methods))
+	for i, m := range methods {
+		ret[i] = m.Sig
+	}
+	return ret
+}
+
+// New creates a registry with ABIs in store, which reloads them every reloadInterval
+func New(store db.Store) (Registry, error) {
+	r := &impl{
+		store:      store,
+		contracts:  map[string]*contract{},
+		signatures: newSignatures(),
+	}
+	if err := r.load(context.Background()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
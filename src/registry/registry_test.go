@@ -0,0 +1,188 @@
+package registry
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+)
+
+const (
+	token = "0xae13d989daC2f0dEbFf460aC112a837C89BAa7cd"
+	alice = "0x1111111111111111111111111111111111111111"
+	bob   = "0x2222222222222222222222222222222222222222"
+
+	erc20ABI = `[
+		{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+		{"type":"event","name":"Transfer","anonymous":false,"inputs":[{"name":"from","type":"address","indexed":true},{"name":"to","type":"address","indexed":true},{"name":"value","type":"uint256","indexed":false}]}
+	]`
+
+	transferSelector = "0xa9059cbb"
+	transferTopic    = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	// transferInput is transfer(bob, 1000)
+	transferInput = transferSelector +
+		"0000000000000000000000002222222222222222222222222222222222222222" +
+		"00000000000000000000000000000000000000000000000000000000000003e8"
+)
+
+func topic(address string) string {
+	return "0x000000000000000000000000" + address[2:]
+}
+
+func newRegistry(t *testing.T, store db.Store) Registry {
+	t.Helper()
+	r, err := New(store)
+	if err != nil {
+		t.Fatalf("failed to create registry: %v", err)
+	}
+	return r
+}
+
+func TestRegister(t *testing.T) {
+	r := newRegistry(t, db.NewMemoryStore(97))
+
+	if err := r.Register("0x1234", []byte(erc20ABI)); err == nil {
+		t.Error("registered ABI of invalid address")
+	}
+	if err := r.Register(token, []byte(`[{"type":"function"`)); err == nil {
+		t.Error("registered invalid ABI")
+	}
+
+	// compiler artifacts wrap ABIs
+	if err := r.Register(token, []byte(`{"contractName":"WBNB","abi":`+erc20ABI+`}`)); err != nil {
+		t.Fatalf("failed to register ABI: %v", err)
+	}
+	got, ok := r.Get("0xae13d989dac2f0debff460ac112a837c89baa7cd")
+	if !ok {
+		t.Fatal("ABI is not registered")
+	}
+	if _, _, err := parseABI([]byte(got)); err != nil {
+		t.Errorf("registered ABI %s is invalid: %v", got, err)
+	}
+	if _, ok := r.Get(alice); ok {
+		t.Error("got ABI of unregistered contract")
+	}
+}
+
+func TestDecodeLog(t *testing.T) {
+	r := newRegistry(t, db.NewMemoryStore(97))
+	if err := r.Register(token, []byte(erc20ABI)); err != nil {
+		t.Fatalf("failed to register ABI: %v", err)
+	}
+
+	l := &eth.Log{
+		Address: token,
+		Topics:  eth.Topics{transferTopic, topic(alice), topic(bob)},
+		Data:    "0x00000000000000000000000000000000000000000000000000000000000003e8",
+	}
+	if err := r.DecodeLog(l); err != nil {
+		t.Fatalf("failed to decode log: %v", err)
+	}
+	if l.Event != "Transfer(address,address,uint256)" {
+		t.Errorf("event = %s", l.Event)
+	}
+	want := eth.Args{"from": alice, "to": bob, "value": "1000"}
+	if !reflect.DeepEqual(l.Decoded, want) {
+		t.Errorf("decoded = %v, want %v", l.Decoded, want)
+	}
+
+	unknown := &eth.Log{Address: alice, Topics: eth.Topics{transferTopic}}
+	if err := r.DecodeLog(unknown); err != nil || unknown.Event != "" {
+		t.Errorf("decoded log of unregistered contract: %s, %v", unknown.Event, err)
+	}
+}
+
+func TestDecodeInput(t *testing.T) {
+	r := newRegistry(t, db.NewMemoryStore(97))
+
+	// unregistered contracts are decoded with known signatures, which name arguments by position
+	tx := &eth.Transaction{To: token, Data: transferInput}
+	if err := r.DecodeInput(tx); err != nil {
+		t.Fatalf("failed to decode input: %v", err)
+	}
+	if tx.Method != "transfer(address,uint256)" {
+		t.Errorf("method = %s", tx.Method)
+	}
+	if want := (eth.Args{"arg0": bob, "arg1": "1000"}); !reflect.DeepEqual(tx.DecodedInput, want) {
+		t.Errorf("decoded input = %v, want %v", tx.DecodedInput, want)
+	}
+
+	if err := r.Register(token, []byte(erc20ABI)); err != nil {
+		t.Fatalf("failed to register ABI: %v", err)
+	}
+	tx = &eth.Transaction{To: token, Data: transferInput}
+	if err := r.DecodeInput(tx); err != nil {
+		t.Fatalf("failed to decode input: %v", err)
+	}
+	if want := (eth.Args{"to": bob, "amount": "1000"}); !reflect.DeepEqual(tx.DecodedInput, want) {
+		t.Errorf("decoded input = %v, want %v", tx.DecodedInput, want)
+	}
+
+	for _, data := range []string{"0x", "0xa9059c", "0xdeadbeef"} {
+		tx := &eth.Transaction{To: alice, Data: data}
+		if err := r.DecodeInput(tx); err != nil || tx.Method != "" {
+			t.Errorf("decoded input %s: %s, %v", data, tx.Method, err)
+		}
+	}
+}
+
+func TestLoadSignatures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signatures.txt")
+	content := "# exported from 4byte.directory\n\n0x12345678 foo(uint256, (address,bytes)[])\nbar()\nbaz(\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRegistry(t, db.NewMemoryStore(97))
+	if err := r.LoadSignatures(path); err != nil {
+		t.Fatalf("failed to load signatures: %v", err)
+	}
+	if got := r.LookupSelector(selector("foo(uint256,(address,bytes)[])")); !reflect.DeepEqual(got, []string{"foo(uint256,(address,bytes)[])"}) {
+		t.Errorf("signatures of foo = %v", got)
+	}
+	if got := r.LookupSelector(selector("bar()")); !reflect.DeepEqual(got, []string{"bar()"}) {
+		t.Errorf("signatures of bar = %v", got)
+	}
+	if got := r.LookupSelector("0xA9059CBB"); !reflect.DeepEqual(got, []string{"transfer(address,uint256)"}) {
+		t.Errorf("signatures of transfer = %v", got)
+	}
+	if got := r.LookupSelector("0x00000000"); len(got) != 0 {
+		t.Errorf("signatures of unknown selector = %v", got)
+	}
+}
+
+func TestReload(t *testing.T) {
+	defer func(d time.Duration) { reloadInterval = d }(reloadInterval)
+	reloadInterval = time.Hour
+
+	// e.g. the API server registering an ABI the indexer decodes with
+	store := db.NewMemoryStore(97)
+	indexer := newRegistry(t, store)
+	if err := newRegistry(t, store).Register(token, []byte(erc20ABI)); err != nil {
+		t.Fatalf("failed to register ABI: %v", err)
+	}
+	if _, ok := indexer.Get(token); ok {
+		t.Fatal("ABI is reloaded before reloadInterval")
+	}
+
+	reloadInterval = 0
+	if _, ok := indexer.Get(token); !ok {
+		t.Fatal("ABI is not reloaded after reloadInterval")
+	}
+
+	// ABIs failing to parse are skipped, keeping ones registered
+	if err := store.SaveABI(context.Background(), &eth.ContractABI{Address: alice, ABI: "invalid"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := indexer.Get(alice); ok {
+		t.Error("invalid ABI is reloaded")
+	}
+	if _, ok := indexer.Get(token); !ok {
+		t.Error("ABI is dropped by reload")
+	}
+}
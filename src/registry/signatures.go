@@ -0,0 +1,194 @@
+package registry
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// builtinSignatures are well known methods of token standards and popular contracts
+var builtinSignatures = []string{
+	// ERC-20
+	"transfer(address,uint256)",
+	"transferFrom(address,address,uint256)",
+	"approve(address,uint256)",
+	"increaseAllowance(address,uint256)",
+	"decreaseAllowance(address,uint256)",
+	"mint(address,uint256)",
+	"burn(uint256)",
+	"burnFrom(address,uint256)",
+	// WETH
+	"deposit()",
+	"withdraw(uint256)",
+	// ERC-721
+	"safeTransferFrom(address,address,uint256)",
+	"safeTransferFrom(address,address,uint256,bytes)",
+	"setApprovalForAll(address,bool)",
+	// ERC-1155
+	"safeTransferFrom(address,address,uint256,uint256,bytes)",
+	"safeBatchTransferFrom(address,address,uint256[],uint256[],bytes)",
+	// Ownable
+	"transferOwnership(address)",
+	"renounceOwnership()",
+	// Uniswap V2 and forks, e.g. PancakeSwap
+	"addLiquidity(address,address,uint256,uint256,uint256,uint256,address,uint256)",
+	"addLiquidityETH(address,uint256,uint256,uint256,address,uint256)",
+	"removeLiquidity(address,address,uint256,uint256,uint256,address,uint256)",
+	"removeLiquidityETH(address,uint256,uint256,uint256,address,uint256)",
+	"swapExactTokensForTokens(uint256,uint256,address[],address,uint256)",
+	"swapTokensForExactTokens(uint256,uint256,address[],address,uint256)",
+	"swapExactETHForTokens(uint256,address[],address,uint256)",
+	"swapTokensForExactETH(uint256,uint256,address[],address,uint256)",
+	"swapExactTokensForETH(uint256,uint256,address[],address,uint256)",
+	"swapETHForExactTokens(uint256,address[],address,uint256)",
+	"swapExactTokensForTokensSupportingFeeOnTransferTokens(uint256,uint256,address[],address,uint256)",
+	"swapExactETHForTokensSupportingFeeOnTransferTokens(uint256,address[],address,uint256)",
+	"swapExactTokensForETHSupportingFeeOnTransferTokens(uint256,uint256,address[],address,uint256)",
+	// Multicall
+	"aggregate((address,bytes)[])",
+	"multicall(bytes[])",
+	"multicall(uint256,bytes[])",
+}
+
+// signatures maps 4-byte selectors in hex to methods sharing them
+type signatures map[string][]*abi.Method
+
+// selector returns the 4-byte selector in hex of a text signature
+func selector(sig string) string {
+	return hexutil.Encode(crypto.Keccak256([]byte(sig))[:4])
+}
+
+func (s signatures) add(sig string) error {
+	method, err := parseSignature(strings.ReplaceAll(sig, " ", ""))
+	if err != nil {
+		return fmt.Errorf("invalid signature %s: %v", sig, err)
+	}
+	sel := selector(method.Sig)
+	for _, existing := range s[sel] {
+		if existing.Sig == method.Sig {
+			return nil
+		}
+	}
+	s[sel] = append(s[sel], method)
+	return nil
+}
+
+func newSignatures() signatures {
+	s := signatures{}
+	for _, sig := range builtinSignatures {
+		if err := s.add(sig); err != nil {
+			panic(err)
+		}
+	}
+	return s
+}
+
+// readSignatures reads a signature list file, with a text signature per line
+// optionally prefixed by its selector as in 4byte.directory exports, e.g.
+//
+//	0xa9059cbb transfer(address,uint256)
+//
+// empty lines and lines starting with # are skipped
+func readSignatures(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open signature file %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var ret []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) > 1 && strings.HasPrefix(fields[0], "0x") {
+			line = strings.Join(fields[1:], "")
+		}
+		ret = append(ret, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read signature file %s: %v", path, err)
+	}
+	return ret, nil
+}
+
+// parseSignature builds a method from a text signature such as `transfer(address,uint256)`
+func parseSignature(sig string) (*abi.Method, error) {
+	open := strings.Index(sig, "(")
+	if open <= 0 || !strings.HasSuffix(sig, ")") {
+		return nil, fmt.Errorf("malformed signature")
+	}
+	name := sig[:open]
+
+	types, err := splitTypes(sig[open+1 : len(sig)-1])
+	if err != nil {
+		return nil, err
+	}
+	inputs := make(abi.Arguments, len(types))
+	for i, t := range types {
+		marshaling := toArgumentMarshaling(fmt.Sprintf("arg%d", i), t)
+		typ, err := abi.NewType(marshaling.Type, "", marshaling.Components)
+		if err != nil {
+			return nil, fmt.Errorf("invalid type %s: %v", t, err)
+		}
+		inputs[i] = abi.Argument{Name: marshaling.Name, Type: typ}
+	}
+
+	method := abi.NewMethod(name, name, abi.Function, "", false, false, inputs, nil)
+	return &method, nil
+}
+
+// splitTypes splits a comma separated type list at the top level, keeping tuples intact
+func splitTypes(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var (
+		ret   []string
+		depth int
+		start int
+	)
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses")
+			}
+		case ',':
+			if depth == 0 {
+				ret = append(ret, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses")
+	}
+	return append(ret, s[start:]), nil
+}
+
+// toArgumentMarshaling converts a type such as `(address,bytes)[]` to its ABI JSON form,
+// which names tuple components by their position
+func toArgumentMarshaling(name, t string) abi.ArgumentMarshaling {
+	if !strings.HasPrefix(t, "(") {
+		return abi.ArgumentMarshaling{Name: name, Type: t}
+	}
+
+	end := strings.LastIndex(t, ")")
+	components, _ := splitTypes(t[1:end])
+	ret := abi.ArgumentMarshaling{Name: name, Type: "tuple" + t[end+1:]}
+	for i, c := range components {
+		ret.Components = append(ret.Components, toArgumentMarshaling(fmt.Sprintf("arg%d", i), c))
+	}
+	return ret
+}
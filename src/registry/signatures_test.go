@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/r04922101/portto/db"
+	"github.com/r04922101/portto/eth"
+)
+
+func TestBuiltinSignatures(t *testing.T) {
+	s := newSignatures()
+	for _, sig := range builtinSignatures {
+		found := false
+		for _, m := range s[selector(sig)] {
+			found = found || m.Sig == sig
+		}
+		if !found {
+			t.Errorf("builtin signature %s is not added by its selector", sig)
+		}
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	tests := []struct {
+		sig      string
+		selector string
+		inputs   []string
+	}{
+		{"transfer(address,uint256)", "0xa9059cbb", []string{"address", "uint256"}},
+		{"multicall(bytes[])", "0xac9650d8", []string{"bytes[]"}},
+		{"aggregate((address,bytes)[])", "0x252dba42", []string{"(address,bytes)[]"}},
+		{"deposit()", "0xd0e30db0", nil},
+	}
+	for _, tt := range tests {
+		m, err := parseSignature(tt.sig)
+		if err != nil {
+			t.Errorf("failed to parse %s: %v", tt.sig, err)
+			continue
+		}
+		if m.Sig != tt.sig || selector(m.Sig) != tt.selector {
+			t.Errorf("parsed %s as %s with selector %s, want %s", tt.sig, m.Sig, selector(m.Sig), tt.selector)
+		}
+		var inputs []string
+		for _, in := range m.Inputs {
+			inputs = append(inputs, in.Type.String())
+		}
+		if !reflect.DeepEqual(inputs, tt.inputs) {
+			t.Errorf("inputs of %s = %v, want %v", tt.sig, inputs, tt.inputs)
+		}
+	}
+
+	for _, sig := range []string{"", "transfer", "(address)", "foo(address", "foo(address))", "foo((address)", "foo(notatype)"} {
+		if _, err := parseSignature(sig); err == nil {
+			t.Errorf("parsed malformed signature %q", sig)
+		}
+	}
+}
+
+func TestReadSignatures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signatures.txt")
+	content := "# comment\n\n  transfer(address,uint256)  \n0x095ea7b3 approve(address, uint256)\nnot-a-selector foo()\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readSignatures(path)
+	if err != nil {
+		t.Fatalf("failed to read signatures: %v", err)
+	}
+	want := []string{"transfer(address,uint256)", "approve(address,uint256)", "not-a-selector foo()"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("signatures = %q, want %q", got, want)
+	}
+
+	if _, err := readSignatures(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("read missing signature file")
+	}
+}
+
+func TestDecodeInputCollision(t *testing.T) {
+	// both share the selector 0x42966c68, of which the first one added decoding the arguments is taken
+	first, second := "burn(uint256)", "collate_propagate_storage(bytes16)"
+	if selector(first) != selector(second) {
+		t.Fatalf("%s and %s do not collide", first, second)
+	}
+	s := signatures{}
+	for _, sig := range []string{first, second, first} {
+		if err := s.add(sig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s[selector(first)]) != 2 {
+		t.Fatalf("duplicated signatures are added: %d", len(s[selector(first)]))
+	}
+
+	r := newRegistry(t, db.NewMemoryStore(97)).(*impl)
+	r.signatures = s
+	arg, _ := abi.NewType("uint256", "", nil)
+	data, err := abi.Arguments{{Type: arg}}.Pack(common.Big1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &eth.Transaction{Data: selector(first) + common.Bytes2Hex(data)}
+	if err := r.DecodeInput(tx); err != nil {
+		t.Fatalf("failed to decode input: %v", err)
+	}
+	if tx.Method != first || !reflect.DeepEqual(tx.DecodedInput, eth.Args{"arg0": "1"}) {
+		t.Errorf("decoded %s with %v", tx.Method, tx.DecodedInput)
+	}
+}
@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	recorder     = tracetest.NewInMemoryExporter()
+	recorderOnce sync.Once
+)
+
+// recordSpans records ended spans of the shared tracer, which is bound to the first global provider, from now on
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	recorderOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(recorder)))
+	})
+	recorder.Reset()
+	return recorder
+}
+
+func TestStartEnd(t *testing.T) {
+	spans := recordSpans(t)
+	ctx, parent := Start(context.Background(), "parent", attribute.String("chain.id", "97"))
+	_, child := Start(ctx, "child")
+	End(child, errors.New("boom"))
+	End(parent, nil)
+
+	got := spans.GetSpans()
+	if len(got) != 2 {
+		t.Fatalf("# of spans = %d, want 2", len(got))
+	}
+	c, p := got[0], got[1]
+	if c.Name != "child" || p.Name != "parent" {
+		t.Fatalf("spans = %s, %s, want child and parent", c.Name, p.Name)
+	}
+	if c.Parent.SpanID() != p.SpanContext.SpanID() || c.SpanContext.TraceID() != p.SpanContext.TraceID() {
+		t.Error("child is not in the trace of its parent")
+	}
+	if c.Status.Code != codes.Error || c.Status.Description != "boom" || len(c.Events) != 1 {
+		t.Errorf("child status = %+v with %d events, want an error recorded", c.Status, len(c.Events))
+	}
+	if p.Status.Code != codes.Unset || len(p.Attributes) != 1 || p.Attributes[0].Value.AsString() != "97" {
+		t.Errorf("parent status = %+v, attributes = %v", p.Status, p.Attributes)
+	}
+}
+
+func TestInit(t *testing.T) {
+	// the shared tracer stays bound to the recorder
+	recordSpans(t)
+	provider := otel.GetTracerProvider()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		config Config
+		ok     bool
+		set    bool
+	}{
+		{Config{}, true, false},
+		{Config{Exporter: ExporterNone}, true, false},
+		{Config{Exporter: ExporterStdout, SampleRatio: 1}, true, true},
+		{Config{Exporter: ExporterOTLP, Endpoint: "localhost:4318", Insecure: true}, true, true},
+		{Config{Exporter: "jaeger"}, false, false},
+	}
+	for _, tt := range tests {
+		otel.SetTracerProvider(provider)
+		shutdown, err := Init(ctx, "test", tt.config)
+		if (err == nil) != tt.ok {
+			t.Errorf("Init(%+v) err = %v, want ok = %v", tt.config, err, tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if set := otel.GetTracerProvider() != provider; set != tt.set {
+			t.Errorf("Init(%+v) set the provider = %v, want %v", tt.config, set, tt.set)
+		}
+		sctx, cancel := context.WithTimeout(ctx, time.Second)
+		if err := shutdown(sctx); err != nil {
+			t.Errorf("failed to shut down %+v: %v", tt.config, err)
+		}
+		cancel()
+	}
+}